@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	generativelanguage "cloud.google.com/go/ai/generativelanguage/apiv1"
+	"cloud.google.com/go/ai/generativelanguage/apiv1/generativelanguagepb"
+	"google.golang.org/api/option"
+)
+
+const (
+	defaultGeminiEmbeddingModel = "models/text-embedding-004"
+	defaultGeminiChatModel      = "models/gemini-2.5-flash"
+	defaultEmbeddingDimension   = 768
+)
+
+// Embedder turns text into a dense vector
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// ChatModel generates a text completion for a prompt.
+// GenerateStream calls onToken with each piece of text as it arrives and returns the full response;
+// an error from onToken aborts the stream.
+type ChatModel interface {
+	Generate(ctx context.Context, prompt string) (string, error)
+	GenerateStream(ctx context.Context, prompt string, onToken func(string) error) (string, error)
+}
+
+var (
+	Embeddings Embedder
+	Chat       ChatModel
+)
+
+// initAIModels selects the Embedder (EMBEDDER=gemini|hash) and ChatModel (CHAT_MODEL=gemini|echo).
+// The Gemini client is only created when one of them needs it.
+func initAIModels(ctx context.Context) error {
+	embedderKind := strings.ToLower(envOr("EMBEDDER", "gemini"))
+	chatKind := strings.ToLower(envOr("CHAT_MODEL", "gemini"))
+
+	if embedderKind == "gemini" || chatKind == "gemini" {
+		var err error
+		GeminiClient, err = generativelanguage.NewGenerativeClient(
+			ctx,
+			option.WithAPIKey(os.Getenv("GEMINI_API_KEY")),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to initialize Gemini client: %w", err)
+		}
+	}
+
+	switch embedderKind {
+	case "gemini":
+		Embeddings = &geminiEmbedder{client: GeminiClient, model: envOr("GEMINI_EMBEDDING_MODEL", defaultGeminiEmbeddingModel)}
+	case "hash":
+		dim := defaultEmbeddingDimension
+		if v := os.Getenv("EMBEDDING_DIMENSION"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid EMBEDDING_DIMENSION %q", v)
+			}
+			dim = n
+		}
+		Embeddings = &hashEmbedder{dim: dim}
+	default:
+		return fmt.Errorf("unknown EMBEDDER %q", embedderKind)
+	}
+
+	switch chatKind {
+	case "gemini":
+		Chat = &geminiChatModel{client: GeminiClient, model: envOr("GEMINI_CHAT_MODEL", defaultGeminiChatModel)}
+	case "echo":
+		m, err := newEchoChatModel(os.Getenv("CHAT_ECHO_TEMPLATE"))
+		if err != nil {
+			return err
+		}
+		Chat = m
+	default:
+		return fmt.Errorf("unknown CHAT_MODEL %q", chatKind)
+	}
+
+	return nil
+}
+
+// chatModelFor returns the configured ChatModel switched to the named model of the same provider.
+// Models without named variants ignore name.
+func chatModelFor(name string) ChatModel {
+	if m, ok := Chat.(*geminiChatModel); ok && name != "" && name != m.model {
+		return &geminiChatModel{client: m.client, model: name}
+	}
+	return Chat
+}
+
+// chatModelName names a ChatModel for settings and debug output
+func chatModelName(m ChatModel) string {
+	switch m := m.(type) {
+	case *geminiChatModel:
+		return m.model
+	case *echoChatModel:
+		return "echo"
+	}
+	return ""
+}
+
+// envOr returns the trimmed value of an environment variable or def when unset
+func envOr(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// geminiEmbedder embeds text with a Gemini embedding model
+type geminiEmbedder struct {
+	client *generativelanguage.GenerativeClient
+	model  string
+}
+
+func (e *geminiEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	req := &generativelanguagepb.EmbedContentRequest{
+		Model: e.model,
+		Content: &generativelanguagepb.Content{
+			Parts: []*generativelanguagepb.Part{
+				{
+					Data: &generativelanguagepb.Part_Text{
+						Text: text,
+					},
+				},
+			},
+		},
+	}
+
+	resp, err := e.client.EmbedContent(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get embedding from Gemini: %w", err)
+	}
+
+	return resp.Embedding.Values, nil
+}
+
+// geminiChatModel generates responses with a Gemini chat model
+type geminiChatModel struct {
+	client *generativelanguage.GenerativeClient
+	model  string
+}
+
+func (m *geminiChatModel) request(prompt string) *generativelanguagepb.GenerateContentRequest {
+	return &generativelanguagepb.GenerateContentRequest{
+		Model: m.model,
+		Contents: []*generativelanguagepb.Content{
+			{
+				Parts: []*generativelanguagepb.Part{
+					{
+						Data: &generativelanguagepb.Part_Text{
+							Text: prompt,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (m *geminiChatModel) Generate(ctx context.Context, prompt string) (string, error) {
+	resp, err := m.client.GenerateContent(ctx, m.request(prompt))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate content: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", fmt.Errorf("no content generated")
+	}
+
+	return resp.Candidates[0].Content.Parts[0].GetText(), nil
+}
+
+func (m *geminiChatModel) GenerateStream(ctx context.Context, prompt string, onToken func(string) error) (string, error) {
+	stream, err := m.client.StreamGenerateContent(ctx, m.request(prompt))
+	if err != nil {
+		return "", fmt.Errorf("failed to start content stream: %w", err)
+	}
+
+	var full strings.Builder
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return full.String(), fmt.Errorf("content stream failed: %w", err)
+		}
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			continue
+		}
+		for _, part := range resp.Candidates[0].Content.Parts {
+			text := part.GetText()
+			if text == "" {
+				continue
+			}
+			full.WriteString(text)
+			if err := onToken(text); err != nil {
+				return full.String(), err
+			}
+		}
+	}
+
+	if full.Len() == 0 {
+		return "", fmt.Errorf("no content generated")
+	}
+	return full.String(), nil
+}
+
+// hashEmbedder is a deterministic bag-of-features embedder for offline use.
+// Words, word bigrams and character trigrams are hashed into dim signed buckets
+// and the result is L2-normalised, so texts sharing vocabulary score high under cosine.
+type hashEmbedder struct {
+	dim int
+}
+
+func (e *hashEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vec := make([]float64, e.dim)
+	add := func(feature string, weight float64) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		sign := 1.0
+		if sum>>63 == 1 {
+			sign = -1.0
+		}
+		vec[sum%uint64(e.dim)] += sign * weight
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, w := range words {
+		add("w:"+w, 1.0)
+		if i > 0 {
+			add("b:"+words[i-1]+" "+w, 0.5)
+		}
+		padded := []rune("^" + w + "$")
+		for j := 0; j+3 <= len(padded); j++ {
+			add("c:"+string(padded[j:j+3]), 0.25)
+		}
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	out := make([]float32, e.dim)
+	if norm == 0 {
+		return out, nil
+	}
+	norm = math.Sqrt(norm)
+	for i, v := range vec {
+		out[i] = float32(v / norm)
+	}
+	return out, nil
+}
+
+const defaultEchoTemplate = `You asked: {{.Question}}
+{{if .Knowledge}}Here is what I found:
+{{range .Knowledge}}- {{.}}
+{{end}}{{else}}I have no matching information.
+{{end}}`
+
+// echoChatModel is a deterministic ChatModel for tests and offline runs.
+// It pulls the question and knowledge lines out of the restaurant prompt and renders them with a template.
+type echoChatModel struct {
+	tmpl *template.Template
+}
+
+func newEchoChatModel(text string) (*echoChatModel, error) {
+	if text == "" {
+		text = defaultEchoTemplate
+	}
+	tmpl, err := template.New("echo").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CHAT_ECHO_TEMPLATE: %w", err)
+	}
+	return &echoChatModel{tmpl: tmpl}, nil
+}
+
+func (m *echoChatModel) Generate(ctx context.Context, prompt string) (string, error) {
+	data := struct {
+		Question  string
+		Knowledge []string
+		Prompt    string
+	}{
+		Question:  promptField(prompt, "Current User Question:"),
+		Knowledge: promptSection(prompt, "Restaurant Knowledge"),
+		Prompt:    prompt,
+	}
+	if data.Question == "" {
+		data.Question = strings.TrimSpace(prompt)
+	}
+
+	var buf bytes.Buffer
+	if err := m.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render echo response: %w", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// GenerateStream renders the whole response and replays it word by word
+func (m *echoChatModel) GenerateStream(ctx context.Context, prompt string, onToken func(string) error) (string, error) {
+	text, err := m.Generate(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	for _, token := range strings.SplitAfter(text, " ") {
+		if err := ctx.Err(); err != nil {
+			return text, err
+		}
+		if err := onToken(token); err != nil {
+			return text, err
+		}
+	}
+	return text, nil
+}
+
+// promptField returns the rest of the first line starting with label
+func promptField(prompt, label string) string {
+	for _, line := range strings.Split(prompt, "\n") {
+		if strings.HasPrefix(line, label) {
+			return strings.TrimSpace(strings.TrimPrefix(line, label))
+		}
+	}
+	return ""
+}
+
+// promptSection returns the non-empty lines following a heading that starts with title, up to the next blank line
+func promptSection(prompt, title string) []string {
+	var lines []string
+	in := false
+	for _, line := range strings.Split(prompt, "\n") {
+		switch {
+		case !in && strings.HasPrefix(line, title):
+			in = true
+		case in && strings.TrimSpace(line) == "":
+			return lines
+		case in:
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return lines
+}
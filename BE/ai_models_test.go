@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The echo model reads the question and knowledge back out of the real prompts;
+// these tests fail when a prompt edit moves the labels it relies on
+func TestPromptFieldsInRestaurantPrompts(t *testing.T) {
+	question := "Is the sate spicy?"
+	knowledge := []string{"Sate Kambing: grilled goat skewers (Rp 45000)", "Teh Manis: sweet iced tea"}
+	profile := "Opening hours: Mon-Sun 10:00-22:00"
+	availability := availabilityNote(time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC), []string{"Nasi Goreng: served from 11:00"})
+	history := []ChatHistory{{Query: "Hi", Response: "Hello! How can I help?"}}
+
+	prompts := map[string]string{
+		"without history": createRestaurantPrompt(question, profile, knowledge, availability),
+		"with history":    createRestaurantPromptWithHistory(question, profile, knowledge, history, 3, "en", availability),
+		"other language":  createRestaurantPromptWithHistory(question, profile, knowledge, nil, 3, "ja", ""),
+	}
+	for name, prompt := range prompts {
+		if got := promptField(prompt, "Current User Question:"); got != question {
+			t.Errorf("%s: question = %q, want %q", name, got, question)
+		}
+		if got := promptSection(prompt, "Restaurant Knowledge"); !reflect.DeepEqual(got, knowledge) {
+			t.Errorf("%s: knowledge = %q, want %q", name, got, knowledge)
+		}
+	}
+
+	empty := createRestaurantPromptWithHistory(question, profile, nil, nil, 3, "en", "")
+	if got := promptSection(empty, "Restaurant Knowledge"); len(got) != 0 {
+		t.Errorf("no knowledge: section = %q, want none", got)
+	}
+}
+
+func TestEchoChatModel(t *testing.T) {
+	m, err := newEchoChatModel("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	tests := []struct {
+		prompt string
+		want   string
+	}{
+		{
+			createRestaurantPrompt("Any tea?", "", []string{"Teh Manis: sweet iced tea"}, ""),
+			"You asked: Any tea?\nHere is what I found:\n- Teh Manis: sweet iced tea",
+		},
+		{
+			createRestaurantPrompt("Any tea?", "", nil, ""),
+			"You asked: Any tea?\nI have no matching information.",
+		},
+		{"plain question", "You asked: plain question\nI have no matching information."},
+	}
+	for _, tt := range tests {
+		got, err := m.Generate(ctx, tt.prompt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Generate = %q, want %q", got, tt.want)
+		}
+
+		var streamed string
+		full, err := m.GenerateStream(ctx, tt.prompt, func(token string) error {
+			streamed += token
+			return nil
+		})
+		if err != nil || full != tt.want || streamed != tt.want {
+			t.Errorf("GenerateStream = %q (streamed %q), %v; want %q", full, streamed, err, tt.want)
+		}
+	}
+
+	if _, err := newEchoChatModel("{{.Missing"); err == nil {
+		t.Error("invalid template accepted")
+	}
+}
+
+func TestHashEmbedder(t *testing.T) {
+	e := &hashEmbedder{dim: 64}
+	ctx := context.Background()
+	a, _ := e.Embed(ctx, "grilled goat skewers")
+	b, _ := e.Embed(ctx, "Grilled goat skewers!")
+	c, _ := e.Embed(ctx, "sweet iced tea")
+	if len(a) != 64 {
+		t.Fatalf("dimension = %d, want 64", len(a))
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Error("embedding depends on case or punctuation")
+	}
+	if same, other := cosineSimilarity(a, b), cosineSimilarity(a, c); same < 0.999 || other >= same {
+		t.Errorf("similarity: same text %v, different text %v", same, other)
+	}
+	if zero, _ := e.Embed(ctx, "  "); cosineSimilarity(zero, a) != 0 {
+		t.Error("blank text should embed to the zero vector")
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// allergenDisclaimer is appended, word for word, to every allergen answer that relies on incomplete data
+const allergenDisclaimer = "Allergen information is not available for every item. If you have an allergy or dietary requirement, please confirm with our staff before ordering."
+
+// allergenRefusal answers allergen questions when no retrieved item carries allergen or dietary tags
+const allergenRefusal = "I don't have verified allergen or dietary information for the items you asked about, so I can't tell you whether they are safe for you."
+
+// noDeclaredAllergens is stored as the allergen list of items declared free of all 14, so $nin filters match them
+const noDeclaredAllergens = "none"
+
+// allergenTerms maps each EU 14 allergen to the words guests use for it
+var allergenTerms = map[string][]string{
+	"gluten":      {"gluten", "wheat", "barley", "rye", "celiac", "coeliac"},
+	"crustaceans": {"crustacean", "shrimp", "prawn", "crab", "lobster", "shellfish"},
+	"eggs":        {"egg", "eggs"},
+	"fish":        {"fish", "anchovy", "anchovies"},
+	"peanuts":     {"peanut", "peanuts", "groundnut"},
+	"soybeans":    {"soy", "soya", "soybean", "soybeans", "tofu"},
+	"milk":        {"milk", "dairy", "lactose"},
+	"nuts":        {"nut", "nuts", "tree nut", "almond", "walnut", "cashew", "hazelnut", "pecan", "pistachio", "macadamia"},
+	"celery":      {"celery", "celeriac"},
+	"mustard":     {"mustard"},
+	"sesame":      {"sesame", "tahini"},
+	"sulphites":   {"sulphite", "sulphites", "sulfite", "sulfites"},
+	"lupin":       {"lupin", "lupine"},
+	"molluscs":    {"mollusc", "molluscs", "mollusk", "mussel", "oyster", "clam", "squid", "octopus", "shellfish"},
+}
+
+// dietaryTerms maps each dietary tag to the phrases that ask for it
+var dietaryTerms = map[string][]string{
+	"vegan":       {"vegan", "plant based"},
+	"vegetarian":  {"vegetarian", "veggie"},
+	"pescatarian": {"pescatarian", "pescetarian"},
+	"halal":       {"halal"},
+	"kosher":      {"kosher"},
+}
+
+// freeFromTags are the dietary tags that vouch for the absence of an allergen on their own
+var freeFromTags = map[string]string{
+	"gluten": "gluten_free",
+	"milk":   "dairy_free",
+	"nuts":   "nut_free",
+}
+
+// allergyWords mark a question as allergen related even when it names no allergen
+var allergyWords = []string{"allergen", "allergens", "allergy", "allergies", "allergic", "intolerance", "intolerant", "anaphylaxis", "safe for"}
+
+// avoidWords turn a named allergen into an allergen question: "does it contain nuts" but not "do you have any crab"
+var avoidWords = []string{"free", "without", "avoid", "contain", "contains", "containing", "traces", "cannot eat", "can t eat", "cant eat"}
+
+// avoidPhrases do the same around the allergen term itself: "is there milk in the latte", "no nuts please".
+// Plain "any" and "no" are left out, as in "any fish dishes" or "crab, no rice".
+var avoidPhrases = []string{
+	"%s in the", "%s in it", "%s in this", "%s in that", "%s in these", "%s in those", "%s in them",
+	"is there %s in", "are there %s in", "is there any %s in", "are there any %s in", "no %s",
+}
+
+// wordPattern splits "gluten-free" into "gluten free" so both spellings match
+var wordPattern = regexp.MustCompile(`[a-z]+`)
+
+// allergenGuard is the guardrail for one question. When Active, the answer may only use explicit
+// allergen and dietary tags: without any, the fixed refusal is returned instead of asking the model,
+// and the fixed disclaimer is added whenever a retrieved item has no tags.
+type allergenGuard struct {
+	Active    bool
+	Allergens []string // allergens the guest wants to avoid
+	Dietary   []string // dietary tags the guest asked for
+}
+
+// newAllergenGuard detects allergen and dietary questions
+func newAllergenGuard(question string) allergenGuard {
+	q := " " + strings.Join(wordPattern.FindAllString(strings.ToLower(question), -1), " ") + " "
+	has := func(term string) bool { return strings.Contains(q, " "+term+" ") }
+
+	var g allergenGuard
+	for tag, terms := range dietaryTerms {
+		for _, term := range terms {
+			if has(term) {
+				g.Dietary = append(g.Dietary, tag)
+				break
+			}
+		}
+	}
+	avoided := false
+	for allergen, terms := range allergenTerms {
+		for _, term := range terms {
+			if has(term) {
+				g.Allergens = append(g.Allergens, allergen)
+				for _, phrase := range avoidPhrases {
+					avoided = avoided || has(fmt.Sprintf(phrase, term))
+				}
+				break
+			}
+		}
+	}
+	sort.Strings(g.Allergens)
+	sort.Strings(g.Dietary)
+
+	avoided = avoided || (len(g.Allergens) > 0 && containsAny(has, avoidWords))
+	g.Active = len(g.Dietary) > 0 || containsAny(has, allergyWords) || avoided
+	if !g.Active {
+		g.Allergens = nil
+	}
+	return g
+}
+
+func containsAny(has func(string) bool, words []string) bool {
+	for _, w := range words {
+		if has(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// allergenMetadata is the vector metadata that lets queries filter by allergens and dietary tags
+func allergenMetadata(m Metadata) map[string]interface{} {
+	meta := map[string]interface{}{"allergens_declared": m.AllergensDeclared}
+	if m.AllergensDeclared {
+		allergens := []interface{}{noDeclaredAllergens}
+		if len(m.Allergens) > 0 {
+			allergens = make([]interface{}, len(m.Allergens))
+			for i, a := range m.Allergens {
+				allergens[i] = a
+			}
+		}
+		meta["allergens"] = allergens
+	}
+	if len(m.Dietary) > 0 {
+		dietary := make([]interface{}, len(m.Dietary))
+		for i, d := range m.Dietary {
+			dietary[i] = d
+		}
+		meta["dietary"] = dietary
+	}
+	return meta
+}
+
+// safeItemsFilter matches items served now whose tags satisfy the guest: free of every allergen
+// asked about, by declared allergens or a free-from tag, and carrying every dietary tag asked for
+func (g allergenGuard) safeItemsFilter(opts queryOptions) map[string]interface{} {
+	conds := []interface{}{servedNowFilter(opts.Now)}
+	for _, a := range g.Allergens {
+		declaredFree := map[string]interface{}{"$and": []interface{}{
+			map[string]interface{}{"allergens_declared": map[string]interface{}{"$eq": true}},
+			map[string]interface{}{"allergens": map[string]interface{}{"$nin": []interface{}{a}}},
+		}}
+		if tag, ok := freeFromTags[a]; ok {
+			conds = append(conds, map[string]interface{}{"$or": []interface{}{
+				declaredFree,
+				map[string]interface{}{"dietary": map[string]interface{}{"$in": []interface{}{tag}}},
+			}})
+		} else {
+			conds = append(conds, declaredFree)
+		}
+	}
+	for _, d := range g.Dietary {
+		conds = append(conds, map[string]interface{}{"dietary": map[string]interface{}{"$in": []interface{}{d}}})
+	}
+	return map[string]interface{}{"$and": conds}
+}
+
+// safeItems retrieves items verified by their tags, when the guest asked about specific allergens or diets
+func (g allergenGuard) safeItems(ctx context.Context, namespace string, embedding []float32, opts queryOptions) ([]string, error) {
+	if len(g.Allergens) == 0 && len(g.Dietary) == 0 {
+		return nil, nil
+	}
+	matches, err := Vectors.Query(ctx, namespace, embedding, opts.Settings.TopK, g.safeItemsFilter(opts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tagged items: %w", err)
+	}
+	return contextFromMatches(matches), nil
+}
+
+// isDishMatch tells dish chunks from section and informational chunks
+func isDishMatch(m VectorMatch) bool {
+	category, _ := m.Metadata["category"].(string)
+	itemKey, _ := m.Metadata["item_key"].(string)
+	return category != "info" && category != "section" && itemKey != "section"
+}
+
+// hasTags reports whether a match carries allergen or dietary data
+func hasTags(m VectorMatch) bool {
+	if declared, _ := m.Metadata["allergens_declared"].(bool); declared {
+		return true
+	}
+	_, ok := m.Metadata["dietary"]
+	return ok
+}
+
+// assess reports whether any retrieved dish carries tags and whether any lacks them
+func (g allergenGuard) assess(matches []VectorMatch, safe []string) (tagged, missing bool) {
+	tagged = len(safe) > 0
+	dishes := 0
+	for _, m := range matches {
+		if !isDishMatch(m) {
+			continue
+		}
+		dishes++
+		if hasTags(m) {
+			tagged = true
+		} else {
+			missing = true
+		}
+	}
+	return tagged, missing || dishes == 0
+}
+
+// note is the prompt section with the guardrail rules and the tag-verified items
+func (g allergenGuard) note(safe []string) string {
+	var b strings.Builder
+	b.WriteString(`Allergen Rules (MANDATORY):
+- Answer allergen and dietary questions ONLY from the "Allergens:" and "Dietary:" tags in the knowledge
+- If an item has no such tag, say its allergen information is not available; NEVER guess from its name, description or ingredients
+- Never state that an item is safe for an allergy unless its tags say so`)
+	if len(safe) > 0 {
+		b.WriteString("\n\nItems Verified By Tags:\n")
+		b.WriteString(strings.Join(safe, "\n"))
+	}
+	return b.String()
+}
+
+// finish appends the fixed disclaimer when the guard is active and tag data is missing, streaming
+// it as a last token, unless the model already ended with it
+func (g allergenGuard) finish(answer string, missing bool, onToken func(string) error) (string, error) {
+	if !g.Active || !missing || strings.HasSuffix(strings.TrimSpace(answer), allergenDisclaimer) {
+		return answer, nil
+	}
+	if onToken != nil {
+		if err := onToken("\n\n" + allergenDisclaimer); err != nil {
+			return answer, err
+		}
+	}
+	return strings.TrimSpace(answer) + "\n\n" + allergenDisclaimer, nil
+}
+
+// refusal is the fixed answer when no retrieved item has tags, so the model is never asked to guess
+func (g allergenGuard) refusal() string {
+	return allergenRefusal + "\n\n" + allergenDisclaimer
+}
+
+// debug summarises the guard for query responses
+func (g allergenGuard) debug(safe []string, refused bool) gin.H {
+	return gin.H{"active": g.Active, "allergens": g.Allergens, "dietary": g.Dietary, "verified_items": len(safe), "refused": refused}
+}
+
+// formatAllergens renders an item's allergen tags for its chunk text
+func formatAllergens(allergens []string) string {
+	if len(allergens) == 0 {
+		return "none of the 14 major allergens"
+	}
+	return "contains " + strings.Join(allergens, ", ")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewAllergenGuard(t *testing.T) {
+	tests := []struct {
+		question  string
+		active    bool
+		allergens []string
+		dietary   []string
+	}{
+		// Asking for a dish is not an allergen question
+		{"Do you have any fish dishes?", false, nil, nil},
+		{"Any shrimp on the menu?", false, nil, nil},
+		{"I want crab, no rice", false, nil, nil},
+		{"What's good here?", false, nil, nil},
+
+		// Containment
+		{"Is there milk in the latte?", true, []string{"milk"}, nil},
+		{"Are there any nuts in the brownie?", true, []string{"nuts"}, nil},
+		{"Is there gluten in it?", true, []string{"gluten"}, nil},
+		{"Does the curry contain peanuts?", true, []string{"peanuts"}, nil},
+
+		// Avoidance
+		{"Which dishes are gluten-free?", true, []string{"gluten"}, nil},
+		{"No eggs please, what can I have?", true, []string{"eggs"}, nil},
+		{"I can't eat sesame", true, []string{"sesame"}, nil},
+		{"Anything without shrimp?", true, []string{"crustaceans"}, nil},
+
+		// Allergy words and dietary tags work on their own
+		{"I have a severe allergy, what is safe?", true, nil, nil},
+		{"What vegan options do you have?", true, nil, []string{"vegan"}},
+	}
+	for _, tt := range tests {
+		g := newAllergenGuard(tt.question)
+		if g.Active != tt.active || !reflect.DeepEqual(g.Allergens, tt.allergens) || !reflect.DeepEqual(g.Dietary, tt.dietary) {
+			t.Errorf("newAllergenGuard(%q) = %+v, want Active:%v Allergens:%v Dietary:%v",
+				tt.question, g, tt.active, tt.allergens, tt.dietary)
+		}
+	}
+}
+
+// indexTestMenu stores a menu in a fresh in-memory namespace with the hash embedder and echo chat model
+func indexTestMenu(t *testing.T, menu string) string {
+	t.Helper()
+	ctx := context.Background()
+	var err error
+	if Vectors, err = newMemoryStore(""); err != nil {
+		t.Fatal(err)
+	}
+	Embeddings = &hashEmbedder{dim: 256}
+	if Chat, err = newEchoChatModel(""); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := prepareChunks(json.RawMessage(menu), "r1", "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunks, err = generateEmbeddings(ctx, chunks, nil); err != nil {
+		t.Fatal(err)
+	}
+	namespace := branchNamespace("r1", "b1")
+	if _, err := storeChunksInPinecone(ctx, chunks, namespace); err != nil {
+		t.Fatal(err)
+	}
+	return namespace
+}
+
+func askTestMenu(t *testing.T, namespace, question string) (string, gin.H) {
+	t.Helper()
+	ctx := context.Background()
+	embedding, err := Embeddings.Embed(ctx, question)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := queryOptions{Now: time.Now().UTC(), Settings: RetrievalSettings{}.withDefaults()}
+	result, err := queryChatbotInPinecone(ctx, embedding, namespace, question, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard := result["debug"].(gin.H)["allergen_guard"].(gin.H)
+	return result["response"].(string), guard
+}
+
+func TestQueryRefusesToGuessAllergens(t *testing.T) {
+	namespace := indexTestMenu(t, `{"sections": [{"name": "Drinks", "items": [
+		{"name": "Latte", "description": "Espresso with steamed milk"},
+		{"name": "Iced Tea", "description": "Black tea with lemon"}
+	]}]}`)
+
+	response, guard := askTestMenu(t, namespace, "Is there milk in the latte?")
+	if response != allergenRefusal+"\n\n"+allergenDisclaimer {
+		t.Errorf("untagged menu answered %q, want the fixed refusal", response)
+	}
+	if guard["refused"] != true {
+		t.Errorf("allergen_guard.refused = %v, want true", guard["refused"])
+	}
+
+	// Ordinary questions about the same menu still go to the model
+	response, guard = askTestMenu(t, namespace, "Do you have a latte?")
+	if guard["active"] != false || strings.Contains(response, allergenRefusal) {
+		t.Errorf("non-allergen question was guarded: %q", response)
+	}
+}
+
+func TestQueryAddsDisclaimerWhenTagsAreMissing(t *testing.T) {
+	namespace := indexTestMenu(t, `{"sections": [{"name": "Drinks", "items": [
+		{"name": "Latte", "description": "Espresso with steamed milk", "allergens": ["milk"]},
+		{"name": "Oat Latte", "description": "Espresso with oat drink", "allergens": []},
+		{"name": "Mocha", "description": "Espresso with chocolate and milk"}
+	]}]}`)
+
+	response, guard := askTestMenu(t, namespace, "Is there milk in the latte or the mocha?")
+	if guard["refused"] != false {
+		t.Fatalf("tagged menu was refused: %q", response)
+	}
+	if !strings.HasSuffix(response, allergenDisclaimer) {
+		t.Errorf("answer %q does not end with the disclaimer", response)
+	}
+	if strings.Count(response, allergenDisclaimer) != 1 {
+		t.Errorf("disclaimer appears %d times", strings.Count(response, allergenDisclaimer))
+	}
+}
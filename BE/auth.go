@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrAccountExists is returned when registering an email that already has an account
+	ErrAccountExists = errors.New("account already exists")
+	// ErrInvalidCredentials is returned when the email or password is wrong
+	ErrInvalidCredentials = errors.New("invalid email or password")
+)
+
+const (
+	authUserKey       = "auth_user" // gin context key of the signed-in AuthUser
+	minPasswordLength = 8
+)
+
+// AuthUser is the signed-in restaurant owner, taken from the verified token
+type AuthUser struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Role  string `json:"role,omitempty"`
+}
+
+// AuthSession is what a successful login returns
+type AuthSession struct {
+	Token     string   `json:"token"`
+	TokenType string   `json:"token_type"`
+	ExpiresIn int      `json:"expires_in"` // seconds
+	User      AuthUser `json:"user"`
+}
+
+var (
+	// Tokens verifies bearer tokens on admin routes
+	Tokens *jwtVerifier
+	// tokenTTL is the lifetime of tokens issued by the self-hosted backend
+	tokenTTL = time.Hour
+)
+
+// initAuth configures token verification. HS256 tokens are checked against JWT_SECRET (Supabase's
+// "JWT secret", also read from SUPABASE_JWT_SECRET); RS256/ES256 tokens against the JWKS at JWKS_URL,
+// which defaults to the Supabase project's. The self-hosted backend signs its own tokens and needs JWT_SECRET.
+func initAuth() error {
+	secret := envOr("JWT_SECRET", os.Getenv("SUPABASE_JWT_SECRET"))
+	jwksURL := os.Getenv("JWKS_URL")
+	backend := strings.ToLower(envOr("DB_BACKEND", "supabase"))
+	if jwksURL == "" && backend == "supabase" && os.Getenv("SUPABASE_URL") != "" {
+		jwksURL = strings.TrimRight(os.Getenv("SUPABASE_URL"), "/") + "/auth/v1/.well-known/jwks.json"
+	}
+	if backend != "supabase" && secret == "" {
+		return fmt.Errorf("JWT_SECRET is required for DB_BACKEND=%s", backend)
+	}
+	if secret == "" && jwksURL == "" {
+		return fmt.Errorf("set JWT_SECRET or JWKS_URL to verify access tokens")
+	}
+	if v := os.Getenv("JWT_EXPIRY"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid JWT_EXPIRY %q", v)
+		}
+		tokenTTL = ttl
+	}
+
+	Tokens = &jwtVerifier{
+		secret:   []byte(secret),
+		jwksURL:  jwksURL,
+		audience: envOr("JWT_AUDIENCE", "authenticated"),
+		issuer:   os.Getenv("JWT_ISSUER"),
+	}
+	log.Printf("Auth: HS256 %v, JWKS %q", secret != "", jwksURL)
+	return nil
+}
+
+// issueToken signs a session for a self-hosted account
+func issueToken(user AuthUser, now time.Time) (AuthSession, error) {
+	claims := jwtClaims{
+		Subject:   user.ID,
+		Email:     user.Email,
+		Role:      "authenticated",
+		Audience:  jwtAudience{Tokens.audience},
+		Issuer:    Tokens.issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(tokenTTL).Unix(),
+	}
+	token, err := signHS256(claims, Tokens.secret)
+	if err != nil {
+		return AuthSession{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+	return AuthSession{Token: token, TokenType: "bearer", ExpiresIn: int(tokenTTL / time.Second), User: user}, nil
+}
+
+// bearerToken reads the Authorization header, or the access_token query parameter for
+// EventSource clients, which cannot set headers
+func bearerToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return c.Query("access_token")
+}
+
+// RequireAuth rejects requests without a valid bearer token and stores the AuthUser for handlers
+func RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+			return
+		}
+		claims, err := Tokens.verify(token, time.Now())
+		if err != nil {
+			if !errors.Is(err, ErrInvalidToken) {
+				log.Printf("Token verification error: %v", err)
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+		c.Set(authUserKey, AuthUser{ID: claims.Subject, Email: claims.Email, Role: claims.Role})
+		c.Next()
+	}
+}
+
+// optionalUser authenticates the request when it carries a valid bearer token, for public routes
+// that give owners more than guests
+func optionalUser(c *gin.Context) (AuthUser, bool) {
+	token := bearerToken(c)
+	if token == "" {
+		return AuthUser{}, false
+	}
+	claims, err := Tokens.verify(token, time.Now())
+	if err != nil {
+		return AuthUser{}, false
+	}
+	user := AuthUser{ID: claims.Subject, Email: claims.Email, Role: claims.Role}
+	c.Set(authUserKey, user)
+	return user, true
+}
+
+// currentUser returns the user RequireAuth stored on the request
+func currentUser(c *gin.Context) AuthUser {
+	user, _ := c.MustGet(authUserKey).(AuthUser)
+	return user
+}
+
+// authorizeRestaurant checks that the signed-in user owns a restaurant, writing a 404/403 response otherwise
+func authorizeRestaurant(c *gin.Context, restaurantID string) bool {
+	restaurant, err := Restaurants.Get(c.Request.Context(), restaurantID)
+	if errors.Is(err, ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+		return false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get restaurant", "details": err.Error()})
+		return false
+	}
+	if !strings.EqualFold(restaurant.OwnerID, currentUser(c).ID) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You do not own this restaurant"})
+		return false
+	}
+	return true
+}
+
+// authorizeBranch checks that the signed-in user owns a branch's restaurant
+func authorizeBranch(c *gin.Context, branchID string) bool {
+	branch, err := Branches.Get(c.Request.Context(), branchID)
+	if errors.Is(err, ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branch", "details": err.Error()})
+		return false
+	}
+	return authorizeRestaurant(c, branch.RestaurantID)
+}
+
+// authorizeChatbot checks that the signed-in user owns a chatbot's branch
+func authorizeChatbot(c *gin.Context, chatbotID string) bool {
+	bot, err := Chatbots.Get(c.Request.Context(), chatbotID)
+	if errors.Is(err, ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
+		return false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chatbot", "details": err.Error()})
+		return false
+	}
+	return authorizeBranch(c, bot.BranchID)
+}
+
+// ownsRestaurant, ownsBranch and ownsChatbot guard routes by the owner of the resource in a path parameter
+func ownsRestaurant(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authorizeRestaurant(c, c.Param(param)) {
+			c.Next()
+		}
+	}
+}
+
+func ownsBranch(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authorizeBranch(c, c.Param(param)) {
+			c.Next()
+		}
+	}
+}
+
+func ownsChatbot(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authorizeChatbot(c, c.Param(param)) {
+			c.Next()
+		}
+	}
+}
+
+// ownsJob guards job routes by the owner of the job's chatbot
+func ownsJob(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := Jobs.Get(c.Request.Context(), c.Param(param))
+		if errors.Is(err, ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job", "details": err.Error()})
+			return
+		}
+		if authorizeChatbot(c, job.ChatbotID) {
+			c.Next()
+		}
+	}
+}
+
+type credentials struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Register creates a restaurant owner account
+func Register(c *gin.Context) {
+	var body credentials
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.Email = strings.ToLower(strings.TrimSpace(body.Email))
+	if _, err := mail.ParseAddress(body.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+	if len(body.Password) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+		return
+	}
+
+	user, err := Accounts.Register(c.Request.Context(), body.Email, body.Password)
+	if errors.Is(err, ErrAccountExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Register error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"user": user})
+}
+
+// Login exchanges an email and password for a bearer token
+func Login(c *gin.Context) {
+	var body credentials
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	session, err := Accounts.SignIn(c.Request.Context(), strings.ToLower(strings.TrimSpace(body.Email)), body.Password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+	if err != nil {
+		log.Printf("Login error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// Me returns the signed-in user
+func Me(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"user": currentUser(c)})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo
+)
+
+// availabilitySlotMinutes is the granularity of the weekly slots stored with scheduled chunks
+const availabilitySlotMinutes = 15
+
+// neverAvailable is the only slot of an item marked unavailable, so it matches no query time
+const neverAvailable = "never"
+
+// weekdays is indexed by time.Weekday and matches the day names of the menu schema
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// branchLocation loads a branch's time zone, falling back to UTC when it is unset or unknown
+func branchLocation(b Branch) *time.Location {
+	if b.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(b.Timezone)
+	if err != nil {
+		log.Printf("Branch %s: unknown time zone %q, using UTC", b.ID, b.Timezone)
+		return time.UTC
+	}
+	return loc
+}
+
+// validTimezone reports whether tz is an IANA time zone name such as "Asia/Jakarta"
+func validTimezone(tz string) bool {
+	if tz == "" || strings.EqualFold(tz, "local") {
+		return false
+	}
+	_, err := time.LoadLocation(tz)
+	return err == nil
+}
+
+// parseClock turns "07:30" into minutes after midnight
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	hours, err1 := strconv.Atoi(h)
+	minutes, err2 := strconv.Atoi(m)
+	if err1 != nil || err2 != nil || hours < 0 || hours > 23 || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// slotLabel names the weekly slot starting at minute of day, e.g. "mon-0715"
+func slotLabel(day, minute int) string {
+	return fmt.Sprintf("%s-%02d%02d", weekdays[day%7], minute/60, minute%60)
+}
+
+// slotAt returns the slot containing t, in t's own location
+func slotAt(t time.Time) string {
+	minute := t.Hour()*60 + t.Minute()
+	return slotLabel(int(t.Weekday()), minute-minute%availabilitySlotMinutes)
+}
+
+// availabilitySlots expands windows into the weekly slots they cover. A window whose until is not
+// after its from runs past midnight into the next day, so "22:00-02:00" on fri includes sat 01:45.
+// A slot counts when the window covers its start.
+func availabilitySlots(windows []Availability) []string {
+	seen := make(map[string]bool)
+	var slots []string
+	for _, w := range windows {
+		from, until := 0, 24*60
+		if w.From != "" {
+			m, err := parseClock(w.From)
+			if err != nil {
+				continue
+			}
+			from = m
+		}
+		if w.Until != "" {
+			m, err := parseClock(w.Until)
+			if err != nil {
+				continue
+			}
+			until = m
+		}
+		if until <= from {
+			until += 24 * 60
+		}
+
+		days := w.Days
+		if len(days) == 0 {
+			days = weekdays
+		}
+		for _, name := range days {
+			day := indexOf(weekdays, strings.ToLower(name))
+			if day < 0 {
+				continue
+			}
+			start := (from + availabilitySlotMinutes - 1) / availabilitySlotMinutes * availabilitySlotMinutes
+			for m := start; m < until; m += availabilitySlotMinutes {
+				label := slotLabel(day+m/(24*60), m%(24*60))
+				if !seen[label] {
+					seen[label] = true
+					slots = append(slots, label)
+				}
+			}
+		}
+	}
+	return slots
+}
+
+// availabilityMetadata is the vector metadata that lets queries filter by the time of day.
+// Chunks without windows are unscheduled and always match.
+func availabilityMetadata(m Metadata) map[string]interface{} {
+	if m.Unavailable {
+		return map[string]interface{}{"scheduled": true, "available_slots": []interface{}{neverAvailable}}
+	}
+	slots := availabilitySlots(m.Availability)
+	if len(slots) == 0 {
+		return map[string]interface{}{"scheduled": false}
+	}
+	// structpb, used by the Pinecone client, only takes []interface{} lists
+	list := make([]interface{}, len(slots))
+	for i, slot := range slots {
+		list[i] = slot
+	}
+	return map[string]interface{}{"scheduled": true, "available_slots": list}
+}
+
+// servedNowFilter matches chunks that are unscheduled or served at now
+func servedNowFilter(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"$or": []interface{}{
+			map[string]interface{}{"scheduled": map[string]interface{}{"$eq": false}},
+			map[string]interface{}{"available_slots": map[string]interface{}{"$in": []interface{}{slotAt(now)}}},
+		},
+	}
+}
+
+// notServedNowFilter matches scheduled chunks that are not served at now
+func notServedNowFilter(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"$and": []interface{}{
+			map[string]interface{}{"scheduled": map[string]interface{}{"$eq": true}},
+			map[string]interface{}{"available_slots": map[string]interface{}{"$nin": []interface{}{slotAt(now)}}},
+		},
+	}
+}
+
+// describeLocalTime renders now for the prompt, e.g. "Tuesday 08:15 (Asia/Jakarta)"
+func describeLocalTime(now time.Time) string {
+	return fmt.Sprintf("%s %s (%s)", now.Weekday(), now.Format("15:04"), now.Location())
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BranchProfile holds the structured facts guests ask about. It is stored as JSON on the branch
+// and injected into every prompt ahead of the retrieved menu knowledge.
+type BranchProfile struct {
+	Hours          []Availability   `json:"hours,omitempty"`      // weekly opening hours, same windows as menu availability
+	Exceptions     []HoursException `json:"exceptions,omitempty"` // holidays and other dates that differ from Hours
+	Phone          string           `json:"phone,omitempty"`
+	Email          string           `json:"email,omitempty"`
+	Website        string           `json:"website,omitempty"`
+	Services       BranchServices   `json:"services"`
+	PaymentMethods []string         `json:"payment_methods,omitempty"`
+	Accessibility  []string         `json:"accessibility,omitempty"` // e.g. "wheelchair accessible", "step-free entrance"
+}
+
+// HoursException overrides the weekly hours on one date. Closed wins over Hours; days in Hours are ignored.
+type HoursException struct {
+	Date   string         `json:"date"` // YYYY-MM-DD in the branch time zone
+	Closed bool           `json:"closed,omitempty"`
+	Hours  []Availability `json:"hours,omitempty"`
+	Note   string         `json:"note,omitempty"`
+}
+
+// BranchServices records what a branch offers. Nil means unknown, so the model is not told "no".
+type BranchServices struct {
+	DineIn       *bool `json:"dine_in,omitempty"`
+	Takeaway     *bool `json:"takeaway,omitempty"`
+	Delivery     *bool `json:"delivery,omitempty"`
+	Reservations *bool `json:"reservations,omitempty"`
+	Parking      *bool `json:"parking,omitempty"`
+	Wifi         *bool `json:"wifi,omitempty"`
+}
+
+// maxProfileExceptions bounds how many upcoming exceptions go into the prompt
+const maxProfileExceptions = 5
+
+// validateBranchProfile returns field errors for malformed hours and exceptions
+func validateBranchProfile(p BranchProfile) []FieldError {
+	var errs []FieldError
+	checkWindows := func(field string, windows []Availability) {
+		for i, w := range windows {
+			for _, day := range w.Days {
+				if indexOf(weekdays, strings.ToLower(day)) < 0 {
+					errs = append(errs, FieldError{Field: fmt.Sprintf("%s[%d].days", field, i), Message: fmt.Sprintf("unknown day %q (want mon..sun)", day)})
+				}
+			}
+			for name, value := range map[string]string{"from": w.From, "until": w.Until} {
+				if value == "" {
+					continue
+				}
+				if _, err := parseClock(value); err != nil {
+					errs = append(errs, FieldError{Field: fmt.Sprintf("%s[%d].%s", field, i, name), Message: "want HH:MM"})
+				}
+			}
+		}
+	}
+	checkWindows("profile.hours", p.Hours)
+	seen := make(map[string]bool)
+	for i, e := range p.Exceptions {
+		field := fmt.Sprintf("profile.exceptions[%d]", i)
+		if _, err := time.Parse(time.DateOnly, e.Date); err != nil {
+			errs = append(errs, FieldError{Field: field + ".date", Message: "want YYYY-MM-DD"})
+		} else if seen[e.Date] {
+			errs = append(errs, FieldError{Field: field + ".date", Message: "duplicate date " + e.Date})
+		}
+		seen[e.Date] = true
+		if !e.Closed && len(e.Hours) == 0 {
+			errs = append(errs, FieldError{Field: field, Message: "set closed or hours"})
+		}
+		checkWindows(field+".hours", e.Hours)
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+// hoursOn returns the opening windows that apply at now and the exception in force, if any
+func (p BranchProfile) hoursOn(now time.Time) ([]Availability, *HoursException) {
+	today := now.Format(time.DateOnly)
+	for i, e := range p.Exceptions {
+		if e.Date != today {
+			continue
+		}
+		if e.Closed {
+			return nil, &p.Exceptions[i]
+		}
+		day := weekdays[now.Weekday()]
+		windows := make([]Availability, len(e.Hours))
+		for j, w := range e.Hours {
+			windows[j] = Availability{Days: []string{day}, From: w.From, Until: w.Until}
+		}
+		return windows, &p.Exceptions[i]
+	}
+	return p.Hours, nil
+}
+
+// openAt reports whether the branch is open at now; ok is false when no hours are known
+func (p BranchProfile) openAt(now time.Time) (open, ok bool) {
+	windows, exception := p.hoursOn(now)
+	if exception == nil && len(windows) == 0 {
+		return false, false
+	}
+	slot := slotAt(now)
+	for _, s := range availabilitySlots(windows) {
+		if s == slot {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// branchProfileBlock renders the branch facts for the prompt, evaluated at the branch's local time
+func branchProfileBlock(b Branch, now time.Time) string {
+	p := b.Profile
+	lines := []string{"Branch: " + b.Name}
+	if b.Address != "" {
+		lines = append(lines, "Address: "+b.Address)
+	}
+	var contact []string
+	for _, c := range []string{p.Phone, p.Email, p.Website} {
+		if c != "" {
+			contact = append(contact, c)
+		}
+	}
+	if len(contact) > 0 {
+		lines = append(lines, "Contact: "+strings.Join(contact, ", "))
+	}
+
+	if len(p.Hours) > 0 {
+		lines = append(lines, "Opening hours: "+formatAvailability(p.Hours))
+	}
+	today := now.Format(time.DateOnly)
+	var upcoming []string
+	for _, e := range p.Exceptions {
+		if e.Date < today {
+			continue
+		}
+		text := e.Date + " "
+		if e.Closed {
+			text += "closed"
+		} else {
+			// The date already says which day; drop the "daily" of windows without days
+			text += strings.ReplaceAll(formatAvailability(e.Hours), "daily ", "")
+		}
+		if e.Note != "" {
+			text += " (" + e.Note + ")"
+		}
+		upcoming = append(upcoming, text)
+	}
+	if len(upcoming) > 0 {
+		sort.Strings(upcoming)
+		if len(upcoming) > maxProfileExceptions {
+			upcoming = upcoming[:maxProfileExceptions]
+		}
+		lines = append(lines, "Exceptions to opening hours: "+strings.Join(upcoming, "; "))
+	}
+	if open, ok := p.openAt(now); ok {
+		status := "closed"
+		if open {
+			status = "open"
+		}
+		lines = append(lines, fmt.Sprintf("Open right now, %s: %s", describeLocalTime(now), status))
+	}
+
+	var services []string
+	for _, s := range []struct {
+		name  string
+		value *bool
+	}{
+		{"dine-in", p.Services.DineIn},
+		{"takeaway", p.Services.Takeaway},
+		{"delivery", p.Services.Delivery},
+		{"reservations", p.Services.Reservations},
+		{"parking", p.Services.Parking},
+		{"wifi", p.Services.Wifi},
+	} {
+		if s.value == nil {
+			continue
+		}
+		if *s.value {
+			services = append(services, s.name+" yes")
+		} else {
+			services = append(services, s.name+" no")
+		}
+	}
+	if len(services) > 0 {
+		lines = append(lines, "Services: "+strings.Join(services, ", "))
+	}
+	if len(p.PaymentMethods) > 0 {
+		lines = append(lines, "Payment methods: "+strings.Join(p.PaymentMethods, ", "))
+	}
+	if len(p.Accessibility) > 0 {
+		lines = append(lines, "Accessibility: "+strings.Join(p.Accessibility, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// GetBranch returns a branch with its profile
+func GetBranch(c *gin.Context) {
+	branch, err := Branches.Get(c.Request.Context(), c.Param("branchId"))
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branch", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, branch)
+}
+
+// UpdateBranch replaces a branch's name, address, time zone and profile. A branch that does not
+// exist yet is created under the path id in the restaurant_id given, so retries are safe.
+func UpdateBranch(c *gin.Context) {
+	branchID := c.Param("branchId")
+	var body struct {
+		RestaurantID string        `json:"restaurant_id"`
+		Name         string        `json:"name" binding:"required"`
+		Address      string        `json:"address"`
+		Timezone     string        `json:"timezone"`
+		Profile      BranchProfile `json:"profile"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.Timezone == "" {
+		body.Timezone = "UTC"
+	} else if !validTimezone(body.Timezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone; use an IANA name such as Asia/Jakarta", "timezone": body.Timezone})
+		return
+	}
+	if errs := validateBranchProfile(body.Profile); len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid branch profile", "fields": errs})
+		return
+	}
+	if !validClientID(c, branchID) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	existing, err := Branches.Get(ctx, branchID)
+	if errors.Is(err, ErrNotFound) {
+		if body.RestaurantID == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found; send restaurant_id to create it"})
+			return
+		}
+		if !authorizeRestaurant(c, body.RestaurantID) {
+			return
+		}
+		created, err := Branches.Create(ctx, Branch{
+			ID:           branchID,
+			RestaurantID: body.RestaurantID,
+			Name:         body.Name,
+			Address:      body.Address,
+			Timezone:     body.Timezone,
+			Profile:      body.Profile,
+		})
+		if err != nil {
+			log.Printf("Branch insert error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create branch", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, created)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branch", "details": err.Error()})
+		return
+	}
+	if !authorizeBranch(c, branchID) {
+		return
+	}
+	if body.RestaurantID != "" && !strings.EqualFold(body.RestaurantID, existing.RestaurantID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Branches cannot move to another restaurant", "restaurant_id": existing.RestaurantID})
+		return
+	}
+
+	updateBranch(c, branchID, map[string]interface{}{
+		"name":     body.Name,
+		"address":  body.Address,
+		"timezone": body.Timezone,
+		"profile":  body.Profile,
+	})
+}
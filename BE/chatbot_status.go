@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BuildTimings summarises a chatbot's most recent index build and the job that ran it
+type BuildTimings struct {
+	BuildID     string     `json:"build_id"`
+	JobID       string     `json:"job_id,omitempty"`
+	Status      string     `json:"status"`
+	VersionID   string     `json:"version_id,omitempty"`
+	VectorCount int        `json:"vector_count"`
+	Error       string     `json:"error,omitempty"`
+	Attempts    int        `json:"attempts,omitempty"`
+	QueuedAt    *time.Time `json:"queued_at,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	QueueWaitMS *int64     `json:"queue_wait_ms,omitempty"` // queued until the build started
+	DurationMS  *int64     `json:"duration_ms,omitempty"`   // build start until it went live or failed for good
+}
+
+// ChatbotDetail is what GET /chatbots/:id reports. The chatbot carries its status, last error and
+// active and indexed version IDs.
+type ChatbotDetail struct {
+	Chatbot     Chatbot           `json:"chatbot"`
+	VectorCount int               `json:"vector_count"` // vectors the chatbot answers from
+	IndexState  VersionIndexState `json:"index_state"`
+	LastBuild   *BuildTimings     `json:"last_build"`
+}
+
+func millisBetween(from, to time.Time) *int64 {
+	ms := to.Sub(from).Milliseconds()
+	return &ms
+}
+
+// lastBuildTimings reads timings off the newest build; the job adds queue time, attempts and,
+// for builds that failed for good, when it gave up
+func lastBuildTimings(ctx context.Context, build IndexBuild) *BuildTimings {
+	t := &BuildTimings{
+		BuildID:     build.ID,
+		JobID:       build.JobID,
+		Status:      build.Status,
+		VersionID:   build.VersionID,
+		VectorCount: build.VectorCount,
+		Error:       build.Error,
+		StartedAt:   build.CreatedAt,
+		FinishedAt:  build.ActivatedAt,
+	}
+	if build.JobID != "" {
+		job, err := Jobs.Get(ctx, build.JobID)
+		if err == nil {
+			t.Attempts = job.Attempts
+			t.QueuedAt = &job.CreatedAt
+			t.QueueWaitMS = millisBetween(job.CreatedAt, build.CreatedAt)
+			if t.FinishedAt == nil && job.Status == "dead" {
+				t.FinishedAt = job.FinishedAt
+			}
+		} else if !errors.Is(err, ErrNotFound) {
+			log.Printf("Index build %s: cannot load job %s: %v", build.ID, build.JobID, err)
+		}
+	}
+	if t.FinishedAt != nil {
+		t.DurationMS = millisBetween(build.CreatedAt, *t.FinishedAt)
+	}
+	return t
+}
+
+// chatbotDetail gathers a chatbot's status, last error, vector count and last build
+func chatbotDetail(ctx context.Context, bot Chatbot) (ChatbotDetail, error) {
+	detail := ChatbotDetail{Chatbot: bot, IndexState: indexState(bot)}
+	builds, err := Builds.List(ctx, bot.ID)
+	if err != nil {
+		return detail, err
+	}
+	if len(builds) > 0 {
+		detail.LastBuild = lastBuildTimings(ctx, builds[0])
+	}
+	for _, b := range builds {
+		if b.ID == bot.ActiveBuildID {
+			detail.VectorCount = b.VectorCount
+		}
+	}
+
+	// Chatbots indexed before blue/green builds have no build record; count their namespace
+	if bot.ActiveBuildID == "" && bot.ContentHash != "" {
+		branch, err := Branches.Get(ctx, bot.BranchID)
+		if err != nil {
+			return detail, err
+		}
+		ids, err := Vectors.ListIDs(ctx, liveNamespace(bot, branch.RestaurantID, branch.ID))
+		if err != nil {
+			return detail, err
+		}
+		detail.VectorCount = len(ids)
+	}
+	return detail, nil
+}
+
+func writeChatbotDetail(c *gin.Context, chatbotID string) {
+	ctx := c.Request.Context()
+	bot, err := Chatbots.Get(ctx, chatbotID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chatbot", "details": err.Error()})
+		return
+	}
+	detail, err := chatbotDetail(ctx, bot)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read chatbot status", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, detail)
+}
+
+// GetChatbot returns a chatbot with its status, last error, vector count, versions and last build timings
+func GetChatbot(c *gin.Context) {
+	writeChatbotDetail(c, c.Param("chatbotId"))
+}
+
+// GetBranchChatbot returns the branch's chatbot like GetChatbot
+func GetBranchChatbot(c *gin.Context) {
+	// Chatbot id equals branch id
+	writeChatbotDetail(c, c.Param("branchId"))
+}
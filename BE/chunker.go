@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Keys that hold a dish's fields when a dish is a JSON object
+var (
+	dishNameKeys   = []string{"name", "title", "dish", "item"}
+	dishPriceKeys  = []string{"price", "prices", "cost", "amount"}
+	dishDescKeys   = []string{"description", "desc", "details"}
+	dishListKeys   = []string{"items", "dishes", "products", "entries"}
+	sectionKeys    = []string{"sections", "categories", "courses", "groups"}
+	menuRootKeys   = []string{"menu", "menus", "food", "drinks", "beverages"}
+	dishSkipFields = map[string]bool{"id": true, "key": true, "slug": true, "image": true, "image_url": true, "position": true, "order": true}
+)
+
+var (
+	// "Spring Rolls - $8", "Iced Tea: 4.50", "Nasi Goreng — Rp 35.000"
+	priceSuffixPattern = regexp.MustCompile(`^(.*?)\s*(?:-|–|—|:|\|)\s*([^\s].*\d.*)$`)
+	// "$8", "€ 12.50", "Rp 35.000", "12 USD", "35k"
+	pricePattern = regexp.MustCompile(`(?i)(?:[$€£¥₹]|rp\.?|idr|usd|eur|sgd)\s*\d[\d.,]*|\d[\d.,]*\s*(?:k|usd|eur|idr|sgd)\b`)
+	// A bare number only counts as a price inside a menu
+	barePricePattern = regexp.MustCompile(`^\d+(?:[.,]\d+)?$`)
+	identPattern     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	slugPattern      = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// menuChunker walks menu JSON of arbitrary depth and emits one chunk per dish and one per informational field.
+// Dish chunks use the JSON path of their list as Source and a slug of the dish name as ItemKey,
+// so reordering dishes or changing one price only touches that dish's vector.
+type menuChunker struct {
+	chunks []TextChunk
+	dishes []menuDish     // structured view of the dish chunks, for snapshot diffs
+	keys   map[string]int // Source|ItemKey -> occurrences, to disambiguate duplicate names
+}
+
+// menuDish identifies a dish by the same Source|ItemKey its vector uses, so diffs match what reindexing touches
+type menuDish struct {
+	Key     string `json:"key"`
+	Section string `json:"section,omitempty"`
+	Name    string `json:"name"`
+	Price   string `json:"price,omitempty"`
+}
+
+func newMenuChunker() *menuChunker {
+	return &menuChunker{keys: make(map[string]int)}
+}
+
+// walk visits value at path. category is the nearest meaningful section name; inMenu is true below a menu-like key.
+func (m *menuChunker) walk(path, category string, value interface{}, inMenu bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		name := dishName(v)
+		isSection := hasAnyKey(v, dishListKeys) || hasAnyKey(v, sectionKeys)
+		switch {
+		case name != "" && !isSection && (inMenu || hasAnyKey(v, dishPriceKeys)):
+			m.addDish(parentPath(path), category, name, v)
+			return
+		case name == "" && !isSection && inMenu && hasAnyKey(v, dishPriceKeys) && path != "":
+			// Dish keyed by its name: {"Burger": {"price": 12, "description": "..."}}
+			m.addDish(trimLastKey(path), category, lastPathKey(path), v)
+			return
+		case name != "" && isSection:
+			// A named section: {"name": "Mains", "items": [...]}
+			category = name
+			inMenu = true
+		}
+		if isPriceMap(v, inMenu) {
+			for _, key := range sortedKeys(v) {
+				m.addDish(path, category, key, map[string]interface{}{"price": v[key]})
+			}
+			return
+		}
+		for _, key := range sortedKeys(v) {
+			if isSection && containsFold(dishNameKeys, key) {
+				continue
+			}
+			child := joinPath(path, key)
+			childCategory := category
+			if !containsFold(dishListKeys, key) && !containsFold(sectionKeys, key) && !containsFold(menuRootKeys, key) && !containsFold(dishNameKeys, key) && !isKeyedDish(v[key]) {
+				childCategory = humanizeKey(key)
+			}
+			m.walk(child, childCategory, v[key], inMenu || containsFold(menuRootKeys, key) || containsFold(sectionKeys, key))
+		}
+	case []interface{}:
+		var infos []string
+		for i, item := range v {
+			switch it := item.(type) {
+			case string:
+				if name, price := splitDishString(it, inMenu); price != "" || inMenu {
+					m.addDish(path, category, name, map[string]interface{}{"price": price})
+				} else {
+					infos = append(infos, it)
+				}
+			case map[string]interface{}:
+				// Named elements are addressed by name so reordering a list keeps their paths stable
+				if name := dishName(it); name != "" {
+					m.walk(fmt.Sprintf("%s[name=%s]", path, name), category, it, inMenu)
+				} else if isFlatObject(it) && !isKeyedDish(it) {
+					// A record such as {"day": "Mon", "open": "9:00"} reads better as one line
+					m.addInfo(fmt.Sprintf("%s[%d]", path, i), category, fieldText(it))
+				} else {
+					m.walk(fmt.Sprintf("%s[%d]", path, i), category, it, inMenu)
+				}
+			case []interface{}:
+				m.walk(fmt.Sprintf("%s[%d]", path, i), category, it, inMenu)
+			default:
+				infos = append(infos, scalarText(it))
+			}
+		}
+		if len(infos) > 0 {
+			m.addInfo(path, category, strings.Join(infos, ", "))
+		}
+	case nil:
+		return
+	default:
+		m.addInfo(path, category, scalarText(v))
+	}
+}
+
+// addDish emits a natural-language chunk for one dish
+func (m *menuChunker) addDish(source, category, name string, fields map[string]interface{}) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	if category != "" {
+		fmt.Fprintf(&b, " (%s)", category)
+	}
+	b.WriteString(".")
+	price := priceText(firstField(fields, dishPriceKeys))
+	if price != "" {
+		fmt.Fprintf(&b, " Price: %s.", price)
+	}
+	if desc := scalarText(firstField(fields, dishDescKeys)); desc != "" {
+		fmt.Fprintf(&b, " %s", strings.TrimSuffix(desc, "."))
+		b.WriteString(".")
+	}
+	for _, key := range sortedKeys(fields) {
+		if containsFold(dishNameKeys, key) || containsFold(dishPriceKeys, key) || containsFold(dishDescKeys, key) || dishSkipFields[strings.ToLower(key)] {
+			continue
+		}
+		if text := fieldText(fields[key]); text != "" {
+			fmt.Fprintf(&b, " %s: %s.", humanizeKey(key), text)
+		}
+	}
+
+	itemKey := slugify(name)
+	if slug := scalarText(fields["slug"]); slug != "" {
+		itemKey = slugify(slug)
+	} else if id := scalarText(fields["id"]); id != "" {
+		itemKey = slugify(id)
+	}
+	itemKey = m.add(source, category, itemKey, b.String())
+	m.lastMetadata().Price = dishPrice(price)
+	m.dishes = append(m.dishes, menuDish{Key: source + "|" + itemKey, Section: category, Name: name, Price: price})
+}
+
+// addInfo emits a chunk for a non-dish field such as opening hours
+func (m *menuChunker) addInfo(path, category, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	m.add(path, "info", slugify(lastPathKey(path)), fmt.Sprintf("%s: %s", pathLabel(path), text))
+}
+
+// add appends a chunk and returns its ItemKey, suffixed when the key is already taken under source
+func (m *menuChunker) add(source, category, itemKey, text string) string {
+	dupKey := source + "|" + itemKey
+	m.keys[dupKey]++
+	if n := m.keys[dupKey]; n > 1 {
+		itemKey = fmt.Sprintf("%s-%d", itemKey, n)
+	}
+	m.chunks = append(m.chunks, TextChunk{
+		ID:   uuid.New().String(),
+		Text: text,
+		Metadata: Metadata{
+			Source:    source,
+			Category:  category,
+			ItemKey:   itemKey,
+			ItemIndex: -1,
+		},
+	})
+	return itemKey
+}
+
+// looksLikePrice reports whether s is a price; bare numbers count only when allowBare is set
+func looksLikePrice(s string, allowBare bool) bool {
+	s = strings.TrimSpace(s)
+	return pricePattern.MatchString(s) || (allowBare && barePricePattern.MatchString(s))
+}
+
+// splitDishString splits "Spring Rolls - $8" into name and price. Price is empty when none is recognised.
+func splitDishString(s string, allowBare bool) (string, string) {
+	s = strings.TrimSpace(s)
+	if match := priceSuffixPattern.FindStringSubmatch(s); match != nil && looksLikePrice(match[2], allowBare) {
+		return strings.TrimSpace(match[1]), strings.TrimSpace(match[2])
+	}
+	if loc := pricePattern.FindStringIndex(s); loc != nil && loc[0] > 0 {
+		return strings.TrimSpace(s[:loc[0]] + s[loc[1]:]), strings.TrimSpace(s[loc[0]:loc[1]])
+	}
+	return s, ""
+}
+
+// isKeyedDish reports whether v is a dish object keyed by its name, e.g. the value of "Burger": {"price": 12}
+func isKeyedDish(v interface{}) bool {
+	obj, ok := v.(map[string]interface{})
+	return ok && dishName(obj) == "" && hasAnyKey(obj, dishPriceKeys) && !hasAnyKey(obj, dishListKeys) && !hasAnyKey(obj, sectionKeys)
+}
+
+// isFlatObject reports whether every value of obj is a scalar
+func isFlatObject(obj map[string]interface{}) bool {
+	for _, v := range obj {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+	return true
+}
+
+// dishName returns the value of the first name-like key, if it is a string
+func dishName(obj map[string]interface{}) string {
+	name, _ := firstField(obj, dishNameKeys).(string)
+	return strings.TrimSpace(name)
+}
+
+// isPriceMap reports whether obj maps names to prices, e.g. {"Latte": "$4", "Mocha": 4.5}.
+// Outside a menu only prices with a currency count, so {"parking": {"hourly": 5}} stays informational.
+func isPriceMap(obj map[string]interface{}, inMenu bool) bool {
+	if len(obj) == 0 {
+		return false
+	}
+	for key, val := range obj {
+		if containsFold(dishNameKeys, key) || containsFold(dishPriceKeys, key) {
+			return false
+		}
+		switch v := val.(type) {
+		case float64:
+			if !inMenu {
+				return false
+			}
+		case string:
+			if !looksLikePrice(v, inMenu) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// priceText renders a price given as a number, a string, an {amount, currency} object or a list of variants
+func priceText(v interface{}) string {
+	switch p := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(p, 'f', -1, 64)
+	case string:
+		return strings.TrimSpace(p)
+	case map[string]interface{}:
+		amount := scalarText(firstField(p, []string{"amount", "value", "price"}))
+		if amount == "" {
+			return fieldText(p)
+		}
+		if currency := scalarText(p["currency"]); currency != "" {
+			return amount + " " + currency
+		}
+		return amount
+	default:
+		return fieldText(p)
+	}
+}
+
+// fieldText renders any JSON value as short prose
+func fieldText(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		parts := make([]string, 0, len(t))
+		for _, item := range t {
+			if text := fieldText(item); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, ", ")
+	case map[string]interface{}:
+		if name := dishName(t); name != "" {
+			if price := priceText(firstField(t, dishPriceKeys)); price != "" {
+				return fmt.Sprintf("%s (%s)", name, price)
+			}
+			return name
+		}
+		if _, ok := t["amount"]; ok {
+			return priceText(t)
+		}
+		parts := make([]string, 0, len(t))
+		for _, key := range sortedKeys(t) {
+			if text := fieldText(t[key]); text != "" {
+				parts = append(parts, fmt.Sprintf("%s %s", humanizeKey(key), text))
+			}
+		}
+		return strings.Join(parts, ", ")
+	default:
+		return scalarText(t)
+	}
+}
+
+func scalarText(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		if t {
+			return "yes"
+		}
+		return "no"
+	case json.Number:
+		return t.String()
+	default:
+		data, _ := json.Marshal(t)
+		return string(data)
+	}
+}
+
+func firstField(obj map[string]interface{}, keys []string) interface{} {
+	for _, key := range keys {
+		for k, v := range obj {
+			if strings.EqualFold(k, key) {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func hasAnyKey(obj map[string]interface{}, keys []string) bool {
+	return firstField(obj, keys) != nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// joinPath appends a key to a JSON path, quoting keys that are not plain identifiers
+func joinPath(path, key string) string {
+	if !identPattern.MatchString(key) {
+		key = fmt.Sprintf("[%q]", key)
+		if path == "" {
+			return "$" + key
+		}
+		return path + key
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// parentPath strips a trailing [n] or [name=...] element so a dish's Source is its list, not its position
+func parentPath(path string) string {
+	if i := strings.LastIndex(path, "["); i > 0 && strings.HasSuffix(path, "]") {
+		return path[:i]
+	}
+	return path
+}
+
+// pathLabel renders a JSON path as a readable label: restaurant.opening_hours -> "restaurant - opening hours"
+func pathLabel(path string) string {
+	var parts []string
+	for _, seg := range strings.FieldsFunc(path, func(r rune) bool { return r == '.' || r == '[' || r == ']' || r == '$' }) {
+		seg = strings.Trim(seg, `"`)
+		if _, err := strconv.Atoi(seg); err == nil {
+			continue
+		}
+		seg = strings.TrimPrefix(seg, "name=")
+		parts = append(parts, humanizeKey(seg))
+	}
+	if len(parts) == 0 {
+		return "info"
+	}
+	return strings.Join(parts, " - ")
+}
+
+// trimLastKey drops the last key of a path: menu.mains.burger -> menu.mains
+func trimLastKey(path string) string {
+	if i := strings.LastIndexAny(path, ".["); i > 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+func lastPathKey(path string) string {
+	path = parentPath(path)
+	if i := strings.LastIndexAny(path, ".["); i >= 0 {
+		return strings.Trim(path[i+1:], `"]`)
+	}
+	return path
+}
+
+func humanizeKey(key string) string {
+	return strings.TrimSpace(strings.NewReplacer("_", " ", "-", " ").Replace(key))
+}
+
+func slugify(s string) string {
+	return strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
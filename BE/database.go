@@ -3,101 +3,64 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
-
-	"github.com/google/uuid"
+	"time"
 )
 
 // updateChatbotStatus updates the status of a chatbot in the database
 func updateChatbotStatus(chatbotID, status string) {
-	updateData := map[string]interface{}{
+	err := Chatbots.Update(context.Background(), chatbotID, map[string]interface{}{
 		"status": status,
+	})
+	if err != nil {
+		log.Printf("Error updating chatbot status: %v", err)
 	}
+}
 
-	var updated []Chatbot
-	_, err := SupabaseClient.
-		From("chatbots").
-		Update(updateData, "", "").
-		Eq("id", chatbotID).
-		ExecuteTo(&updated)
+// recordChatbotError sets a chatbot's status and stores the error behind it, so clients can see
+// why a background build failed instead of only the log
+func recordChatbotError(chatbotID, status string, cause error) {
+	err := Chatbots.Update(context.Background(), chatbotID, map[string]interface{}{
+		"status":        status,
+		"last_error":    cause.Error(),
+		"last_error_at": time.Now().UTC(),
+	})
 	if err != nil {
-		log.Printf("Error updating chatbot status: %v", err)
+		log.Printf("Error recording chatbot error: %v", err)
 	}
 }
 
-// chunkContent splits JSON content into text chunks for processing
+// chunkContent splits JSON content into text chunks for processing.
+// Structured menus (see Menu) are chunked from their typed form; anything else is walked
+// recursively so that each dish still becomes its own chunk (see menuChunker).
 func chunkContent(content json.RawMessage) ([]TextChunk, error) {
-	// Parse the raw JSON
-	var contentMap map[string]interface{}
-	if err := json.Unmarshal(content, &contentMap); err != nil {
+	chunker, err := chunkMenu(content)
+	if err != nil {
 		return nil, err
 	}
+	return chunker.chunks, nil
+}
 
-	chunks := []TextChunk{}
-
-	// Process different sections of the JSON content
-	for section, data := range contentMap {
-		switch v := data.(type) {
-		case string:
-			// For simple string values, create a single chunk
-			chunk := TextChunk{
-				ID:   uuid.New().String(),
-				Text: fmt.Sprintf("%s: %s", section, v),
-				Metadata: Metadata{
-					Source:    section,
-					Category:  "general",
-					ItemKey:   section, // logical key for this field
-					ItemIndex: -1,
-				},
-			}
-			chunks = append(chunks, chunk)
-		case []interface{}:
-			// For arrays, process each item
-			for i, item := range v {
-				itemStr, err := json.Marshal(item)
-				if err != nil {
-					continue
-				}
-				chunk := TextChunk{
-					ID:   uuid.New().String(),
-					Text: fmt.Sprintf("%s item %d: %s", section, i, string(itemStr)),
-					Metadata: Metadata{
-						Source:    section,
-						Category:  "list",
-						ItemKey:   "", // use index for stable ID
-						ItemIndex: i,
-					},
-				}
-				chunks = append(chunks, chunk)
-			}
-		case map[string]interface{}:
-			// For nested objects, process each field
-			for key, val := range v {
-				valStr, err := json.Marshal(val)
-				if err != nil {
-					continue
-				}
-				chunk := TextChunk{
-					ID:   uuid.New().String(),
-					Text: fmt.Sprintf("%s - %s: %s", section, key, string(valStr)),
-					Metadata: Metadata{
-						Source:    section,
-						Category:  "object",
-						ItemKey:   key, // use key for stable ID
-						ItemIndex: -1,
-					},
-				}
-				chunks = append(chunks, chunk)
-			}
-		}
+// chunkMenu runs the chunker over content, keeping both the chunks and the dishes it found
+func chunkMenu(content json.RawMessage) (*menuChunker, error) {
+	// Parse the raw JSON
+	var data interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
 	}
 
-	return chunks, nil
+	chunker := newMenuChunker()
+	if menu, ok := parseStructuredMenu(content); ok {
+		chunker.addMenu(menu)
+	} else {
+		chunker.walk("", "", data, false)
+	}
+	return chunker, nil
 }
 
-// generateEmbeddings creates vector embeddings for text chunks using Gemini
-func generateEmbeddings(ctx context.Context, chunks []TextChunk) ([]TextChunk, error) {
+// generateEmbeddings creates vector embeddings for text chunks using Gemini.
+// onEmbedded, if set, is called with the number of chunks embedded so far.
+func generateEmbeddings(ctx context.Context, chunks []TextChunk, onEmbedded func(done int)) ([]TextChunk, error) {
 
 	for i := range chunks {
 		// Using Gemini to generate embeddings
@@ -106,6 +69,9 @@ func generateEmbeddings(ctx context.Context, chunks []TextChunk) ([]TextChunk, e
 			return nil, err
 		}
 		chunks[i].Embedding = embedding
+		if onEmbedded != nil {
+			onEmbedded(i + 1)
+		}
 	}
 	return chunks, nil
 }
@@ -0,0 +1,25 @@
+-- Run this BEFORE schema.sql when self-hosting on plain Postgres (DB_BACKEND=postgres).
+-- It provides the pieces of a Supabase project that schema.sql depends on:
+-- the uuid-ossp extension, an auth.users table and the auth.uid() function used by the RLS policies.
+--
+--   psql "$DATABASE_URL" -f database/local_bootstrap.sql
+--   psql "$DATABASE_URL" -f database/schema.sql
+
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE SCHEMA IF NOT EXISTS auth;
+
+CREATE TABLE IF NOT EXISTS auth.users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email TEXT UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Owner accounts registered through POST /auth/register; bcrypt hashes, checked by POST /auth/login
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS password_hash TEXT;
+
+-- The backend connects as the table owner, which bypasses RLS, so auth.uid() only
+-- matters for other roles. It reads the subject the same way PostgREST does.
+CREATE OR REPLACE FUNCTION auth.uid() RETURNS UUID
+    LANGUAGE sql STABLE
+    AS $$ SELECT NULLIF(current_setting('request.jwt.claim.sub', true), '')::uuid $$;
@@ -49,6 +49,9 @@ CREATE TABLE IF NOT EXISTS chatbot_versions (
     UNIQUE (chatbot_id, content_hash)
 );
 
+CREATE INDEX IF NOT EXISTS idx_chatbot_versions_chatbot_created
+    ON chatbot_versions(chatbot_id, created_at DESC);
+
 -- Create a view to get all chatbots with restaurant and branch info
 CREATE OR REPLACE VIEW chatbot_info AS
 SELECT 
@@ -199,3 +202,129 @@ CREATE POLICY menu_snapshots_delete_policy ON menu_snapshots
 
 CREATE INDEX IF NOT EXISTS idx_menu_snapshots_branch_id ON menu_snapshots(branch_id);
 CREATE INDEX IF NOT EXISTS idx_menu_snapshots_created_at ON menu_snapshots(created_at DESC);
+
+-- Drafts (e.g. menus extracted from an uploaded PDF) wait for review and are never indexed until published
+ALTER TABLE IF EXISTS menu_snapshots
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published', -- 'published', 'draft'
+    ADD COLUMN IF NOT EXISTS provenance JSONB;
+
+-- Serves the latest-published lookup and the paginated history
+CREATE INDEX IF NOT EXISTS idx_menu_snapshots_branch_status_created
+    ON menu_snapshots(branch_id, status, created_at DESC);
+
+-- Guest conversation history (keyed by client session)
+CREATE TABLE IF NOT EXISTS chat_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    session_id TEXT NOT NULL,
+    query TEXT NOT NULL,
+    response TEXT NOT NULL,
+    language TEXT DEFAULT 'en',
+    timestamp TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_history_session_id ON chat_history(session_id);
+CREATE INDEX IF NOT EXISTS idx_chat_history_timestamp ON chat_history(timestamp);
+
+-- Durable background jobs (chatbot indexing). Workers lease jobs and extend the lease while running;
+-- expired leases are requeued, failures retry with backoff until max_attempts, then become 'dead'.
+CREATE TABLE IF NOT EXISTS index_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    kind TEXT NOT NULL DEFAULT 'index',
+    chatbot_id UUID NOT NULL REFERENCES chatbots(id) ON DELETE CASCADE,
+    payload JSONB NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'queued', -- 'queued', 'running', 'succeeded', 'dead'
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    run_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    lease_owner TEXT,
+    lease_expires_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    progress JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_index_jobs_runnable ON index_jobs(status, run_at);
+CREATE INDEX IF NOT EXISTS idx_index_jobs_chatbot_id ON index_jobs(chatbot_id);
+
+-- At most one running job per chatbot, so concurrent workers cannot race to switch its live build.
+-- Requeue any extra running jobs first; their workers lose the lease at the next extension.
+UPDATE index_jobs j
+   SET status = 'queued', lease_owner = NULL, lease_expires_at = NULL, run_at = NOW(), updated_at = NOW()
+ WHERE j.status = 'running'
+   AND EXISTS (SELECT 1 FROM index_jobs o WHERE o.chatbot_id = j.chatbot_id AND o.status = 'running' AND o.id < j.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_index_jobs_one_running ON index_jobs(chatbot_id) WHERE status = 'running';
+
+-- Blue/green indexing: every build gets its own vector namespace and the chatbot points at the live one
+ALTER TABLE IF EXISTS chatbots
+    ADD COLUMN IF NOT EXISTS active_namespace TEXT,
+    ADD COLUMN IF NOT EXISTS active_build_id UUID;
+
+CREATE TABLE IF NOT EXISTS index_builds (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chatbot_id UUID NOT NULL REFERENCES chatbots(id) ON DELETE CASCADE,
+    job_id UUID,
+    namespace TEXT NOT NULL UNIQUE,
+    version_id UUID,
+    content_hash TEXT NOT NULL DEFAULT '',
+    vector_count INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'building', -- 'building', 'live', 'retired', 'failed', 'deleted'
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    activated_at TIMESTAMP WITH TIME ZONE,
+    retired_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_index_builds_chatbot_created ON index_builds(chatbot_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_index_builds_status_retired ON index_builds(status, retired_at);
+
+-- Scheduled menus: availability windows in menu content are evaluated in the branch's time zone
+ALTER TABLE IF EXISTS branches
+    ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+
+-- Structured branch profile: opening hours and exceptions, contact, services, payment methods, accessibility
+ALTER TABLE IF EXISTS branches
+    ADD COLUMN IF NOT EXISTS profile JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+-- Per-chatbot retrieval settings: top_k, min_score, max_context_chars, history_turns, model
+ALTER TABLE IF EXISTS chatbots
+    ADD COLUMN IF NOT EXISTS retrieval_settings JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+-- Guest sessions: what table QR codes encode. A session opens one branch's chat until it expires,
+-- is revoked (rotation revokes the old session) or has answered max_uses questions (0 = unlimited)
+CREATE TABLE IF NOT EXISTS guest_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+    table_label TEXT,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    max_uses INTEGER NOT NULL DEFAULT 0 CHECK (max_uses >= 0),
+    uses INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    rotated_to UUID REFERENCES guest_sessions(id) ON DELETE SET NULL,
+    created_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_guest_sessions_branch_created ON guest_sessions(branch_id, created_at DESC);
+
+-- Chat history belongs to a branch so deleting the branch removes it
+ALTER TABLE IF EXISTS chat_history
+    ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES branches(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_chat_history_branch_id ON chat_history(branch_id);
+
+-- Backfill older rows through their guest session. History from before guest sessions, or whose
+-- session is gone, cannot be attributed and keeps a NULL branch_id.
+UPDATE chat_history h
+   SET branch_id = g.branch_id
+  FROM guest_sessions g
+ WHERE h.branch_id IS NULL
+   AND h.session_id = g.id::text;
+
+-- Why the chatbot's last indexing attempt failed, shown by GET /chatbots/:id
+ALTER TABLE IF EXISTS chatbots
+    ADD COLUMN IF NOT EXISTS last_error TEXT,
+    ADD COLUMN IF NOT EXISTS last_error_at TIMESTAMP WITH TIME ZONE;
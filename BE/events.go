@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventBroker fans indexing events out to SSE subscribers, keyed by chatbot ID.
+// It is in-process only: clients see events for jobs run by the instance they are connected to.
+type eventBroker struct {
+	mu   sync.Mutex
+	subs map[string]map[chan IndexEvent]struct{}
+}
+
+// IndexEvents is the broker the index workers publish to
+var IndexEvents = &eventBroker{subs: make(map[string]map[chan IndexEvent]struct{})}
+
+// Subscribe returns a channel of events for a chatbot and a function that unsubscribes
+func (b *eventBroker) Subscribe(chatbotID string) (<-chan IndexEvent, func()) {
+	ch := make(chan IndexEvent, 32)
+	b.mu.Lock()
+	if b.subs[chatbotID] == nil {
+		b.subs[chatbotID] = make(map[chan IndexEvent]struct{})
+	}
+	b.subs[chatbotID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs[chatbotID], ch)
+		if len(b.subs[chatbotID]) == 0 {
+			delete(b.subs, chatbotID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Publish sends an event to every subscriber of its chatbot. Slow subscribers drop events
+// rather than stalling the worker.
+func (b *eventBroker) Publish(ev IndexEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[ev.ChatbotID] {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// StreamChatbotEvents streams indexing progress for a chatbot as Server-Sent Events.
+// The first event reports the current status; the stream ends after a 'done' or 'failed' event.
+func StreamChatbotEvents(c *gin.Context) {
+	chatbotID := c.Param("chatbotId")
+	ctx := c.Request.Context()
+
+	bot, err := Chatbots.Get(ctx, chatbotID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chatbot", "details": err.Error()})
+		return
+	}
+
+	events, unsubscribe := IndexEvents.Subscribe(chatbotID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent("status", IndexEvent{Type: "status", ChatbotID: chatbotID, Status: bot.Status, Time: time.Now().UTC()})
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-heartbeat.C:
+			// SSE comment line keeps proxies from closing an idle connection
+			io.WriteString(w, ": ping\n\n")
+			return true
+		case ev := <-events:
+			c.SSEvent(ev.Type, ev)
+			return ev.Type != "done" && ev.Type != "failed"
+		}
+	})
+}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"context"
-
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -34,69 +33,45 @@ func CreateRestaurant(c *gin.Context) {
 		return
 	}
 
-	if restaurant.ID == "" {
-		restaurant.ID = uuid.New().String()
-	}
-
-	if restaurant.OwnerID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Owner ID is required"})
+	// Restaurants belong to the signed-in owner; owner_id may be omitted but not set to someone else
+	user := currentUser(c)
+	if restaurant.OwnerID != "" && !strings.EqualFold(restaurant.OwnerID, user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "owner_id must be the signed-in user"})
 		return
 	}
+	restaurant.OwnerID = user.ID
 
 	if restaurant.Name == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Restaurant name is required"})
 		return
 	}
 
-	insertData := map[string]interface{}{
-		"id":          restaurant.ID,
-		"name":        restaurant.Name,
-		"description": restaurant.Description,
-		"owner_id":    restaurant.OwnerID,
-	}
-
-	log.Printf("Attempting to insert restaurant: %+v", insertData)
-
-	// Insert into Supabase
-	var inserted []Restaurant
-	count, err := SupabaseClient.
-		From("restaurants").
-		Insert(insertData, false, "", "", "").
-		ExecuteTo(&inserted)
-
-	log.Printf("Insert operation - Count: %d, Error: %v", count, err)
-	log.Printf("Inserted length: %d", len(inserted))
-
-	if err != nil {
-		log.Printf("Supabase insert error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create restaurant", "details": err.Error()})
+	if restaurant.ID == "" {
+		restaurant.ID = uuid.New().String()
+	} else if !validClientID(c, restaurant.ID) {
+		return
+	} else if existing, err := Restaurants.Get(c.Request.Context(), restaurant.ID); err == nil {
+		// A retried create returns the restaurant it made the first time
+		if authorizeRestaurant(c, existing.ID) {
+			c.JSON(http.StatusOK, existing)
+		}
 		return
 	}
 
-	if count == 0 || len(inserted) == 0 {
-		log.Printf("No rows inserted - Count: %d, Inserted length: %d", count, len(inserted))
-
-		// Try to fetch the restaurant that should have been created
-		var fetchedRestaurants []Restaurant
-		fetchCount, fetchErr := SupabaseClient.
-			From("restaurants").
-			Select("*", "", false).
-			Eq("id", restaurant.ID).
-			ExecuteTo(&fetchedRestaurants)
-
-		log.Printf("Fetch attempt - Count: %d, Error: %v", fetchCount, fetchErr)
-
-		if fetchErr == nil && len(fetchedRestaurants) > 0 {
-			// Restaurant was created but not returned due to RLS
-			c.JSON(http.StatusCreated, fetchedRestaurants[0])
-			return
-		}
+	log.Printf("Attempting to insert restaurant: %+v", restaurant)
 
+	created, err := Restaurants.Create(c.Request.Context(), restaurant)
+	if errors.Is(err, ErrNotFound) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "No rows inserted - this may indicate a database constraint violation or RLS policy blocking return"})
 		return
 	}
+	if err != nil {
+		log.Printf("Restaurant insert error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create restaurant", "details": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusCreated, inserted[0])
+	c.JSON(http.StatusCreated, created)
 }
 
 // CreateBranch creates a new branch for a restaurant
@@ -107,11 +82,6 @@ func CreateBranch(c *gin.Context) {
 		return
 	}
 
-	// Generate ID if not provided
-	if branch.ID == "" {
-		branch.ID = uuid.New().String()
-	}
-
 	if branch.RestaurantID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Restaurant ID is required"})
 		return
@@ -122,70 +92,54 @@ func CreateBranch(c *gin.Context) {
 		return
 	}
 
-	// DON'T send timestamps - let the database handle them
-	insertData := map[string]interface{}{
-		"id":            branch.ID,
-		"restaurant_id": branch.RestaurantID,
-		"name":          branch.Name,
-		"address":       branch.Address,
-		"has_chatbot":   false,
-		// Remove created_at and updated_at - let DB set them
+	if branch.Timezone == "" {
+		branch.Timezone = "UTC"
+	} else if !validTimezone(branch.Timezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone; use an IANA name such as Asia/Jakarta", "timezone": branch.Timezone})
+		return
 	}
-
-	log.Printf("Attempting to insert branch: %+v", insertData)
-
-	// Insert into Supabase
-	var inserted []Branch
-	count, err := SupabaseClient.
-		From("branches").
-		Insert(insertData, false, "", "", "").
-		ExecuteTo(&inserted)
-
-	log.Printf("Branch insert operation - Count: %d, Error: %v", count, err)
-	log.Printf("Inserted length: %d", len(inserted))
-
-	if err != nil {
-		log.Printf("Supabase branch insert error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create branch", "details": err.Error()})
+	if errs := validateBranchProfile(branch.Profile); len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid branch profile", "fields": errs})
+		return
+	}
+	if !authorizeRestaurant(c, branch.RestaurantID) {
 		return
 	}
 
-	if count == 0 || len(inserted) == 0 {
-		log.Printf("No branch rows inserted - Count: %d, Inserted length: %d", count, len(inserted))
-
-		// Try to fetch the branch that should have been created
-		var fetchedBranches []Branch
-		fetchCount, fetchErr := SupabaseClient.
-			From("branches").
-			Select("*", "", false).
-			Eq("id", branch.ID).
-			ExecuteTo(&fetchedBranches)
-
-		log.Printf("Branch fetch attempt - Count: %d, Error: %v", fetchCount, fetchErr)
-
-		if fetchErr == nil && len(fetchedBranches) > 0 {
-			// Branch was created but not returned due to RLS
-			c.JSON(http.StatusCreated, fetchedBranches[0])
-			return
+	// Generate ID if not provided
+	if branch.ID == "" {
+		branch.ID = uuid.New().String()
+	} else if !validClientID(c, branch.ID) {
+		return
+	} else if existing, err := Branches.Get(c.Request.Context(), branch.ID); err == nil {
+		// A retried create returns the branch it made the first time
+		if authorizeBranch(c, existing.ID) {
+			c.JSON(http.StatusOK, existing)
 		}
+		return
+	}
 
+	log.Printf("Attempting to insert branch: %+v", branch)
+
+	created, err := Branches.Create(c.Request.Context(), branch)
+	if errors.Is(err, ErrNotFound) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "No branch rows inserted"})
 		return
 	}
+	if err != nil {
+		log.Printf("Branch insert error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create branch", "details": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusCreated, inserted[0])
+	c.JSON(http.StatusCreated, created)
 }
 
 // Fix GetRestaurantBranches
 func GetRestaurantBranches(c *gin.Context) {
 	restaurantID := c.Param("restaurantId")
 
-	var branches []Branch
-	_, err := SupabaseClient.
-		From("branches").
-		Select("*", "", false).
-		Eq("restaurant_id", restaurantID).
-		ExecuteTo(&branches)
+	branches, err := Branches.ListByRestaurant(c.Request.Context(), restaurantID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branches", "details": err.Error()})
 		return
@@ -205,37 +159,6 @@ func GetRestaurantBranches(c *gin.Context) {
 	})
 }
 
-// for testing func GetAllBranches
-func GetAllBranches(c *gin.Context) {
-	// Get all branches from Supabase
-	log.Printf("Fetching all branches from Supabase")
-
-	var branches []Branch
-	count, err := SupabaseClient.
-		From("branches").
-		Select("*", "", false).
-		ExecuteTo(&branches)
-
-	log.Printf("Query result - Count: %d, Error: %v", count, err)
-
-	if err != nil {
-		log.Printf("Database error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branches", "details": err.Error()})
-		return
-	}
-
-	log.Printf("Found %d branches:", len(branches))
-	for i, b := range branches {
-		log.Printf("Branch %d: ID='%s', Name='%s', RestaurantID='%s', HasChatbot=%v",
-			i, b.ID, b.Name, b.RestaurantID, b.HasChatbot)
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"count":    count,
-		"branches": branches,
-	})
-}
-
 func CreateChatbot(c *gin.Context) {
 	var req ChatbotContent
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -244,140 +167,63 @@ func CreateChatbot(c *gin.Context) {
 		return
 	}
 
-	// Generate hash from the content to detect changes
-	hash := generateHash(req.Content)
-
-	// Check if a chatbot with the same content hash already exists
-	var existing []Chatbot
-	_, err := SupabaseClient.
-		From("chatbots").
-		Select("*", "", false).
-		Eq("branch_id", req.BranchID).
-		Eq("content_hash", hash).
-		ExecuteTo(&existing)
-
-	if err == nil && len(existing) > 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"message":    "Content unchanged. Skipping chatbot regeneration.",
-			"chatbot_id": existing[0].ID,
-		})
+	if !bindValidatedContent(c, req.Content, req.ContentFormat) {
+		return
+	}
+	if !authorizeBranch(c, req.BranchID) {
 		return
 	}
 
-	log.Printf("Looking for branch ID: %s", req.BranchID)
+	// Posting to an existing chatbot adds its content as the new active version
+	upsertChatbot(c, req.BranchID, req.Content, "")
+}
 
-	var branches []Branch
-	_, err = SupabaseClient.
-		From("branches").
-		Select("*", "", false).
-		Eq("id", req.BranchID).
-		ExecuteTo(&branches)
+// createChatbotWithContent creates a branch's chatbot with content as its first version and queues the build
+func createChatbotWithContent(c *gin.Context, branchID string, content json.RawMessage, hash string) {
+	ctx := c.Request.Context()
+	log.Printf("Looking for branch ID: %s", branchID)
 
-	if err != nil {
-		log.Printf("Database error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check branch", "details": err.Error()})
+	branch, restaurant, ok := loadBranchContext(c, branchID)
+	if !ok {
 		return
 	}
-	if len(branches) == 0 {
-		log.Printf("Branch not found - ID: %s", req.BranchID)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
-		return
-	}
-
-	branch := branches[0]
 	log.Printf("Found branch: %s", branch.Name)
 
-	var restaurants []Restaurant
-	_, restErr := SupabaseClient.
-		From("restaurants").
-		Select("*", "", false).
-		Eq("id", branch.RestaurantID).
-		ExecuteTo(&restaurants)
-	if restErr != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get restaurant", "details": restErr.Error()})
-		return
-	}
-	if len(restaurants) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+	// Use branch_id as chatbot id; initialize version=1
+	createdChatbot, err := Chatbots.Create(ctx, Chatbot{
+		ID:          branchID,
+		BranchID:    branchID,
+		Status:      "building",
+		ContentHash: hash,
+		Version:     1,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chatbot", "details": err.Error()})
 		return
 	}
 
-	restaurant := restaurants[0]
-
-	// Use branch_id as chatbot id; initialize version=1
-	chatbotData := map[string]interface{}{
-		"id":           req.BranchID,
-		"branch_id":    req.BranchID,
-		"status":       "building",
-		"content_hash": hash,
-		"version":      1,
+	// The initial content becomes the first, active version; index it by version when that worked
+	payload := IndexJobPayload{BranchID: branch.ID, Content: content}
+	if v, err := Versions.Create(ctx, ChatbotVersion{ChatbotID: createdChatbot.ID, Content: content, ContentHash: hash, Notes: "Initial content", CreatedBy: currentUser(c).ID}); err != nil {
+		log.Printf("Warning: failed to record initial version for chatbot %s: %v", createdChatbot.ID, err)
+	} else if err := Chatbots.Update(ctx, createdChatbot.ID, map[string]interface{}{"active_version_id": v.ID}); err != nil {
+		log.Printf("Warning: failed to set active version: %v", err)
+	} else {
+		payload = IndexJobPayload{BranchID: branch.ID, VersionID: v.ID}
 	}
 
-	var inserted []Chatbot
-	_, chatbotErr := SupabaseClient.
-		From("chatbots").
-		Insert(chatbotData, false, "", "", "").
-		ExecuteTo(&inserted)
-	if chatbotErr != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chatbot", "details": chatbotErr.Error()})
-		return
-	}
-	if len(inserted) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No chatbot rows inserted"})
+	job, err := enqueueIndexJob(ctx, createdChatbot.ID, payload)
+	if err != nil {
+		recordChatbotError(createdChatbot.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue chatbot build", "details": err.Error()})
 		return
 	}
-
-	createdChatbot := inserted[0]
-
-	go func() {
-		namespace := fmt.Sprintf("%s_%s", restaurant.ID, strings.ReplaceAll(branch.Name, " ", "_"))
-
-		chunks, err := chunkContent(req.Content)
-		if err != nil {
-			log.Printf("Error chunking content: %v", err)
-			updateChatbotStatus(createdChatbot.ID, "error")
-			return
-		}
-
-		for i := range chunks {
-			chunks[i].Metadata.RestaurantID = restaurant.ID
-			chunks[i].Metadata.BranchID = branch.ID
-		}
-
-		ctx := context.Background()
-		chunks, err = generateEmbeddings(ctx, chunks)
-		if err != nil {
-			log.Printf("Error generating embeddings: %v", err)
-			updateChatbotStatus(createdChatbot.ID, "error")
-			return
-		}
-
-		err = storeChunksInPinecone(ctx, chunks, namespace)
-		if err != nil {
-			log.Printf("Error storing in Pinecone: %v", err)
-			updateChatbotStatus(createdChatbot.ID, "error")
-			return
-		}
-
-		updateChatbotStatus(createdChatbot.ID, "active")
-
-		updateData := map[string]interface{}{
-			"has_chatbot": true,
-		}
-		var updatedBranches []Branch
-		_, err = SupabaseClient.
-			From("branches").
-			Update(updateData, "", "").
-			Eq("id", req.BranchID).
-			ExecuteTo(&updatedBranches)
-		if err != nil {
-			log.Printf("Error updating branch: %v", err)
-		}
-	}()
+	log.Printf("Queued build for branch %s (%s)", branch.Name, restaurant.Name)
 
 	c.JSON(http.StatusAccepted, gin.H{
 		"message":    "Chatbot creation started",
 		"chatbot_id": createdChatbot.ID,
+		"job_id":     job.ID,
 	})
 }
 
@@ -391,240 +237,167 @@ func CreateChatbotLite(c *gin.Context) {
 		return
 	}
 
-	// Ensure branch exists
-	var branches []Branch
-	_, err := SupabaseClient.
-		From("branches").
-		Select("*", "", false).
-		Eq("id", body.BranchID).
-		ExecuteTo(&branches)
-	if err != nil || len(branches) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+	// Ensure branch exists and belongs to the signed-in owner
+	ctx := c.Request.Context()
+	if !authorizeBranch(c, body.BranchID) {
 		return
 	}
 
-	chatbot := map[string]interface{}{
-		"id":                    uuid.New().String(),
-		"branch_id":             body.BranchID,
-		"status":                "idle",
-		"content_hash":          "",
-		"active_version_id":     nil,
-		"last_indexed_version_id": nil,
+	if _, err := Chatbots.Get(ctx, body.BranchID); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Branch already has a chatbot", "chatbot_id": body.BranchID})
+		return
 	}
 
-	var inserted []Chatbot
-	_, err = SupabaseClient.
-		From("chatbots").
-		Insert(chatbot, false, "", "", "").
-		ExecuteTo(&inserted)
-	if err != nil || len(inserted) == 0 {
+	// Like CreateChatbot, the chatbot id is the branch id
+	inserted, err := Chatbots.Create(ctx, Chatbot{
+		ID:       body.BranchID,
+		BranchID: body.BranchID,
+		Status:   "idle",
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chatbot"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"chatbot_id": inserted[0].ID})
+	c.JSON(http.StatusCreated, gin.H{"chatbot_id": inserted.ID})
 }
 
 // New: Add a version (store-only) for a chatbot. No vector DB work.
 func AddChatbotVersion(c *gin.Context) {
 	chatbotID := c.Param("chatbotId")
 	var req struct {
-		Content json.RawMessage `json:"content" binding:"required"`
-		Notes   string          `json:"notes"`
-		CreatedBy string        `json:"created_by"`
-		MakeActive bool         `json:"make_active"`
+		Content       json.RawMessage `json:"content" binding:"required"`
+		ContentFormat string          `json:"content_format"`
+		Notes         string          `json:"notes"`
+		MakeActive    bool            `json:"make_active"`
+		Reindex       bool            `json:"reindex"` // with make_active, queue indexing of the new version
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !bindValidatedContent(c, req.Content, req.ContentFormat) {
+		return
+	}
 
 	// Ensure chatbot exists
-	var bots []Chatbot
-	_, err := SupabaseClient.
-		From("chatbots").
-		Select("*", "", false).
-		Eq("id", chatbotID).
-		ExecuteTo(&bots)
-	if err != nil || len(bots) == 0 {
+	ctx := c.Request.Context()
+	bot, err := Chatbots.Get(ctx, chatbotID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
 		return
 	}
 
-	h := generateHash(req.Content)
-	version := map[string]interface{}{
-		"id":           uuid.New().String(),
-		"chatbot_id":   chatbotID,
-		"content":      req.Content,
-		"content_hash": h,
-		"notes":        req.Notes,
-		"created_by":   req.CreatedBy,
-	}
-	var vInserted []ChatbotVersion
-	_, err = SupabaseClient.
-		From("chatbot_versions").
-		Insert(version, false, "", "", "").
-		ExecuteTo(&vInserted)
-	if err != nil || len(vInserted) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add version"})
-		return
+	// Content hashes are unique per chatbot; re-adding the same content returns the existing version
+	hash := generateHash(req.Content)
+	status := http.StatusCreated
+	vInserted, err := Versions.FindByHash(ctx, chatbotID, hash)
+	if err == nil {
+		status = http.StatusOK
+	} else {
+		vInserted, err = Versions.Create(ctx, ChatbotVersion{
+			ID:          uuid.New().String(),
+			ChatbotID:   chatbotID,
+			Content:     req.Content,
+			ContentHash: hash,
+			Notes:       req.Notes,
+			CreatedBy:   currentUser(c).ID,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add version"})
+			return
+		}
 	}
 
 	// Optionally mark as active
 	if req.MakeActive {
-		update := map[string]interface{}{
-			"active_version_id": vInserted[0].ID,
-		}
-		var updated []Chatbot
-		_, err = SupabaseClient.
-			From("chatbots").
-			Update(update, "", "").
-			Eq("id", chatbotID).
-			ExecuteTo(&updated)
-		if err != nil {
-			log.Printf("Warning: failed to set active version: %v", err)
-		}
+		activateVersion(c, bot, vInserted, req.Reindex)
+		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"version_id": vInserted[0].ID})
+	c.JSON(status, gin.H{"version_id": vInserted.ID, "index_state": indexState(bot)})
 }
 
 // New: Reindex a chatbot for a given version (or the active one) into Pinecone with selective upsert.
 func ReindexChatbot(c *gin.Context) {
 	chatbotID := c.Param("chatbotId") // equal to branch_id in simplified model
 	var body struct {
-		Content json.RawMessage `json:"content"` // optional; if omitted, use last stored content in chat_history or skip
-		Prune   bool            `json:"prune"`
+		Content   json.RawMessage `json:"content"`    // optional; if omitted, the version is indexed
+		VersionID string          `json:"version_id"` // optional; defaults to the active version, else the latest menu snapshot
+		DryRun    bool            `json:"dry_run"` // report which live vectors a new build would drop, without indexing
 	}
 	_ = c.ShouldBindJSON(&body) // accept empty
 
 	// Load chatbot
-	var bots []Chatbot
-	_, err := SupabaseClient.
-		From("chatbots").
-		Select("*", "", false).
-		Eq("id", chatbotID).
-		ExecuteTo(&bots)
-	if err != nil || len(bots) == 0 {
+	bot, err := Chatbots.Get(c.Request.Context(), chatbotID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
 		return
 	}
-	bot := bots[0]
 
 	// Fetch branch/restaurant for namespace
-	var branches []Branch
-	_, err = SupabaseClient.
-		From("branches").
-		Select("*", "", false).
-		Eq("id", bot.BranchID).
-		ExecuteTo(&branches)
-	if err != nil || len(branches) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load branch"})
-		return
-	}
-	branch := branches[0]
-
-	var restaurants []Restaurant
-	_, err = SupabaseClient.
-		From("restaurants").
-		Select("*", "", false).
-		Eq("id", branch.RestaurantID).
-		ExecuteTo(&restaurants)
-	if err != nil || len(restaurants) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load restaurant"})
-		return
-	}
-	restaurant := restaurants[0]
-
-	// Spawn background job: chunk -> embed -> upsert with selective diff
-	go func() {
-		// set status building
-		var tmp []Chatbot
-		_, _ = SupabaseClient.
-			From("chatbots").
-			Update(map[string]interface{}{"status": "building"}, "", "").
-			Eq("id", chatbotID).
-			ExecuteTo(&tmp)
-
-		namespace := fmt.Sprintf("%s_%s", restaurant.ID, strings.ReplaceAll(branch.Name, " ", "_"))
-		ctx := context.Background()
-		var content json.RawMessage
-		switch {
-		case len(body.Content) > 0:
-			content = body.Content
-		default:
-			// Try to fetch latest menu snapshot for this branch
-			var snaps []MenuSnapshot
-			_, err := SupabaseClient.
-				From("menu_snapshots").
-				Select("*", "", false).
-				Eq("branch_id", branch.ID).
-				ExecuteTo(&snaps)
-			if err != nil || len(snaps) == 0 {
-				log.Printf("Reindex: no content provided and no menu snapshot found; abort")
-				updateChatbotStatus(chatbotID, "error")
-				return
-			}
-			latest := snaps[0]
-			for _, s := range snaps[1:] {
-				if s.CreatedAt.After(latest.CreatedAt) {
-					latest = s
-				}
-			}
-			content = latest.Content
-		}
+	branch, restaurant, ok := loadBranchContext(c, bot.BranchID)
+	if !ok {
+		return
+	}
 
-		chunks, err := chunkContent(content)
-		if err != nil {
-			log.Printf("Reindex: chunking error: %v", err)
-			updateChatbotStatus(chatbotID, "error")
+	// Explicit content wins; otherwise index the requested version, defaulting to the active one
+	if len(body.Content) > 0 && body.VersionID != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Send content or version_id, not both"})
+		return
+	}
+	payload := IndexJobPayload{BranchID: branch.ID, Content: body.Content, VersionID: body.VersionID}
+	if len(body.Content) == 0 && payload.VersionID == "" {
+		payload.VersionID = bot.ActiveVersionID
+	}
+	if payload.VersionID != "" {
+		if _, ok := bindChatbotVersion(c, bot.ID, payload.VersionID); !ok {
 			return
 		}
-		for i := range chunks {
-			chunks[i].Metadata.RestaurantID = restaurant.ID
-			chunks[i].Metadata.BranchID = branch.ID
-		}
+	}
 
-		chunks, err = generateEmbeddings(ctx, chunks)
+	// Dry run: chunking is cheap and IDs are deterministic, so the diff against the live index needs no embeddings
+	if body.DryRun {
+		ctx := c.Request.Context()
+		namespace := liveNamespace(bot, restaurant.ID, branch.ID)
+		content, err := resolveIndexContent(ctx, bot.ID, branch.ID, payload)
 		if err != nil {
-			log.Printf("Reindex: embeddings error: %v", err)
-			updateChatbotStatus(chatbotID, "error")
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		if err := storeChunksInPinecone(ctx, chunks, namespace); err != nil {
-			log.Printf("Reindex: store error: %v", err)
-			updateChatbotStatus(chatbotID, "error")
+		chunks, err := prepareChunks(content, restaurant.ID, branch.ID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		// Optionally prune: not implemented here; would require computing missing IDs.
-
-		// success: if content changed (hash differs), increment version
-		newHash := generateHash(content)
-		newVersion := bot.Version
-		if newHash != bot.ContentHash {
-			newVersion = bot.Version + 1
-		}
-		update := map[string]interface{}{
-			"status":       "active",
-			"content_hash": newHash,
-			"version":      newVersion,
-		}
-		var updated []Chatbot
-		_, err = SupabaseClient.
-			From("chatbots").
-			Update(update, "", "").
-			Eq("id", chatbotID).
-			ExecuteTo(&updated)
+		result, err := pruneStaleVectors(ctx, namespace, chunks, true)
 		if err != nil {
-			log.Printf("Reindex: failed to update chatbot: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute prune diff", "details": err.Error()})
+			return
 		}
-	}()
+		c.JSON(http.StatusOK, gin.H{
+			"chatbot_id":   chatbotID,
+			"chunks":       len(chunks),
+			"would_delete": len(result.StaleIDs),
+			"prune":        result,
+		})
+		return
+	}
+
+	// Queue background job: chunk -> embed -> upsert with selective diff
+	job, err := enqueueIndexJob(c.Request.Context(), chatbotID, payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue reindex", "details": err.Error()})
+		return
+	}
+	log.Printf("Queued reindex for branch %s (%s)", branch.Name, restaurant.Name)
 
 	c.JSON(http.StatusAccepted, gin.H{
-		"message":    "Reindex started",
-		"chatbot_id": chatbotID,
+		"message":     "Reindex started",
+		"chatbot_id":  chatbotID,
+		"job_id":      job.ID,
+		"version_id":  payload.VersionID,
+		"index_state": indexState(bot),
 	})
 }
 
@@ -632,7 +405,8 @@ func QueryChatbot(c *gin.Context) {
 	branchID := c.Param("branchId")
 
 	var query struct {
-		Question string `json:"question" binding:"required"`
+		Question  string `json:"question" binding:"required"`
+		SessionID string `json:"session_id"` // guest session; branch owners may omit it to preview
 	}
 
 	if err := c.ShouldBindJSON(&query); err != nil {
@@ -640,44 +414,17 @@ func QueryChatbot(c *gin.Context) {
 		return
 	}
 
-	// Get branch info
-	var branches []Branch
-	_, err := SupabaseClient.
-		From("branches").
-		Select("*", "", false).
-		Eq("id", branchID).
-		ExecuteTo(&branches)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branch", "details": err.Error()})
-		return
-	}
-	if len(branches) == 0 { // Use len() instead of count
-		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
-		return
-	}
-
-	branch := branches[0]
-
-	// Get restaurant info
-	var restaurants []Restaurant
-	_, err = SupabaseClient.
-		From("restaurants").
-		Select("*", "", false).
-		Eq("id", branch.RestaurantID).
-		ExecuteTo(&restaurants)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get restaurant", "details": err.Error()})
+	// Get branch and restaurant info
+	branch, restaurant, ok := loadBranchContext(c, branchID)
+	if !ok {
 		return
 	}
-	if len(restaurants) == 0 { // Use len() instead of count
-		c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+	if !authorizeGuest(c, branch.ID, &query.SessionID) {
 		return
 	}
 
-	restaurant := restaurants[0]
-
-	// Create namespace
-	namespace := fmt.Sprintf("%s_%s", restaurant.ID, strings.ReplaceAll(branch.Name, " ", "_"))
+	// Read from the chatbot's live index build
+	namespace := chatbotNamespace(c.Request.Context(), restaurant.ID, branch.ID)
 
 	// Generate embedding for the query
 	ctx := context.Background()
@@ -688,7 +435,8 @@ func QueryChatbot(c *gin.Context) {
 	}
 
 	// Query Pinecone - pass the user's question
-	response, err := queryChatbotInPinecone(ctx, embedding, namespace, query.Question)
+	opts := newQueryOptions(branch, chatbotSettings(ctx, branch.ID))
+	response, err := queryChatbotInPinecone(ctx, embedding, namespace, query.Question, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query knowledge base"})
 		return
@@ -701,75 +449,49 @@ func QueryChatbot(c *gin.Context) {
 func SaveMenuSnapshot(c *gin.Context) {
 	branchID := c.Param("branchId")
 	var body struct {
-		Content   json.RawMessage `json:"content" binding:"required"`
-		Notes     string          `json:"notes"`
-		CreatedBy string          `json:"created_by"`
+		Content       json.RawMessage `json:"content" binding:"required"`
+		ContentFormat string          `json:"content_format"`
+		Notes         string          `json:"notes"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Ensure branch exists and user owns it via RLS
-	var branches []Branch
-	_, err := SupabaseClient.
-		From("branches").
-		Select("*", "", false).
-		Eq("id", branchID).
-		ExecuteTo(&branches)
-	if err != nil || len(branches) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+	if !bindValidatedContent(c, body.Content, body.ContentFormat) {
 		return
 	}
 
-	snapshot := map[string]interface{}{
-		"branch_id":    branchID,
-		"content":      body.Content,
-		"content_hash": generateHash(body.Content),
-		"notes":        body.Notes,
-		"created_by":   body.CreatedBy,
+	// Ensure branch exists
+	ctx := c.Request.Context()
+	if _, err := Branches.Get(ctx, branchID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return
 	}
 
-	var inserted []MenuSnapshot
-	_, err = SupabaseClient.
-		From("menu_snapshots").
-		Insert(snapshot, false, "", "", "").
-		ExecuteTo(&inserted)
-	if err != nil || len(inserted) == 0 {
+	inserted, err := saveMenuSnapshot(ctx, branchID, body.Content, body.Notes, currentUser(c).ID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save snapshot"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"snapshot_id": inserted[0].ID,
-		"content_hash": inserted[0].ContentHash,
-		"created_at":   inserted[0].CreatedAt,
+		"snapshot_id":  inserted.ID,
+		"content_hash": inserted.ContentHash,
+		"created_at":   inserted.CreatedAt,
 	})
 }
 
 // GetLatestMenuSnapshot returns the latest stored JSON snapshot for a branch
 func GetLatestMenuSnapshot(c *gin.Context) {
 	branchID := c.Param("branchId")
-	var rows []MenuSnapshot
-	_, err := SupabaseClient.
-		From("menu_snapshots").
-		Select("*", "", false).
-		Eq("branch_id", branchID).
-		ExecuteTo(&rows)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch snapshot", "details": err.Error()})
-		return
-	}
-	if len(rows) == 0 {
+	latest, err := Snapshots.Latest(c.Request.Context(), branchID)
+	if errors.Is(err, ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "No snapshots found"})
 		return
 	}
-	// Pick the row with max CreatedAt
-	latest := rows[0]
-	for _, r := range rows[1:] {
-		if r.CreatedAt.After(latest.CreatedAt) {
-			latest = r
-		}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch snapshot", "details": err.Error()})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{"snapshot": latest})
 }
@@ -778,6 +500,7 @@ type QueryWithHistoryRequest struct {
 	Question  string `json:"question" binding:"required"`
 	SessionID string `json:"session_id"`
 	Language  string `json:"language"`
+	Stream    bool   `json:"stream"` // stream the answer as Server-Sent Events
 }
 
 func QueryChatbotWithHistory(c *gin.Context) {
@@ -789,9 +512,6 @@ func QueryChatbotWithHistory(c *gin.Context) {
 	}
 
 	// Set defaults
-	if query.SessionID == "" {
-		query.SessionID = uuid.New().String()
-	}
 	if query.Language == "" {
 		query.Language = "en"
 	}
@@ -799,43 +519,19 @@ func QueryChatbotWithHistory(c *gin.Context) {
 	ctx := context.Background()
 
 	// Get branch information (same as QueryChatbot)
-	var branches []Branch
-	_, err := SupabaseClient.
-		From("branches").
-		Select("*", "", false).
-		Eq("id", branchID).
-		ExecuteTo(&branches)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branch", "details": err.Error()})
-		return
-	}
-	if len(branches) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+	branch, restaurant, ok := loadBranchContext(c, branchID)
+	if !ok {
 		return
 	}
-
-	branch := branches[0]
-
-	var restaurants []Restaurant
-	_, err = SupabaseClient.
-		From("restaurants").
-		Select("*", "", false).
-		Eq("id", branch.RestaurantID).
-		ExecuteTo(&restaurants)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get restaurant", "details": err.Error()})
+	// The guest session doubles as the chat history id
+	if !authorizeGuest(c, branch.ID, &query.SessionID) {
 		return
 	}
-	if len(restaurants) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
-		return
-	}
-
-	restaurant := restaurants[0]
 
-	namespace := fmt.Sprintf("%s_%s", restaurant.ID, strings.ReplaceAll(branch.Name, " ", "_"))
+	namespace := chatbotNamespace(ctx, restaurant.ID, branch.ID)
 
-	history, err := getChatHistory(query.SessionID, 10)
+	settings := chatbotSettings(ctx, branch.ID)
+	history, err := getChatHistory(query.SessionID, settings.HistoryTurns)
 	if err != nil {
 		log.Printf("Error getting chat history: %v", err)
 		history = []ChatHistory{}
@@ -847,8 +543,14 @@ func QueryChatbotWithHistory(c *gin.Context) {
 		return
 	}
 
+	opts := newQueryOptions(branch, settings)
+	if query.Stream || c.Query("stream") == "true" || strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		streamQueryWithHistory(c, branch.ID, embedding, namespace, query, history, opts)
+		return
+	}
+
 	// Query vector database with correct namespace
-	response, err := queryChatbotInPineconeWithHistory(ctx, embedding, namespace, query.Question, history, query.Language)
+	response, err := queryChatbotInPineconeWithHistory(ctx, embedding, namespace, query.Question, history, query.Language, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query knowledge base"})
 		return
@@ -856,7 +558,7 @@ func QueryChatbotWithHistory(c *gin.Context) {
 
 	// Store the interaction
 	if responseStr, ok := response["response"].(string); ok {
-		err = storeInteraction(query.SessionID, query.Question, responseStr, query.Language)
+		err = storeInteraction(branch.ID, query.SessionID, query.Question, responseStr, query.Language)
 		if err != nil {
 			log.Printf("Warning: Failed to store interaction: %v", err)
 		}
@@ -867,6 +569,81 @@ func QueryChatbotWithHistory(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
+
+// streamQueryWithHistory sends the answer as SSE 'token' events while it is generated, then a 'done' event
+// carrying the same body as the non-streaming response (response, context, session_id, debug).
+// The interaction is stored once the stream completes; if the model fails part way an 'error' event
+// ends the stream instead and nothing is stored.
+func streamQueryWithHistory(c *gin.Context, branchID string, embedding []float32, namespace string, query QueryWithHistoryRequest, history []ChatHistory, opts queryOptions) {
+	ctx := c.Request.Context()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	response, err := streamChatbotInPineconeWithHistory(ctx, embedding, namespace, query.Question, history, query.Language, opts, func(token string) error {
+		c.SSEvent("token", gin.H{"text": token})
+		c.Writer.Flush()
+		return ctx.Err()
+	})
+	if ctx.Err() != nil {
+		log.Printf("Client disconnected before the answer completed (session %s)", query.SessionID)
+		return
+	}
+	if errors.Is(err, errAnswerInterrupted) {
+		// The partial answer is not stored, so it cannot feed the next question's history
+		c.SSEvent("error", gin.H{"error": "The answer was interrupted, please ask again"})
+		c.Writer.Flush()
+		return
+	}
+	if err != nil {
+		c.SSEvent("error", gin.H{"error": "Failed to query knowledge base"})
+		c.Writer.Flush()
+		return
+	}
+
+	if responseStr, ok := response["response"].(string); ok {
+		if err := storeInteraction(branchID, query.SessionID, query.Question, responseStr, query.Language); err != nil {
+			log.Printf("Warning: Failed to store interaction: %v", err)
+		}
+	}
+
+	response["session_id"] = query.SessionID
+	c.SSEvent("done", response)
+	c.Writer.Flush()
+}
+
+// loadBranchContext fetches a branch and its restaurant, writing a 404/500 response on failure
+func loadBranchContext(c *gin.Context, branchID string) (Branch, Restaurant, bool) {
+	ctx := c.Request.Context()
+
+	branch, err := Branches.Get(ctx, branchID)
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("Branch not found - ID: %s", branchID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return Branch{}, Restaurant{}, false
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branch", "details": err.Error()})
+		return Branch{}, Restaurant{}, false
+	}
+
+	restaurant, err := Restaurants.Get(ctx, branch.RestaurantID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+		return Branch{}, Restaurant{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get restaurant", "details": err.Error()})
+		return Branch{}, Restaurant{}, false
+	}
+
+	return branch, restaurant, true
+}
+
 func generateHash(content json.RawMessage) string {
 	hash := sha256.Sum256(content)
 	return hex.EncodeToString(hash[:])
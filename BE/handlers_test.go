@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// offlineServer wires the whole backend with no external services: in-memory repositories,
+// VECTOR_STORE=memory, EMBEDDER=hash and CHAT_MODEL=echo, plus a running job worker
+func offlineServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("VECTOR_STORE", "memory")
+	t.Setenv("VECTOR_STORE_PATH", "")
+	t.Setenv("EMBEDDER", "hash")
+	t.Setenv("CHAT_MODEL", "echo")
+	t.Setenv("CHAT_ECHO_TEMPLATE", "")
+	t.Setenv("JOB_WORKERS", "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var err error
+	if Vectors, err = newVectorStoreFromEnv(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := initAIModels(ctx); err != nil {
+		t.Fatal(err)
+	}
+	useMemoryRepositories()
+	oldTokens := Tokens
+	Tokens = &jwtVerifier{secret: []byte("offline-secret"), audience: "authenticated"}
+
+	workers := StartJobWorkers(ctx)
+	r := gin.New()
+	RegisterRoutes(r)
+	srv := httptest.NewServer(r)
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+		workers.Wait()
+		Tokens = oldTokens
+	})
+	return srv
+}
+
+// call sends a JSON request and decodes the JSON response, failing unless the status is want
+func call(t *testing.T, srv *httptest.Server, token, method, path string, body interface{}, want int) gin.H {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, srv.URL+path, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out gin.H
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("%s %s: undecodable response: %v", method, path, err)
+	}
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s: status %d, want %d: %v", method, path, resp.StatusCode, want, out)
+	}
+	return out
+}
+
+// waitForJob polls a job until it finishes and fails the test unless it succeeded
+func waitForJob(t *testing.T, srv *httptest.Server, token, jobID string) gin.H {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		job := call(t, srv, token, http.MethodGet, "/jobs/"+jobID, nil, http.StatusOK)
+		switch job["status"] {
+		case "succeeded":
+			return job
+		case "dead":
+			t.Fatalf("job %s failed: %v", jobID, job["last_error"])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", jobID)
+	return nil
+}
+
+func TestOfflineCreateReindexQuery(t *testing.T) {
+	srv := offlineServer(t)
+	session, err := issueToken(AuthUser{ID: "owner-1"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := session.Token
+
+	restaurant := call(t, srv, token, http.MethodPost, "/restaurants", gin.H{"name": "Warung Offline"}, http.StatusCreated)
+	branch := call(t, srv, token, http.MethodPost, "/branches", gin.H{
+		"restaurant_id": restaurant["id"],
+		"name":          "Main Street",
+	}, http.StatusCreated)
+	branchID := branch["id"].(string)
+
+	// Create: the first menu becomes version 1 and is indexed in the background
+	created := call(t, srv, token, http.MethodPost, "/chatbots", gin.H{
+		"branch_id": branchID,
+		"content": json.RawMessage(`{"sections": [{"name": "Mains", "items": [
+			{"name": "Nasi Goreng", "description": "Fried rice with egg and chicken", "price": {"amount": 35000}},
+			{"name": "Mie Ayam", "description": "Chicken noodles", "price": {"amount": 30000}}
+		]}]}`),
+	}, http.StatusAccepted)
+	chatbotID := created["chatbot_id"].(string)
+	job := waitForJob(t, srv, token, created["job_id"].(string))
+	if progress := job["progress"].(map[string]interface{}); progress["vectors_upserted"] != float64(2) {
+		t.Errorf("first build progress = %v, want 2 vectors upserted", progress)
+	}
+	if versions, _ := Versions.List(context.Background(), chatbotID); len(versions) != 1 || versions[0].CreatedBy != "owner-1" {
+		t.Errorf("versions = %+v, want one created by the signed-in owner", versions)
+	}
+
+	// Reindex with new content: the fresh build replaces the old one
+	reindex := call(t, srv, token, http.MethodPost, "/chatbots/"+chatbotID+"/reindex", gin.H{
+		"content": json.RawMessage(`{"sections": [{"name": "Mains", "items": [
+			{"name": "Nasi Goreng", "description": "Fried rice with egg and chicken", "price": {"amount": 35000}},
+			{"name": "Sate Kambing", "description": "Grilled goat skewers with peanut sauce", "price": {"amount": 45000}}
+		]}]}`),
+	}, http.StatusAccepted)
+	job = waitForJob(t, srv, token, reindex["job_id"].(string))
+	if progress := job["progress"].(map[string]interface{}); progress["vectors_deleted"] != float64(1) {
+		t.Errorf("reindex progress = %v, want 1 vector deleted", progress)
+	}
+	bot := call(t, srv, token, http.MethodGet, "/chatbots/"+chatbotID, nil, http.StatusOK)["chatbot"].(map[string]interface{})
+	if bot["status"] != "active" || bot["active_namespace"] == "" {
+		t.Fatalf("chatbot after reindex = %v", bot)
+	}
+
+	// Query as a guest: the echo model repeats the question and the knowledge it was given
+	guest := call(t, srv, token, http.MethodPost, "/branches/"+branchID+"/sessions", gin.H{}, http.StatusCreated)
+	sessionID := guest["id"].(string)
+	answer := call(t, srv, "", http.MethodPost, "/branches/"+branchID+"/query-with-history", gin.H{
+		"question":   "Do you have goat skewers?",
+		"session_id": sessionID,
+	}, http.StatusOK)
+	response := answer["response"].(string)
+	if !strings.HasPrefix(response, "You asked: Do you have goat skewers?") {
+		t.Errorf("response = %q, want the echoed question", response)
+	}
+	if !strings.Contains(response, "Sate Kambing") {
+		t.Errorf("response = %q, want the reindexed dish", response)
+	}
+	if strings.Contains(response, "Mie Ayam") {
+		t.Errorf("response = %q mentions a dish the reindex removed", response)
+	}
+	if answer["session_id"] != sessionID {
+		t.Errorf("session_id = %v, want %s", answer["session_id"], sessionID)
+	}
+
+	// The interaction was stored and feeds the next question's history
+	history, _ := Histories.Recent(context.Background(), sessionID, 10)
+	if len(history) != 1 || history[0].Response != response || history[0].BranchID != branchID {
+		t.Errorf("stored history = %+v", history)
+	}
+
+	// Guests without a session are turned away
+	call(t, srv, "", http.MethodPost, "/branches/"+branchID+"/query-with-history", gin.H{"question": "Hi"}, http.StatusUnauthorized)
+}
+
+// interruptedChatModel streams the first words of an answer and then fails
+type interruptedChatModel struct{}
+
+func (interruptedChatModel) Generate(ctx context.Context, prompt string) (string, error) {
+	return "", errors.New("model unavailable")
+}
+
+func (interruptedChatModel) GenerateStream(ctx context.Context, prompt string, onToken func(string) error) (string, error) {
+	if err := onToken("Yes, we have "); err != nil {
+		return "", err
+	}
+	return "Yes, we have ", errors.New("stream reset")
+}
+
+func TestStreamingQueryInterrupted(t *testing.T) {
+	srv := offlineServer(t)
+	session, err := issueToken(AuthUser{ID: "owner-1"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := session.Token
+	restaurant := call(t, srv, token, http.MethodPost, "/restaurants", gin.H{"name": "Warung Stream"}, http.StatusCreated)
+	branchID := call(t, srv, token, http.MethodPost, "/branches", gin.H{"restaurant_id": restaurant["id"], "name": "Main"}, http.StatusCreated)["id"].(string)
+	created := call(t, srv, token, http.MethodPost, "/chatbots", gin.H{
+		"branch_id": branchID,
+		"content":   json.RawMessage(`{"sections": [{"name": "Mains", "items": [{"name": "Sate Kambing", "price": {"amount": 45000}}]}]}`),
+	}, http.StatusAccepted)
+	waitForJob(t, srv, token, created["job_id"].(string))
+	sessionID := call(t, srv, token, http.MethodPost, "/branches/"+branchID+"/sessions", gin.H{}, http.StatusCreated)["id"].(string)
+
+	stream := func() string {
+		t.Helper()
+		body := strings.NewReader(`{"question": "Do you have sate?", "stream": true, "session_id": "` + sessionID + `"}`)
+		resp, err := http.Post(srv.URL+"/branches/"+branchID+"/query-with-history", "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		events, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(events)
+	}
+
+	// A complete answer ends with 'done' and is stored
+	if events := stream(); !strings.Contains(events, "event:done") || strings.Contains(events, "event:error") {
+		t.Fatalf("complete stream = %q", events)
+	}
+	if history, _ := Histories.Recent(context.Background(), sessionID, 10); len(history) != 1 {
+		t.Fatalf("stored %d interactions, want 1", len(history))
+	}
+
+	// A model failure after the first tokens ends with 'error' and stores nothing
+	oldChat := Chat
+	Chat = interruptedChatModel{}
+	defer func() { Chat = oldChat }()
+	events := stream()
+	if !strings.Contains(events, "event:token") || !strings.Contains(events, "event:error") || strings.Contains(events, "event:done") {
+		t.Errorf("interrupted stream = %q", events)
+	}
+	if history, _ := Histories.Recent(context.Background(), sessionID, 10); len(history) != 1 {
+		t.Errorf("stored %d interactions after the interrupted answer, want 1", len(history))
+	}
+}
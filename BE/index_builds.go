@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultIndexRetention is how long a superseded build is kept for rollback (INDEX_RETENTION)
+	defaultIndexRetention = 72 * time.Hour
+	indexGCInterval       = time.Hour
+)
+
+// buildNamespace names the namespace of one index build: the branch namespace plus a build suffix
+func buildNamespace(restaurantID, branchID, buildID string) string {
+	return fmt.Sprintf("%s_%s", branchNamespace(restaurantID, branchID), strings.ReplaceAll(buildID, "-", "")[:12])
+}
+
+// liveNamespace is the namespace a chatbot answers from. Chatbots indexed before blue/green
+// builds have no pointer yet and still use the plain branch namespace.
+func liveNamespace(bot Chatbot, restaurantID, branchID string) string {
+	if bot.ActiveNamespace != "" {
+		return bot.ActiveNamespace
+	}
+	return branchNamespace(restaurantID, branchID)
+}
+
+// chatbotNamespace looks up the live namespace for a branch's chatbot (chatbot id equals branch id)
+func chatbotNamespace(ctx context.Context, restaurantID, branchID string) string {
+	bot, err := Chatbots.Get(ctx, branchID)
+	if err != nil {
+		return branchNamespace(restaurantID, branchID)
+	}
+	return liveNamespace(bot, restaurantID, branchID)
+}
+
+// startIndexBuild records a new build writing into its own, empty namespace
+func startIndexBuild(ctx context.Context, bot Chatbot, restaurantID, branchID, jobID, versionID, contentHash string) (IndexBuild, error) {
+	id := uuid.New().String()
+	return Builds.Create(ctx, IndexBuild{
+		ID:          id,
+		ChatbotID:   bot.ID,
+		JobID:       jobID,
+		Namespace:   buildNamespace(restaurantID, branchID, id),
+		VersionID:   versionID,
+		ContentHash: contentHash,
+		Status:      BuildBuilding,
+	})
+}
+
+// failIndexBuild discards a build that did not finish. Its namespace never went live, so it is
+// deleted right away; if that fails the build stays 'failed' and garbage collection retries.
+func failIndexBuild(build IndexBuild, cause error) {
+	ctx := context.Background()
+	status := BuildFailed
+	if err := Vectors.DeleteNamespace(ctx, build.Namespace); err != nil {
+		log.Printf("Index build %s: failed to delete namespace %s: %v", build.ID, build.Namespace, err)
+	} else {
+		status = BuildDeleted
+	}
+	if err := Builds.Update(ctx, build.ID, map[string]interface{}{"status": status, "error": cause.Error()}); err != nil {
+		log.Printf("Index build %s: %v", build.ID, err)
+	}
+}
+
+// switchToBuild points the chatbot at build in a single row update, so queries move from the old
+// namespace to the new one at once, then retires the previously live build. extra is merged
+// into the chatbot update.
+func switchToBuild(ctx context.Context, bot Chatbot, build IndexBuild, extra map[string]interface{}) error {
+	now := time.Now().UTC()
+	update := map[string]interface{}{
+		"status":                  "active",
+		"active_namespace":        build.Namespace,
+		"active_build_id":         build.ID,
+		"content_hash":            build.ContentHash,
+		"last_indexed_version_id": nullIfEmpty(build.VersionID),
+	}
+	for k, v := range extra {
+		update[k] = v
+	}
+	if err := Chatbots.Update(ctx, bot.ID, update); err != nil {
+		return fmt.Errorf("failed to switch chatbot to build %s: %w", build.ID, err)
+	}
+
+	if err := Builds.Update(ctx, build.ID, map[string]interface{}{"status": BuildLive, "activated_at": now, "retired_at": nil}); err != nil {
+		log.Printf("Index build %s: %v", build.ID, err)
+	}
+
+	switch {
+	case bot.ActiveBuildID != "" && bot.ActiveBuildID != build.ID:
+		if err := Builds.Update(ctx, bot.ActiveBuildID, map[string]interface{}{"status": BuildRetired, "retired_at": now}); err != nil {
+			log.Printf("Index build %s: %v", bot.ActiveBuildID, err)
+		}
+	case bot.ActiveBuildID == "" && bot.ContentHash != "":
+		// First blue/green build of a chatbot indexed in place: track the old namespace so it can be
+		// rolled back to and garbage collected like any other build
+		branch, err := Branches.Get(ctx, bot.BranchID)
+		if err != nil {
+			log.Printf("Chatbot %s: cannot record legacy namespace: %v", bot.ID, err)
+			break
+		}
+		legacy := IndexBuild{
+			ChatbotID:   bot.ID,
+			Namespace:   branchNamespace(branch.RestaurantID, branch.ID),
+			VersionID:   bot.LastIndexedVersionID,
+			ContentHash: bot.ContentHash,
+			Status:      BuildRetired,
+			RetiredAt:   &now,
+		}
+		if _, err := Builds.Create(ctx, legacy); err != nil {
+			log.Printf("Chatbot %s: cannot record legacy namespace: %v", bot.ID, err)
+		}
+	}
+	log.Printf("Chatbot %s now serves build %s (%s)", bot.ID, build.ID, build.Namespace)
+	return nil
+}
+
+// retainedBuildFor returns the newest retired build of versionID whose namespace still exists
+func retainedBuildFor(ctx context.Context, chatbotID, versionID string) (IndexBuild, error) {
+	builds, err := Builds.List(ctx, chatbotID)
+	if err != nil {
+		return IndexBuild{}, err
+	}
+	for _, b := range builds {
+		if b.Status == BuildRetired && b.VersionID == versionID {
+			return b, nil
+		}
+	}
+	return IndexBuild{}, ErrNotFound
+}
+
+// indexRetention reads INDEX_RETENTION (a Go duration such as 72h)
+func indexRetention() time.Duration {
+	if v := os.Getenv("INDEX_RETENTION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("Warning: invalid INDEX_RETENTION %q, using %s", v, defaultIndexRetention)
+	}
+	return defaultIndexRetention
+}
+
+// collectIndexGarbage deletes the namespaces of failed builds and of builds retired longer than
+// the retention period. It returns how many namespaces were removed.
+func collectIndexGarbage(ctx context.Context) (int, error) {
+	builds, err := Builds.ListGarbage(ctx, time.Now().Add(-indexRetention()))
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, b := range builds {
+		// Never delete what a chatbot is serving, whatever the build row says
+		if bot, err := Chatbots.Get(ctx, b.ChatbotID); err == nil && bot.ActiveNamespace == b.Namespace {
+			continue
+		}
+		if err := Vectors.DeleteNamespace(ctx, b.Namespace); err != nil {
+			log.Printf("Index GC: build %s: %v", b.ID, err)
+			continue
+		}
+		if err := Builds.Update(ctx, b.ID, map[string]interface{}{"status": BuildDeleted}); err != nil {
+			log.Printf("Index GC: build %s: %v", b.ID, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// StartIndexGC runs index garbage collection hourly until ctx is cancelled
+func StartIndexGC(ctx context.Context) {
+	go func() {
+		t := time.NewTicker(indexGCInterval)
+		defer t.Stop()
+		for {
+			if n, err := collectIndexGarbage(ctx); err != nil {
+				log.Printf("Index GC failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Index GC: deleted %d old namespaces", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// ListIndexBuilds returns a chatbot's index builds, newest first
+func ListIndexBuilds(c *gin.Context) {
+	chatbotID := c.Param("chatbotId")
+	ctx := c.Request.Context()
+
+	bot, err := Chatbots.Get(ctx, chatbotID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
+		return
+	}
+	builds, err := Builds.List(ctx, chatbotID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list index builds", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"builds":           builds,
+		"active_build_id":  bot.ActiveBuildID,
+		"active_namespace": bot.ActiveNamespace,
+		"retention":        indexRetention().String(),
+	})
+}
+
+// RollbackIndexBuild switches a chatbot back to a retained build without re-embedding anything.
+// Without a build_id it goes back to the most recently retired build.
+func RollbackIndexBuild(c *gin.Context) {
+	chatbotID := c.Param("chatbotId")
+	var body struct {
+		BuildID string `json:"build_id"`
+	}
+	_ = c.ShouldBindJSON(&body) // accept empty
+
+	ctx := c.Request.Context()
+	bot, err := Chatbots.Get(ctx, chatbotID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
+		return
+	}
+
+	var target IndexBuild
+	if body.BuildID != "" {
+		target, err = Builds.Get(ctx, body.BuildID)
+		if errors.Is(err, ErrNotFound) || (err == nil && target.ChatbotID != chatbotID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build", "details": err.Error()})
+			return
+		}
+		if target.Status != BuildRetired {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Build is %s; only retired builds can be rolled back to", target.Status)})
+			return
+		}
+	} else {
+		builds, err := Builds.List(ctx, chatbotID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list index builds", "details": err.Error()})
+			return
+		}
+		for _, b := range builds {
+			if b.Status == BuildRetired && (target.RetiredAt == nil || (b.RetiredAt != nil && b.RetiredAt.After(*target.RetiredAt))) {
+				target = b
+			}
+		}
+		if target.ID == "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "No retained build to roll back to"})
+			return
+		}
+	}
+
+	// Keep the active version in step with what is served
+	extra := map[string]interface{}{}
+	if target.VersionID != "" {
+		extra["active_version_id"] = target.VersionID
+	}
+	if err := switchToBuild(ctx, bot, target, extra); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Rolled back",
+		"chatbot_id":        bot.ID,
+		"active_build_id":   target.ID,
+		"active_namespace":  target.Namespace,
+		"previous_build_id": bot.ActiveBuildID,
+		"version_id":        target.VersionID,
+	})
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindIndex       = "index"
+	defaultMaxAttempts = 5
+	jobLease           = 90 * time.Second
+	jobPollInterval    = 5 * time.Second
+	jobBackoffBase     = 10 * time.Second
+	jobBackoffMax      = 10 * time.Minute
+)
+
+// jobWake nudges idle workers when a job is enqueued so they don't wait for the next poll
+var jobWake = make(chan struct{}, 1)
+
+// enqueueIndexJob persists an indexing job for a chatbot and wakes a worker
+func enqueueIndexJob(ctx context.Context, chatbotID string, payload IndexJobPayload) (IndexJob, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return IndexJob{}, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+	job, err := Jobs.Enqueue(ctx, IndexJob{
+		Kind:        jobKindIndex,
+		ChatbotID:   chatbotID,
+		Payload:     data,
+		MaxAttempts: defaultMaxAttempts,
+	})
+	if err != nil {
+		return IndexJob{}, err
+	}
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+	log.Printf("Enqueued index job %s for chatbot %s", job.ID, chatbotID)
+	return job, nil
+}
+
+// RecoverJobs requeues jobs whose worker died mid-run (expired lease). Called at startup.
+func RecoverJobs(ctx context.Context) {
+	n, err := Jobs.RecoverExpired(ctx)
+	if err != nil {
+		log.Printf("Warning: job recovery failed: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Recovered %d interrupted jobs", n)
+	}
+}
+
+// StartJobWorkers starts JOB_WORKERS (default 2) workers that run until ctx is cancelled
+func StartJobWorkers(ctx context.Context) *sync.WaitGroup {
+	workers := 2
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			workers = n
+		}
+	}
+
+	host, _ := os.Hostname()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		owner := fmt.Sprintf("%s/%d/%s", host, i, uuid.New().String()[:8])
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runJobWorker(ctx, owner)
+		}()
+	}
+	log.Printf("Started %d job workers", workers)
+	return &wg
+}
+
+// runJobWorker claims and runs jobs until ctx is cancelled
+func runJobWorker(ctx context.Context, owner string) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	polls := 0
+	for {
+		for {
+			job, err := Jobs.Claim(ctx, owner, jobLease)
+			if errors.Is(err, ErrNotFound) {
+				break
+			}
+			if err != nil {
+				log.Printf("Job worker %s: claim failed: %v", owner, err)
+				break
+			}
+			runClaimedJob(ctx, owner, job)
+		}
+
+		// Leases of crashed peers expire; pick those jobs up every few polls
+		polls++
+		if polls%6 == 0 {
+			RecoverJobs(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-jobWake:
+		}
+	}
+}
+
+// runClaimedJob runs one leased job, keeping the lease alive, and records the outcome
+func runClaimedJob(ctx context.Context, owner string, job IndexJob) {
+	log.Printf("Job %s: attempt %d/%d (chatbot %s)", job.ID, job.Attempts, job.MaxAttempts, job.ChatbotID)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Heartbeat: extend the lease while the job runs; if the lease is lost, stop working
+	go func() {
+		t := time.NewTicker(jobLease / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-t.C:
+				if err := Jobs.ExtendLease(jobCtx, job.ID, owner, jobLease); err != nil {
+					log.Printf("Job %s: %v; cancelling", job.ID, err)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	var err error
+	switch job.Kind {
+	case jobKindIndex:
+		err = runIndexJob(jobCtx, job)
+	default:
+		err = fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+
+	// Record the outcome even if the job context was cancelled
+	recordCtx := context.Background()
+	if err == nil {
+		if err := Jobs.Complete(recordCtx, job.ID, owner); err != nil {
+			log.Printf("Job %s: %v", job.ID, err)
+		}
+		log.Printf("Job %s: succeeded", job.ID)
+		IndexEvents.Publish(IndexEvent{Type: "done", ChatbotID: job.ChatbotID, JobID: job.ID, Status: "active", Attempt: job.Attempts})
+		return
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		log.Printf("Job %s: failed permanently after %d attempts: %v", job.ID, job.Attempts, err)
+		if ferr := Jobs.Fail(recordCtx, job.ID, owner, err.Error(), nil); ferr != nil {
+			log.Printf("Job %s: %v", job.ID, ferr)
+		}
+		// A chatbot with a live build keeps answering from it; only a chatbot with nothing to serve is in error
+		status := "error"
+		if bot, gerr := Chatbots.Get(recordCtx, job.ChatbotID); gerr == nil && bot.ActiveNamespace != "" {
+			status = "active"
+		}
+		recordChatbotError(job.ChatbotID, status, err)
+		IndexEvents.Publish(IndexEvent{Type: "failed", ChatbotID: job.ChatbotID, JobID: job.ID, Status: status, Error: err.Error(), Attempt: job.Attempts})
+		return
+	}
+
+	retryAt := time.Now().Add(jobBackoff(job.Attempts))
+	log.Printf("Job %s: attempt %d failed, retrying at %s: %v", job.ID, job.Attempts, retryAt.Format(time.RFC3339), err)
+	if ferr := Jobs.Fail(recordCtx, job.ID, owner, err.Error(), &retryAt); ferr != nil {
+		log.Printf("Job %s: %v", job.ID, ferr)
+	}
+	recordChatbotError(job.ChatbotID, "building", err)
+	IndexEvents.Publish(IndexEvent{Type: "retry", ChatbotID: job.ChatbotID, JobID: job.ID, Status: "building", Error: err.Error(), Attempt: job.Attempts, RetryAt: &retryAt})
+}
+
+// jobBackoff returns the delay before retry n: exponential from jobBackoffBase, capped, with jitter
+func jobBackoff(attempt int) time.Duration {
+	d := jobBackoffBase << uint(min(attempt-1, 10))
+	if d > jobBackoffMax {
+		d = jobBackoffMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// jobProgressReporter publishes every progress change to SSE subscribers and persists it,
+// throttled so per-chunk updates don't hammer the database
+type jobProgressReporter struct {
+	jobID     string
+	chatbotID string
+	progress  JobProgress
+	lastSave  time.Time
+}
+
+func (r *jobProgressReporter) update(ctx context.Context, fn func(p *JobProgress), force bool) {
+	fn(&r.progress)
+	snapshot := r.progress
+	IndexEvents.Publish(IndexEvent{Type: "progress", ChatbotID: r.chatbotID, JobID: r.jobID, Status: "building", Progress: &snapshot})
+	if !force && time.Since(r.lastSave) < 2*time.Second {
+		return
+	}
+	r.lastSave = time.Now()
+	if err := Jobs.UpdateProgress(ctx, r.jobID, r.progress); err != nil {
+		log.Printf("Job %s: %v", r.jobID, err)
+	}
+}
+
+// runIndexJob chunks, embeds and upserts a chatbot's content into a fresh namespace, then switches
+// the chatbot over to it. Queries keep using the previous namespace until the switch, so guests
+// never see a half-built index and a failed build leaves the live one untouched.
+func runIndexJob(ctx context.Context, job IndexJob) (err error) {
+	var payload IndexJobPayload
+	if len(job.Payload) > 0 {
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("invalid job payload: %w", err)
+		}
+	}
+	report := &jobProgressReporter{jobID: job.ID, chatbotID: job.ChatbotID}
+
+	bot, err := Chatbots.Get(ctx, job.ChatbotID)
+	if err != nil {
+		return fmt.Errorf("failed to load chatbot: %w", err)
+	}
+	branch, err := Branches.Get(ctx, bot.BranchID)
+	if err != nil {
+		return fmt.Errorf("failed to load branch: %w", err)
+	}
+	restaurant, err := Restaurants.Get(ctx, branch.RestaurantID)
+	if err != nil {
+		return fmt.Errorf("failed to load restaurant: %w", err)
+	}
+
+	updateChatbotStatus(bot.ID, "building")
+	IndexEvents.Publish(IndexEvent{Type: "status", ChatbotID: bot.ID, JobID: job.ID, Status: "building", Attempt: job.Attempts})
+
+	content, err := resolveIndexContent(ctx, bot.ID, branch.ID, payload)
+	if err != nil {
+		return err
+	}
+	chunks, err := prepareChunks(content, restaurant.ID, branch.ID)
+	if err != nil {
+		return err
+	}
+	newHash := generateHash(content)
+
+	build, err := startIndexBuild(ctx, bot, restaurant.ID, branch.ID, job.ID, payload.VersionID, newHash)
+	if err != nil {
+		return fmt.Errorf("failed to start index build: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			failIndexBuild(build, err)
+		}
+	}()
+
+	report.update(ctx, func(p *JobProgress) {
+		p.Stage = "embedding"
+		p.ChunksTotal = len(chunks)
+	}, true)
+
+	chunks, err = generateEmbeddings(ctx, chunks, func(done int) {
+		report.update(ctx, func(p *JobProgress) { p.ChunksEmbedded = done }, false)
+	})
+	if err != nil {
+		return fmt.Errorf("embeddings error: %w", err)
+	}
+	report.update(ctx, func(p *JobProgress) { p.Stage = "storing" }, true)
+
+	// The build namespace starts empty, so there is nothing stale to prune
+	stats, err := storeChunksInPinecone(ctx, chunks, build.Namespace)
+	if err != nil {
+		return fmt.Errorf("store error: %w", err)
+	}
+	if stats.Upserted+stats.Unchanged != len(chunks) {
+		return fmt.Errorf("incomplete build: %d of %d vectors stored", stats.Upserted+stats.Unchanged, len(chunks))
+	}
+	if err := Builds.Update(ctx, build.ID, map[string]interface{}{"vector_count": len(chunks)}); err != nil {
+		log.Printf("Index build %s: %v", build.ID, err)
+	}
+
+	// Re-read the chatbot: another build may have gone live while this one ran
+	current, err := Chatbots.Get(ctx, bot.ID)
+	if err != nil {
+		return fmt.Errorf("failed to reload chatbot: %w", err)
+	}
+
+	// Vectors of the live index that this content no longer produces are left out of the new build,
+	// so they disappear at the switch; count them as deleted
+	deleted := 0
+	if current.ActiveNamespace != "" || current.ContentHash != "" {
+		stale, err := pruneStaleVectors(ctx, liveNamespace(current, restaurant.ID, branch.ID), chunks, true)
+		if err != nil {
+			log.Printf("Index build %s: cannot compare with the live index: %v", build.ID, err)
+		} else {
+			deleted = len(stale.StaleIDs)
+		}
+	}
+	report.update(ctx, func(p *JobProgress) {
+		p.Stage = "done"
+		p.VectorsNew = stats.New
+		p.VectorsUpdated = stats.Updated
+		p.VectorsUnchanged = stats.Unchanged
+		p.VectorsUpserted = stats.Upserted
+		p.VectorsDeleted = deleted
+	}, true)
+
+	// success: if content changed (hash differs), increment version
+	newVersion := current.Version
+	if current.ContentHash != "" && newHash != current.ContentHash {
+		newVersion = current.Version + 1
+	}
+	if newVersion == 0 {
+		newVersion = 1
+	}
+	if err := switchToBuild(ctx, current, build, map[string]interface{}{"version": newVersion}); err != nil {
+		return err
+	}
+	if err := Branches.SetHasChatbot(ctx, branch.ID, true); err != nil {
+		log.Printf("Error updating branch: %v", err)
+	}
+	return nil
+}
+
+// resolveIndexContent returns what a job indexes: its content, else its chatbot version,
+// else the branch's latest menu snapshot
+func resolveIndexContent(ctx context.Context, chatbotID, branchID string, payload IndexJobPayload) (json.RawMessage, error) {
+	if len(payload.Content) > 0 {
+		return payload.Content, nil
+	}
+	if payload.VersionID != "" {
+		v, err := loadChatbotVersion(ctx, chatbotID, payload.VersionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load version %s: %w", payload.VersionID, err)
+		}
+		return v.Content, nil
+	}
+	latest, err := Snapshots.Latest(ctx, branchID)
+	if err != nil {
+		return nil, fmt.Errorf("no content provided and no menu snapshot found: %w", err)
+	}
+	return latest.Content, nil
+}
+
+// prepareChunks chunks content and stamps the owning restaurant and branch, which the deterministic IDs depend on
+func prepareChunks(content json.RawMessage, restaurantID, branchID string) ([]TextChunk, error) {
+	chunks, err := chunkContent(content)
+	if err != nil {
+		return nil, fmt.Errorf("chunking error: %w", err)
+	}
+	for i := range chunks {
+		chunks[i].Metadata.RestaurantID = restaurantID
+		chunks[i].Metadata.BranchID = branchID
+	}
+	return chunks, nil
+}
+
+// GetJob reports the status and progress of a background job
+func GetJob(c *gin.Context) {
+	jobID := c.Param("jobId")
+	job, err := Jobs.Get(c.Request.Context(), jobID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job", "details": err.Error()})
+		return
+	}
+	job.Payload = nil // content can be large; progress is what callers want
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is returned for malformed, badly signed or expired tokens
+var ErrInvalidToken = errors.New("invalid token")
+
+const (
+	jwksRefresh   = 10 * time.Minute // how long fetched keys are trusted
+	jwksMinRetry  = time.Minute      // an unknown kid refetches at most this often
+	jwtClockSkew  = 30 * time.Second
+	jwksMaxLength = 1 << 20
+)
+
+// jwtClaims are the claims Supabase puts in access tokens; locally issued tokens use the same shape
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Email     string      `json:"email,omitempty"`
+	Role      string      `json:"role,omitempty"`
+	Audience  jwtAudience `json:"aud,omitempty"`
+	Issuer    string      `json:"iss,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	ExpiresAt int64       `json:"exp"`
+}
+
+// jwtAudience accepts "aud" as a string or a list of strings
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = jwtAudience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a jwtAudience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jwtVerifier checks HS256 tokens against a shared secret and RS256/ES256 tokens against a JWKS,
+// which is how Supabase signs access tokens with legacy and asymmetric keys respectively
+type jwtVerifier struct {
+	secret   []byte
+	jwksURL  string
+	audience string // required "aud" value; empty skips the check
+	issuer   string // required "iss" value; empty skips the check
+	client   *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	fetching  chan struct{} // closed when the JWKS fetch in flight finishes
+}
+
+// verify parses a compact JWT and returns its claims once the signature, expiry and audience check out
+func (v *jwtVerifier) verify(token string, now time.Time) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return jwtClaims{}, fmt.Errorf("%w: bad header", ErrInvalidToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return jwtClaims{}, fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if len(v.secret) == 0 {
+			return jwtClaims{}, fmt.Errorf("%w: HS256 tokens are not accepted", ErrInvalidToken)
+		}
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return jwtClaims{}, fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+	case "RS256", "ES256":
+		key, err := v.key(header.Kid, now)
+		if err != nil {
+			return jwtClaims{}, err
+		}
+		if err := verifyAsymmetric(header.Alg, key, signed, signature); err != nil {
+			return jwtClaims{}, err
+		}
+	default:
+		return jwtClaims{}, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return jwtClaims{}, fmt.Errorf("%w: bad claims", ErrInvalidToken)
+	}
+	unix := now.Unix()
+	skew := int64(jwtClockSkew / time.Second)
+	switch {
+	case claims.ExpiresAt == 0:
+		return jwtClaims{}, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	case unix > claims.ExpiresAt+skew:
+		return jwtClaims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.NotBefore != 0 && unix < claims.NotBefore-skew:
+		return jwtClaims{}, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case claims.Subject == "":
+		return jwtClaims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case v.audience != "" && indexOf(claims.Audience, v.audience) < 0:
+		return jwtClaims{}, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	case v.issuer != "" && claims.Issuer != v.issuer:
+		return jwtClaims{}, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, into interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
+
+func verifyAsymmetric(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key is not RSA", ErrInvalidToken)
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key is not ECDSA", ErrInvalidToken)
+		}
+		// JWS ECDSA signatures are r || s, 32 bytes each for P-256
+		if len(signature) != 64 {
+			return fmt.Errorf("%w: bad ES256 signature length", ErrInvalidToken)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+	}
+	return nil
+}
+
+// key returns the JWKS key for kid, refetching the set when it is stale or kid is new.
+// The fetch runs without holding v.mu, and concurrent callers wait for it instead of fetching again.
+func (v *jwtVerifier) key(kid string, now time.Time) (crypto.PublicKey, error) {
+	if v.jwksURL == "" {
+		return nil, fmt.Errorf("%w: asymmetric tokens are not accepted", ErrInvalidToken)
+	}
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	stale := now.Sub(v.fetchedAt) > jwksRefresh
+	if !(!ok && now.Sub(v.fetchedAt) > jwksMinRetry) && !stale {
+		v.mu.Unlock()
+		return knownKey(kid, key, ok)
+	}
+	if wait := v.fetching; wait != nil {
+		v.mu.Unlock()
+		<-wait
+		v.mu.Lock()
+		if fetched, found := v.keys[kid]; found {
+			key, ok = fetched, true
+		}
+		v.mu.Unlock()
+		return knownKey(kid, key, ok)
+	}
+	done := make(chan struct{})
+	v.fetching = done
+	v.mu.Unlock()
+
+	keys, err := fetchJWKS(v.client, v.jwksURL)
+
+	v.mu.Lock()
+	if err == nil {
+		v.keys, v.fetchedAt = keys, now
+	}
+	v.fetching = nil
+	close(done)
+	v.mu.Unlock()
+
+	if err != nil {
+		if ok {
+			// Keep serving the cached key while the JWKS endpoint is unreachable
+			return key, nil
+		}
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	key, ok = keys[kid]
+	return knownKey(kid, key, ok)
+}
+
+func knownKey(kid string, key crypto.PublicKey, ok bool) (crypto.PublicKey, error) {
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// jwk is one key of a JSON Web Key Set; only RSA and P-256 signing keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+func fetchJWKS(client *http.Client, url string) (map[string]crypto.PublicKey, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned %s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(nil, resp.Body, jwksMaxLength)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+	return parseJWKS(set.Keys), nil
+}
+
+// parseJWKS converts the usable keys of a set, skipping encryption keys and unknown types
+func parseJWKS(keys []jwk) map[string]crypto.PublicKey {
+	out := make(map[string]crypto.PublicKey, len(keys))
+	for _, k := range keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			out[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			out[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return out
+}
+
+// signHS256 issues a compact HS256 token, used for accounts of the self-hosted backend
+func signHS256(claims jwtClaims, secret []byte) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
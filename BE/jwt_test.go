@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testNow = time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+
+func testClaims() jwtClaims {
+	return jwtClaims{
+		Subject:   "user-1",
+		Email:     "owner@example.com",
+		Audience:  jwtAudience{"authenticated"},
+		IssuedAt:  testNow.Unix(),
+		ExpiresAt: testNow.Add(time.Hour).Unix(),
+	}
+}
+
+func b64JSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signAsymmetric issues an RS256 or ES256 token the way Supabase's asymmetric keys do
+func signAsymmetric(t *testing.T, alg, kid string, key crypto.Signer, claims jwtClaims) string {
+	t.Helper()
+	signed := b64JSON(t, jwtHeader{Alg: alg, Kid: kid, Typ: "JWT"}) + "." + b64JSON(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = sig
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestHS256RoundTrip(t *testing.T) {
+	v := &jwtVerifier{secret: []byte("secret"), audience: "authenticated"}
+	token, err := signHS256(testClaims(), v.secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := v.verify(token, testNow)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "owner@example.com" {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	v := &jwtVerifier{secret: []byte("secret"), audience: "authenticated"}
+	sign := func(claims jwtClaims, secret string) string {
+		token, err := signHS256(claims, []byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	expired := testClaims()
+	expired.ExpiresAt = testNow.Add(-time.Hour).Unix()
+	wrongAud := testClaims()
+	wrongAud.Audience = jwtAudience{"anon"}
+	noExp := testClaims()
+	noExp.ExpiresAt = 0
+	noSub := testClaims()
+	noSub.Subject = ""
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", sign(expired, "secret")},
+		{"wrong secret", sign(testClaims(), "other")},
+		{"wrong audience", sign(wrongAud, "secret")},
+		{"missing exp", sign(noExp, "secret")},
+		{"missing sub", sign(noSub, "secret")},
+		{"alg none", b64JSON(t, jwtHeader{Alg: "none", Typ: "JWT"}) + "." + b64JSON(t, testClaims()) + "."},
+		{"asymmetric without JWKS", signAsymmetric(t, "ES256", "k1", mustECKey(t), testClaims())},
+		{"not a JWT", "abc.def"},
+	}
+	for _, tt := range tests {
+		if _, err := v.verify(tt.token, testNow); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", tt.name, err)
+		}
+	}
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerifyJWKS(t *testing.T) {
+	ecKey := mustECKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	set := map[string][]jwk{"keys": {
+		{Kty: "EC", Kid: "ec-1", Use: "sig", Crv: "P-256", X: enc(ecKey.X), Y: enc(ecKey.Y)},
+		{Kty: "RSA", Kid: "rsa-1", Use: "sig", N: enc(rsaKey.N), E: enc(big.NewInt(int64(rsaKey.E)))},
+	}}
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+	v := &jwtVerifier{jwksURL: server.URL, audience: "authenticated", client: server.Client()}
+
+	for _, tt := range []struct {
+		alg, kid string
+		key      crypto.Signer
+	}{
+		{"ES256", "ec-1", ecKey},
+		{"RS256", "rsa-1", rsaKey},
+	} {
+		claims, err := v.verify(signAsymmetric(t, tt.alg, tt.kid, tt.key, testClaims()), testNow)
+		if err != nil || claims.Subject != "user-1" {
+			t.Errorf("%s: claims = %+v, err = %v", tt.alg, claims, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("JWKS fetched %d times, want 1 (cached)", fetches)
+	}
+
+	otherKey := mustECKey(t)
+	rejected := map[string]string{
+		"signed by another key":  signAsymmetric(t, "ES256", "ec-1", otherKey, testClaims()),
+		"alg does not match key": signAsymmetric(t, "RS256", "ec-1", rsaKey, testClaims()),
+		"unknown kid":            signAsymmetric(t, "ES256", "ec-2", ecKey, testClaims()),
+	}
+	for name, token := range rejected {
+		if _, err := v.verify(token, testNow); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestRequireAuthAndOwnership(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	oldTokens := Tokens
+	defer func() { Tokens = oldTokens }()
+	Tokens = &jwtVerifier{secret: []byte("secret"), audience: "authenticated"}
+	useMemoryRepositories()
+	if _, err := Restaurants.Create(context.Background(), Restaurant{ID: "r1", Name: "Owned", OwnerID: "user-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	admin := r.Group("", RequireAuth())
+	admin.GET("/restaurants/:restaurantId", ownsRestaurant("restaurantId"), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user": currentUser(c).ID})
+	})
+
+	tokenFor := func(sub string) string {
+		session, err := issueToken(AuthUser{ID: sub}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session.Token
+	}
+	expired := testClaims()
+	expired.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+	expiredToken, _ := signHS256(expired, Tokens.secret)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"no token", "/restaurants/r1", "", http.StatusUnauthorized},
+		{"not bearer", "/restaurants/r1", "Basic " + tokenFor("user-1"), http.StatusUnauthorized},
+		{"expired", "/restaurants/r1", "Bearer " + expiredToken, http.StatusUnauthorized},
+		{"other owner", "/restaurants/r1", "Bearer " + tokenFor("user-2"), http.StatusForbidden},
+		{"unknown restaurant", "/restaurants/r2", "Bearer " + tokenFor("user-1"), http.StatusNotFound},
+		{"owner", "/restaurants/r1", "Bearer " + tokenFor("user-1"), http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, w.Code, tt.want, w.Body.String())
+		}
+	}
+}
+
+func TestJWKSFetchedOnceForConcurrentRequests(t *testing.T) {
+	ecKey := mustECKey(t)
+	enc := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	set := map[string][]jwk{"keys": {{Kty: "EC", Kid: "ec-1", Crv: "P-256", X: enc(ecKey.X), Y: enc(ecKey.Y)}}}
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+	v := &jwtVerifier{jwksURL: server.URL, audience: "authenticated", client: server.Client()}
+	token := signAsymmetric(t, "ES256", "ec-1", ecKey, testClaims())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.verify(token, testNow); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// The slow fetch does not hold the lock: other verifier state stays reachable meanwhile
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	locked := make(chan struct{})
+	go func() {
+		v.mu.Lock()
+		v.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Error("lock held during the JWKS fetch")
+	}
+	close(release)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/pinecone-io/go-pinecone/v4/pinecone"
 	"github.com/supabase-community/supabase-go"
-	"google.golang.org/api/option"
 )
 
 var (
@@ -26,52 +25,31 @@ func InitializeClients() error {
 		log.Println("Warning: Error loading .env file:", err)
 	}
 
-	// Supabase
-	supabaseUrl := os.Getenv("SUPABASE_URL")
-	supabaseKey := os.Getenv("SUPABASE_SERVICE_ROLE_KEY") // Use service role key for RLS bypass
-
-	// Fixed: supabase.NewClient now returns 2 values
-	var err error
-	SupabaseClient, err = supabase.NewClient(supabaseUrl, supabaseKey, nil)
-	if err != nil {
-		log.Printf("Warning: Supabase client initialization issue: %v", err)
-	}
-
-	// Pinecone
-	pineconeApiKey := os.Getenv("PINECONE_API_KEY")
-
-	// Fixed: pinecone.NewClient field names (ApiKey, not APIKey)
-	config := pinecone.NewClientParams{
-		ApiKey: pineconeApiKey, // Fixed: ApiKey not APIKey
-		// Environment field doesn't exist in newer versions
-	}
-	PineconeClient, err = pinecone.NewClient(config)
-	if err != nil {
-		log.Fatalf("Failed to initialize Pinecone client: %v", err)
+	// Repositories (Supabase by default, DB_BACKEND=postgres for a self-hosted database)
+	ctx := context.Background()
+	if err := initRepositories(ctx); err != nil {
+		log.Printf("Failed to initialize repositories: %v", err)
+		return fmt.Errorf("failed to initialize repositories: %w", err)
 	}
 
-	// Create Pinecone index if it doesn't exist
-	err = createPineconeIndex()
+	// Vector store (Pinecone by default, VECTOR_STORE=memory for offline use)
+	var err error
+	Vectors, err = newVectorStoreFromEnv(ctx)
 	if err != nil {
-		log.Printf("Failed to create Pinecone index: %v", err)
-		return fmt.Errorf("failed to initialize Pinecone index: %w", err)
+		log.Printf("Failed to initialize vector store: %v", err)
+		return fmt.Errorf("failed to initialize vector store: %w", err)
 	}
 
-	// Create chat history table if needed
-	err = createChatHistoryTable()
-	if err != nil {
-		log.Printf("Warning: Chat history table issue: %v", err)
-		
+	// Access token verification for admin routes (JWT_SECRET and/or JWKS_URL)
+	if err := initAuth(); err != nil {
+		log.Printf("Failed to initialize auth: %v", err)
+		return fmt.Errorf("failed to initialize auth: %w", err)
 	}
 
-	// Gemini (Google Generative Language)
-	ctx := context.Background()
-	GeminiClient, err = generativelanguage.NewGenerativeClient(
-		ctx,
-		option.WithAPIKey(os.Getenv("GEMINI_API_KEY")),
-	)
-	if err != nil {
-		log.Fatalf("Failed to initialize Gemini client: %v", err)
+	// Embedding and chat models (Gemini by default, EMBEDDER=hash / CHAT_MODEL=echo for offline use)
+	if err := initAIModels(ctx); err != nil {
+		log.Printf("Failed to initialize AI models: %v", err)
+		return fmt.Errorf("failed to initialize AI models: %w", err)
 	}
 
 	return nil
@@ -88,14 +66,29 @@ func main() {
 		log.Fatalf("Failed to initialize clients: %v", err)
 	}
 
+	// One-off maintenance commands: `mindmenu migrate-namespaces [-mode copy|reembed] [-dry-run] [-delete-legacy]`
+	if len(os.Args) > 1 && os.Args[1] == "migrate-namespaces" {
+		if err := runNamespaceMigration(context.Background(), os.Args[2:]); err != nil {
+			log.Fatalf("Namespace migration failed: %v", err)
+		}
+		log.Println("Namespace migration complete")
+		return
+	}
+
+	// Requeue jobs interrupted by a previous shutdown, then start the index workers
+	RecoverJobs(context.Background())
+	StartJobWorkers(context.Background())
+	StartIndexGC(context.Background())
+
 	// Create Gin router
 	r := gin.Default()
 
 	// Add CORS middleware
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Expose-Headers", "Content-Disposition, X-Session-Id, X-Client-Url")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
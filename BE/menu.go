@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// MenuSchemaVersion identifies structured menu content
+const MenuSchemaVersion = "menu/v1"
+
+// Menu is structured chatbot content, published as schemas/menu.schema.json
+type Menu struct {
+	SchemaVersion string                 `json:"schema_version,omitempty"`
+	Currency      string                 `json:"currency,omitempty"` // ISO 4217, default for prices without one
+	Sections      []MenuSection          `json:"sections"`
+	Info          map[string]interface{} `json:"info,omitempty"`
+}
+
+// MenuSection groups items, e.g. "Breakfast" or "Cocktails". Sections can nest.
+type MenuSection struct {
+	Name         string         `json:"name"`
+	Description  string         `json:"description,omitempty"`
+	Items        []MenuItem     `json:"items,omitempty"`
+	Sections     []MenuSection  `json:"sections,omitempty"`
+	Availability []Availability `json:"availability,omitempty"`
+}
+
+// MenuItem is a single dish or drink
+type MenuItem struct {
+	ID           string         `json:"id,omitempty"`
+	Name         string         `json:"name"`
+	Description  string         `json:"description,omitempty"`
+	Price        *Price         `json:"price,omitempty"`
+	Variants     []MenuOption   `json:"variants,omitempty"` // sizes or alternatives, each with its own price
+	AddOns       []MenuOption   `json:"add_ons,omitempty"`
+	Tags         []string       `json:"tags,omitempty"`
+	Available    *bool          `json:"available,omitempty"`
+	Availability []Availability `json:"availability,omitempty"`
+	Allergens    []string       `json:"allergens,omitempty"` // EU 14 codes; absent is unknown, [] in content declares none
+	Dietary      []string       `json:"dietary,omitempty"`
+}
+
+// MenuOption is a variant or add-on of an item
+type MenuOption struct {
+	Name  string `json:"name"`
+	Price *Price `json:"price,omitempty"`
+}
+
+// Price is an amount in a currency; Currency falls back to Menu.Currency
+type Price struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+// Availability is a weekly window, e.g. mon-fri 07:00-11:00. Empty Days means every day.
+type Availability struct {
+	Days  []string `json:"days,omitempty"`
+	From  string   `json:"from,omitempty"`
+	Until string   `json:"until,omitempty"`
+}
+
+//go:embed schemas/menu.schema.json
+var menuSchemaJSON []byte
+
+var (
+	menuSchemaOnce sync.Once
+	menuSchema     *jsonschema.Schema
+	menuSchemaErr  error
+)
+
+// compiledMenuSchema compiles the embedded schema once
+func compiledMenuSchema() (*jsonschema.Schema, error) {
+	menuSchemaOnce.Do(func() {
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(menuSchemaJSON))
+		if err != nil {
+			menuSchemaErr = fmt.Errorf("invalid embedded menu schema: %w", err)
+			return
+		}
+		c := jsonschema.NewCompiler()
+		if err := c.AddResource("menu.schema.json", doc); err != nil {
+			menuSchemaErr = fmt.Errorf("invalid embedded menu schema: %w", err)
+			return
+		}
+		menuSchema, menuSchemaErr = c.Compile("menu.schema.json")
+	})
+	return menuSchema, menuSchemaErr
+}
+
+// FieldError is a validation failure at a JSON path inside the content
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Content formats accepted on ingest
+const (
+	ContentFormatAuto   = "auto"   // validate when the content looks like a structured menu
+	ContentFormatMenu   = "menu"   // always validate against the menu schema
+	ContentFormatLegacy = "legacy" // accept any JSON object, as before the schema existed
+)
+
+// validateContent checks content for the requested format and returns field-level errors.
+// Auto treats content with "schema_version" or "sections" as a structured menu; anything else is legacy
+// unless MENU_VALIDATION=strict, which requires every upload to be a structured menu.
+func validateContent(content json.RawMessage, format string) ([]FieldError, error) {
+	if format == "" {
+		format = ContentFormatAuto
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return []FieldError{{Field: "content", Message: "content is not valid JSON: " + err.Error()}}, nil
+	}
+
+	switch format {
+	case ContentFormatLegacy:
+		if _, ok := doc.(map[string]interface{}); !ok {
+			return []FieldError{{Field: "content", Message: "content must be a JSON object"}}, nil
+		}
+		return nil, nil
+	case ContentFormatAuto:
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return []FieldError{{Field: "content", Message: "content must be a JSON object"}}, nil
+		}
+		_, hasVersion := obj["schema_version"]
+		_, hasSections := obj["sections"]
+		if !hasVersion && !hasSections && !strings.EqualFold(os.Getenv("MENU_VALIDATION"), "strict") {
+			return nil, nil
+		}
+	case ContentFormatMenu:
+	default:
+		return []FieldError{{Field: "content_format", Message: fmt.Sprintf("unknown content format %q (want auto, menu or legacy)", format)}}, nil
+	}
+
+	schema, err := compiledMenuSchema()
+	if err != nil {
+		return nil, err
+	}
+	// The validator wants json.Number rather than float64 for numbers
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	var verr *jsonschema.ValidationError
+	if err := schema.Validate(instance); errors.As(err, &verr) {
+		return fieldErrors(verr), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// fieldErrors flattens a validation error tree into its leaf failures, one per field and message
+func fieldErrors(verr *jsonschema.ValidationError) []FieldError {
+	p := message.NewPrinter(language.English)
+	seen := make(map[FieldError]bool)
+	var out []FieldError
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, cause := range e.Causes {
+				walk(cause)
+			}
+			return
+		}
+		fe := FieldError{Field: contentFieldPath(e.InstanceLocation), Message: e.ErrorKind.LocalizedString(p)}
+		if !seen[fe] {
+			seen[fe] = true
+			out = append(out, fe)
+		}
+	}
+	walk(verr)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Field < out[j].Field })
+	return out
+}
+
+// contentFieldPath renders an instance location as content.sections[0].items[2].price
+func contentFieldPath(location []string) string {
+	var b strings.Builder
+	b.WriteString("content")
+	for _, tok := range location {
+		if isIndex(tok) {
+			fmt.Fprintf(&b, "[%s]", tok)
+		} else {
+			b.WriteString("." + tok)
+		}
+	}
+	return b.String()
+}
+
+func isIndex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// bindValidatedContent validates content and writes a 422 with field errors when it is invalid.
+// It returns false when the handler should stop.
+func bindValidatedContent(c *gin.Context, content json.RawMessage, format string) bool {
+	errs, err := validateContent(content, format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate content", "details": err.Error()})
+		return false
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Content does not match the menu schema", "fields": errs})
+		return false
+	}
+	return true
+}
+
+// parseStructuredMenu decodes content as a Menu when it looks like one: a schema_version,
+// or a top-level "sections" list whose entries are named objects
+func parseStructuredMenu(content json.RawMessage) (Menu, bool) {
+	var probe struct {
+		SchemaVersion string            `json:"schema_version"`
+		Sections      []json.RawMessage `json:"sections"`
+	}
+	if err := json.Unmarshal(content, &probe); err != nil {
+		return Menu{}, false
+	}
+	if probe.SchemaVersion == "" && len(probe.Sections) == 0 {
+		return Menu{}, false
+	}
+	var menu Menu
+	if err := json.Unmarshal(content, &menu); err != nil {
+		return Menu{}, false
+	}
+	for _, section := range menu.Sections {
+		if strings.TrimSpace(section.Name) == "" {
+			return Menu{}, false
+		}
+	}
+	return menu, true
+}
+
+// addMenu emits chunks for a structured menu using the same Source/ItemKey conventions as walk
+func (m *menuChunker) addMenu(menu Menu) {
+	for _, section := range menu.Sections {
+		m.addMenuSection(fmt.Sprintf("sections[name=%s]", section.Name), section, menu.Currency, nil)
+	}
+	if len(menu.Info) > 0 {
+		m.walk("info", "", menu.Info, false)
+	}
+}
+
+// addMenuSection emits a section's chunks. Sections and items without availability of their own
+// inherit the nearest enclosing windows.
+func (m *menuChunker) addMenuSection(path string, section MenuSection, currency string, inherited []Availability) {
+	windows := inherited
+	if len(section.Availability) > 0 {
+		windows = section.Availability
+	}
+	if section.Description != "" || len(section.Availability) > 0 {
+		text := fmt.Sprintf("%s section.", section.Name)
+		if section.Description != "" {
+			text += " " + strings.TrimSuffix(section.Description, ".") + "."
+		}
+		if served := formatAvailability(windows); served != "" {
+			text += " Served " + served + "."
+		}
+		m.add(path, "section", "section", text)
+		meta := m.lastMetadata()
+		meta.Availability = windows
+	}
+
+	for _, item := range section.Items {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s (%s).", item.Name, section.Name)
+		price := ""
+		if item.Price != nil {
+			price = formatPrice(*item.Price, currency)
+			fmt.Fprintf(&b, " Price: %s.", price)
+		}
+		if item.Description != "" {
+			fmt.Fprintf(&b, " %s.", strings.TrimSuffix(item.Description, "."))
+		}
+		if len(item.Variants) > 0 {
+			fmt.Fprintf(&b, " Variants: %s.", formatOptions(item.Variants, currency, ""))
+		}
+		if len(item.AddOns) > 0 {
+			fmt.Fprintf(&b, " Add-ons: %s.", formatOptions(item.AddOns, currency, "+"))
+		}
+		if len(item.Tags) > 0 {
+			fmt.Fprintf(&b, " Tags: %s.", strings.Join(item.Tags, ", "))
+		}
+		if item.Allergens != nil {
+			fmt.Fprintf(&b, " Allergens: %s.", formatAllergens(item.Allergens))
+		}
+		if len(item.Dietary) > 0 {
+			fmt.Fprintf(&b, " Dietary: %s.", strings.Join(item.Dietary, ", "))
+		}
+		itemWindows := windows
+		if len(item.Availability) > 0 {
+			itemWindows = item.Availability
+		}
+		if served := formatAvailability(itemWindows); served != "" {
+			fmt.Fprintf(&b, " Served %s.", served)
+		}
+		if item.Available != nil && !*item.Available {
+			b.WriteString(" Currently unavailable.")
+		}
+
+		itemKey := slugify(item.Name)
+		if item.ID != "" {
+			itemKey = slugify(item.ID)
+		}
+		itemKey = m.add(path+".items", section.Name, itemKey, b.String())
+		meta := m.lastMetadata()
+		meta.Availability = itemWindows
+		meta.Unavailable = item.Available != nil && !*item.Available
+		meta.Allergens = item.Allergens
+		meta.AllergensDeclared = item.Allergens != nil
+		meta.Dietary = item.Dietary
+		meta.Price = itemPrice(item)
+		m.dishes = append(m.dishes, menuDish{Key: path + ".items|" + itemKey, Section: section.Name, Name: item.Name, Price: price})
+	}
+
+	for _, sub := range section.Sections {
+		m.addMenuSection(fmt.Sprintf("%s.sections[name=%s]", path, sub.Name), sub, currency, windows)
+	}
+}
+
+// itemPrice is the amount range filters compare: the item price, or its cheapest variant
+func itemPrice(item MenuItem) *float64 {
+	if item.Price != nil {
+		amount := item.Price.Amount
+		return &amount
+	}
+	var lowest *float64
+	for _, v := range item.Variants {
+		if v.Price != nil && (lowest == nil || v.Price.Amount < *lowest) {
+			amount := v.Price.Amount
+			lowest = &amount
+		}
+	}
+	return lowest
+}
+
+// lastMetadata returns the metadata of the chunk just added, for the fields queries filter on
+func (m *menuChunker) lastMetadata() *Metadata {
+	return &m.chunks[len(m.chunks)-1].Metadata
+}
+
+// formatPrice renders a price as "9.5 USD", falling back to the menu currency
+func formatPrice(p Price, currency string) string {
+	amount := strconv.FormatFloat(p.Amount, 'f', -1, 64)
+	if p.Currency != "" {
+		currency = p.Currency
+	}
+	if currency == "" {
+		return amount
+	}
+	return amount + " " + currency
+}
+
+func formatOptions(options []MenuOption, currency, sign string) string {
+	parts := make([]string, 0, len(options))
+	for _, o := range options {
+		if o.Price != nil {
+			parts = append(parts, fmt.Sprintf("%s %s%s", o.Name, sign, formatPrice(*o.Price, currency)))
+		} else {
+			parts = append(parts, o.Name)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatAvailability renders windows as "mon, tue 07:00-11:00; sat 10:00-14:00"
+func formatAvailability(windows []Availability) string {
+	parts := make([]string, 0, len(windows))
+	for _, w := range windows {
+		days := "daily"
+		if len(w.Days) > 0 {
+			days = strings.Join(w.Days, ", ")
+		}
+		switch {
+		case w.From != "" && w.Until != "":
+			parts = append(parts, fmt.Sprintf("%s %s-%s", days, w.From, w.Until))
+		case w.From != "":
+			parts = append(parts, fmt.Sprintf("%s from %s", days, w.From))
+		case w.Until != "":
+			parts = append(parts, fmt.Sprintf("%s until %s", days, w.Until))
+		default:
+			parts = append(parts, days)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// GetMenuSchema publishes the JSON Schema of structured menu content
+func GetMenuSchema(c *gin.Context) {
+	c.Data(http.StatusOK, "application/schema+json", menuSchemaJSON)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ledongthuc/pdf"
+)
+
+// sourceLine is one line of an uploaded document, numbered from 1 within its page
+type sourceLine struct {
+	Page int    `json:"page"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// ItemProvenance records where an extracted item came from so owners can check the draft
+type ItemProvenance struct {
+	Section string `json:"section"`
+	Item    string `json:"item"`
+	Page    int    `json:"page"`
+	Line    int    `json:"line"`
+	Text    string `json:"text,omitempty"` // the source line, empty when the model cited a line that does not exist
+}
+
+// extractDocumentLines returns the text lines of a PDF or plain-text upload
+func extractDocumentLines(filename string, data []byte) ([]sourceLine, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".pdf") || bytes.HasPrefix(data, []byte("%PDF-")) {
+		return extractPDFLines(data)
+	}
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("unsupported file: expected a PDF or UTF-8 text")
+	}
+
+	var lines []sourceLine
+	// Form feeds separate pages in text exported from PDFs
+	for p, page := range strings.Split(string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))), "\f") {
+		for l, text := range strings.Split(page, "\n") {
+			if text = strings.TrimSpace(text); text != "" {
+				lines = append(lines, sourceLine{Page: p + 1, Line: l + 1, Text: text})
+			}
+		}
+	}
+	return lines, nil
+}
+
+func extractPDFLines(data []byte) ([]sourceLine, error) {
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF: %w", err)
+	}
+
+	var lines []sourceLine
+	for p := 1; p <= r.NumPage(); p++ {
+		page := r.Page(p)
+		if page.V.IsNull() {
+			continue
+		}
+		rows, err := page.GetTextByRow()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page %d: %w", p, err)
+		}
+		// PDF coordinates grow upwards; read top to bottom
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Position > rows[j].Position })
+		n := 0
+		for _, row := range rows {
+			var b strings.Builder
+			for _, t := range row.Content {
+				b.WriteString(t.S)
+			}
+			if text := strings.Join(strings.Fields(b.String()), " "); text != "" {
+				n++
+				lines = append(lines, sourceLine{Page: p, Line: n, Text: text})
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no text found in PDF (scanned menus need OCR first)")
+	}
+	return lines, nil
+}
+
+// extractedMenu is what the model is asked to return: a Menu whose items cite their source line
+type extractedMenu struct {
+	Currency string `json:"currency"`
+	Sections []struct {
+		Name  string `json:"name"`
+		Items []struct {
+			MenuItem
+			Source struct {
+				Page int `json:"page"`
+				Line int `json:"line"`
+			} `json:"source"`
+		} `json:"items"`
+	} `json:"sections"`
+}
+
+// createMenuExtractionPrompt asks the model to structure numbered document lines into menu JSON
+func createMenuExtractionPrompt(lines []sourceLine) string {
+	var b strings.Builder
+	b.WriteString(`You convert restaurant menus into JSON. Below are the lines of an uploaded menu, each prefixed with [page:line].
+
+Return ONLY a JSON object, no prose and no code fences, in this shape:
+{"currency": "ISO 4217 code if evident, else empty",
+ "sections": [{"name": "section heading",
+   "items": [{"name": "...", "description": "...", "price": {"amount": 0}, "tags": ["..."],
+     "variants": [{"name": "size", "price": {"amount": 0}}],
+     "source": {"page": 1, "line": 3}}]}]}
+
+Rules:
+- Every item MUST cite the page and line where its name appears in "source".
+- Copy names and prices exactly; do not invent items, prices or descriptions.
+- Omit "price" when no price is shown. Omit empty fields.
+- Put items without a heading in a section named "Menu".
+
+Menu lines:
+`)
+	for _, l := range lines {
+		fmt.Fprintf(&b, "[%d:%d] %s\n", l.Page, l.Line, l.Text)
+	}
+	return b.String()
+}
+
+var jsonFencePattern = regexp.MustCompile("(?s)```(?:json)?\\s*(.*?)\\s*```")
+
+// parseExtractedMenu turns the model's answer into a Menu and provenance, checking cited lines
+func parseExtractedMenu(response string, lines []sourceLine) (Menu, []ItemProvenance, error) {
+	response = strings.TrimSpace(response)
+	if m := jsonFencePattern.FindStringSubmatch(response); m != nil {
+		response = m[1]
+	}
+	if i, j := strings.Index(response, "{"), strings.LastIndex(response, "}"); i >= 0 && j > i {
+		response = response[i : j+1]
+	}
+
+	var extracted extractedMenu
+	if err := json.Unmarshal([]byte(response), &extracted); err != nil {
+		return Menu{}, nil, fmt.Errorf("model did not return menu JSON: %w", err)
+	}
+
+	byPos := make(map[[2]int]string, len(lines))
+	for _, l := range lines {
+		byPos[[2]int{l.Page, l.Line}] = l.Text
+	}
+
+	menu := Menu{SchemaVersion: MenuSchemaVersion, Currency: strings.ToUpper(strings.TrimSpace(extracted.Currency))}
+	var provenance []ItemProvenance
+	for _, s := range extracted.Sections {
+		section := MenuSection{Name: strings.TrimSpace(s.Name)}
+		if section.Name == "" {
+			section.Name = "Menu"
+		}
+		for _, it := range s.Items {
+			if strings.TrimSpace(it.Name) == "" {
+				continue
+			}
+			section.Items = append(section.Items, it.MenuItem)
+			provenance = append(provenance, ItemProvenance{
+				Section: section.Name,
+				Item:    it.Name,
+				Page:    it.Source.Page,
+				Line:    it.Source.Line,
+				Text:    byPos[[2]int{it.Source.Page, it.Source.Line}],
+			})
+		}
+		if len(section.Items) > 0 {
+			menu.Sections = append(menu.Sections, section)
+		}
+	}
+	if len(menu.Sections) == 0 {
+		return Menu{}, nil, fmt.Errorf("model found no menu items")
+	}
+	return menu, provenance, nil
+}
+
+// trailingPricePattern catches "Latte 4.50" and dot leaders like "Croissant .... 3,20"
+var trailingPricePattern = regexp.MustCompile(`^(.*?\pL.*?)[\s.·…_–—-]*\s(\d{1,3}(?:[.,]\d{3})+(?:[.,]\d{1,2})?|\d{1,6}(?:[.,]\d{1,3})?)$`)
+
+// extractMenuHeuristically structures lines without a model: priced lines are items, short unpriced
+// lines are section headings, and other unpriced lines describe the item above them
+func extractMenuHeuristically(lines []sourceLine) (Menu, []ItemProvenance) {
+	menu := Menu{SchemaVersion: MenuSchemaVersion}
+	var provenance []ItemProvenance
+	current := -1
+	var last *MenuItem
+
+	for _, l := range lines {
+		name, priceText := splitDishString(l.Text, true)
+		if priceText == "" {
+			if m := trailingPricePattern.FindStringSubmatch(l.Text); m != nil {
+				name, priceText = strings.TrimSpace(m[1]), m[2]
+			}
+		}
+
+		if priceText != "" {
+			if current < 0 {
+				menu.Sections = append(menu.Sections, MenuSection{Name: "Menu"})
+				current = len(menu.Sections) - 1
+			}
+			item := MenuItem{Name: strings.TrimRight(name, " .-–—:")}
+			if amount, err := parsePriceAmount(priceText); err == nil {
+				item.Price = &Price{Amount: amount}
+			}
+			section := &menu.Sections[current]
+			section.Items = append(section.Items, item)
+			last = &section.Items[len(section.Items)-1]
+			provenance = append(provenance, ItemProvenance{Section: section.Name, Item: item.Name, Page: l.Page, Line: l.Line, Text: l.Text})
+			continue
+		}
+
+		if len(strings.Fields(l.Text)) <= 5 && !strings.HasSuffix(l.Text, ".") {
+			menu.Sections = append(menu.Sections, MenuSection{Name: strings.Trim(l.Text, " :")})
+			current = len(menu.Sections) - 1
+			last = nil
+			continue
+		}
+		if last != nil && last.Description == "" {
+			last.Description = l.Text
+		}
+	}
+
+	// Headings with nothing under them (titles, addresses) are not sections
+	kept := menu.Sections[:0]
+	for _, s := range menu.Sections {
+		if len(s.Items) > 0 {
+			kept = append(kept, s)
+		}
+	}
+	menu.Sections = kept
+	return menu, provenance
+}
+
+// extractMenuDraft structures document lines with the chat model, falling back to the heuristic
+// extractor when the model is unavailable or returns something unusable. It reports the method used.
+func extractMenuDraft(ctx context.Context, lines []sourceLine) (Menu, []ItemProvenance, string, []string) {
+	var warnings []string
+
+	response, err := generateResponseWithGemini(ctx, createMenuExtractionPrompt(lines))
+	if err == nil {
+		menu, provenance, perr := parseExtractedMenu(response, lines)
+		if perr == nil {
+			content, _ := json.Marshal(menu)
+			fieldErrs, verr := validateContent(content, ContentFormatMenu)
+			if verr == nil && len(fieldErrs) == 0 {
+				for _, p := range provenance {
+					if p.Text == "" {
+						warnings = append(warnings, fmt.Sprintf("%q cites page %d line %d, which does not exist", p.Item, p.Page, p.Line))
+					}
+				}
+				return menu, provenance, "model", warnings
+			}
+			err = fmt.Errorf("model output failed validation: %v", fieldErrs)
+		} else {
+			err = perr
+		}
+	}
+
+	log.Printf("Menu extraction: falling back to heuristic: %v", err)
+	warnings = append(warnings, "Model extraction failed, used line heuristics: "+err.Error())
+	menu, provenance := extractMenuHeuristically(lines)
+	return menu, provenance, "heuristic", warnings
+}
+
+// UploadMenuDocument extracts a menu from an uploaded PDF or text file and saves it as a draft snapshot.
+// Drafts are not indexed; review them and publish with POST .../menu-snapshots/:snapshotId/publish.
+// Multipart fields: file, and optionally currency and notes.
+func UploadMenuDocument(c *gin.Context) {
+	branchID := c.Param("branchId")
+	ctx := c.Request.Context()
+
+	if _, err := Branches.Get(ctx, branchID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if fh.Size > maxImportFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file is too large"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxImportFileSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lines, err := extractDocumentLines(fh.Filename, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	menu, provenance, method, warnings := extractMenuDraft(ctx, lines)
+	if len(menu.Sections) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No menu items found in the document", "warnings": warnings})
+		return
+	}
+	if currency := strings.ToUpper(c.PostForm("currency")); currency != "" {
+		menu.Currency = currency
+	}
+
+	content, err := json.Marshal(menu)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode menu"})
+		return
+	}
+	provenanceJSON, err := json.Marshal(provenance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode provenance"})
+		return
+	}
+
+	notes := c.PostForm("notes")
+	if notes == "" {
+		notes = fmt.Sprintf("Extracted from %s (%s)", fh.Filename, method)
+	}
+	snapshot, err := Snapshots.Create(ctx, MenuSnapshot{
+		BranchID:    branchID,
+		Content:     content,
+		ContentHash: generateHash(content),
+		Notes:       notes,
+		CreatedBy:   currentUser(c).ID,
+		Status:      SnapshotDraft,
+		Provenance:  provenanceJSON,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"snapshot_id": snapshot.ID,
+		"status":      snapshot.Status,
+		"method":      method,
+		"menu":        menu,
+		"provenance":  provenance,
+		"warnings":    warnings,
+	})
+}
+
+// PublishMenuSnapshot makes a reviewed draft the branch's current menu
+func PublishMenuSnapshot(c *gin.Context) {
+	branchID := c.Param("branchId")
+	snapshotID := c.Param("snapshotId")
+	ctx := c.Request.Context()
+
+	snap, ok := loadBranchSnapshot(c, branchID, snapshotID)
+	if !ok {
+		return
+	}
+	if snap.Status != SnapshotDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "Snapshot is already published"})
+		return
+	}
+	if !bindValidatedContent(c, snap.Content, ContentFormatAuto) {
+		return
+	}
+
+	published, err := Snapshots.Publish(ctx, snapshotID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish snapshot", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshot": published})
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxImportFileSize = 10 << 20
+
+// ColumnMapping names the spreadsheet column that holds each menu field. Empty fields are auto-detected.
+type ColumnMapping struct {
+	ID          string `json:"id,omitempty"`
+	Section     string `json:"section,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Price       string `json:"price,omitempty"`
+	Currency    string `json:"currency,omitempty"`
+	Variant     string `json:"variant,omitempty"` // rows sharing a name with different variants become one item
+	Tags        string `json:"tags,omitempty"`    // comma or semicolon separated
+	Available   string `json:"available,omitempty"`
+}
+
+// importPresets map the headers of common POS exports
+var importPresets = map[string]ColumnMapping{
+	"square": {ID: "Token", Section: "Category", Name: "Item Name", Description: "Description", Price: "Price", Variant: "Variation Name"},
+	"toast":  {ID: "Item GUID", Section: "Menu Group", Name: "Item", Description: "Description", Price: "Price"},
+	"shopify": {ID: "Handle", Section: "Product Type", Name: "Title", Description: "Body (HTML)", Price: "Variant Price",
+		Variant: "Option1 Value", Tags: "Tags"},
+}
+
+// Header synonyms used when a mapping field is empty, matched case-insensitively
+var importHeaderSynonyms = map[string][]string{
+	"id":          {"id", "sku", "item id", "code"},
+	"section":     {"section", "category", "group", "menu group", "course", "menu section"},
+	"name":        {"name", "item", "item name", "dish", "product", "title"},
+	"description": {"description", "desc", "details"},
+	"price":       {"price", "cost", "amount", "unit price"},
+	"currency":    {"currency"},
+	"variant":     {"variant", "size", "variation", "variation name", "option"},
+	"tags":        {"tags", "labels", "dietary"},
+	"available":   {"available", "in stock", "active", "enabled"},
+}
+
+// ImportRowError reports a row that could not be imported. Row is 1-based and counts the header.
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// MenuImportResult is the preview of an import
+type MenuImportResult struct {
+	Menu    Menu             `json:"menu"`
+	Items   int              `json:"items"`
+	Rows    int              `json:"rows"`
+	Mapping ColumnMapping    `json:"mapping"`
+	Errors  []ImportRowError `json:"errors"`
+}
+
+// importMenuRows converts spreadsheet rows (header first) into a Menu
+func importMenuRows(rows [][]string, mapping ColumnMapping, currency string) (MenuImportResult, error) {
+	result := MenuImportResult{Errors: []ImportRowError{}}
+	if len(rows) == 0 {
+		return result, fmt.Errorf("file is empty")
+	}
+
+	// Rows above the header may be blank; row numbers in errors still count them
+	start := 0
+	for start < len(rows)-1 && isBlankRow(rows[start]) {
+		start++
+	}
+	header := rows[start]
+	resolved, cols, err := resolveColumns(header, mapping)
+	if err != nil {
+		return result, err
+	}
+	result.Mapping = resolved
+
+	menu := Menu{SchemaVersion: MenuSchemaVersion, Currency: strings.ToUpper(currency)}
+	sectionIndex := map[string]int{}
+	itemIndex := map[string]int{} // section|name -> index in section items
+
+	cell := func(row []string, field string) string {
+		i, ok := cols[field]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	for r, row := range rows[start+1:] {
+		rowNum := start + r + 2
+		if isBlankRow(row) {
+			continue
+		}
+		result.Rows++
+
+		name := cell(row, "name")
+		if name == "" {
+			result.Errors = append(result.Errors, ImportRowError{Row: rowNum, Column: resolved.Name, Message: "missing item name"})
+			continue
+		}
+
+		var price *Price
+		if raw := cell(row, "price"); raw != "" {
+			amount, err := parsePriceAmount(raw)
+			if err != nil {
+				result.Errors = append(result.Errors, ImportRowError{Row: rowNum, Column: resolved.Price, Message: err.Error()})
+				continue
+			}
+			price = &Price{Amount: amount}
+			if cur := strings.ToUpper(cell(row, "currency")); cur != "" && cur != menu.Currency {
+				price.Currency = cur
+			}
+		}
+
+		sectionName := cell(row, "section")
+		if sectionName == "" {
+			sectionName = "Menu"
+		}
+		si, ok := sectionIndex[strings.ToLower(sectionName)]
+		if !ok {
+			si = len(menu.Sections)
+			sectionIndex[strings.ToLower(sectionName)] = si
+			menu.Sections = append(menu.Sections, MenuSection{Name: sectionName})
+		}
+		section := &menu.Sections[si]
+
+		variant := cell(row, "variant")
+		key := strings.ToLower(sectionName + "|" + name)
+		if ii, exists := itemIndex[key]; exists && variant != "" {
+			// Another size of an item we already have
+			item := &section.Items[ii]
+			item.Variants = append(item.Variants, MenuOption{Name: variant, Price: price})
+			continue
+		} else if exists {
+			result.Errors = append(result.Errors, ImportRowError{Row: rowNum, Column: resolved.Name, Message: fmt.Sprintf("duplicate item %q in section %q", name, sectionName)})
+			continue
+		}
+
+		item := MenuItem{
+			ID:          cell(row, "id"),
+			Name:        name,
+			Description: cell(row, "description"),
+			Tags:        splitTags(cell(row, "tags")),
+		}
+		// Square and Shopify call the single size "Regular"/"Default Title"; that is not a real variant
+		if variant != "" && !isDefaultVariant(variant) {
+			item.Variants = []MenuOption{{Name: variant, Price: price}}
+		} else {
+			item.Price = price
+		}
+		if raw := cell(row, "available"); raw != "" {
+			available, err := parseBoolCell(raw)
+			if err != nil {
+				result.Errors = append(result.Errors, ImportRowError{Row: rowNum, Column: resolved.Available, Message: err.Error()})
+				continue
+			}
+			item.Available = &available
+		}
+
+		itemIndex[key] = len(section.Items)
+		section.Items = append(section.Items, item)
+		result.Items++
+	}
+
+	result.Menu = menu
+	return result, nil
+}
+
+// resolveColumns fills empty mapping fields from header synonyms and returns field -> column index
+func resolveColumns(header []string, mapping ColumnMapping) (ColumnMapping, map[string]int, error) {
+	byName := make(map[string]int, len(header))
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if _, dup := byName[h]; !dup {
+			byName[h] = i
+		}
+	}
+
+	fields := map[string]*string{
+		"id": &mapping.ID, "section": &mapping.Section, "name": &mapping.Name, "description": &mapping.Description,
+		"price": &mapping.Price, "currency": &mapping.Currency, "variant": &mapping.Variant, "tags": &mapping.Tags,
+		"available": &mapping.Available,
+	}
+	cols := make(map[string]int)
+	for field, col := range fields {
+		if *col != "" {
+			i, ok := byName[strings.ToLower(*col)]
+			if !ok {
+				return mapping, nil, fmt.Errorf("column %q for %s not found in header", *col, field)
+			}
+			cols[field] = i
+			continue
+		}
+		for _, synonym := range importHeaderSynonyms[field] {
+			if i, ok := byName[synonym]; ok {
+				cols[field] = i
+				*col = strings.TrimSpace(header[i])
+				break
+			}
+		}
+	}
+	if _, ok := cols["name"]; !ok {
+		return mapping, nil, fmt.Errorf("no item name column found; set mapping.name")
+	}
+	return mapping, cols, nil
+}
+
+var priceCleanPattern = regexp.MustCompile(`[^0-9.,\-]`)
+
+// parsePriceAmount parses "$8", "12.50", "1,234.50", "35.000" (thousands) and "12,50" (decimal comma)
+func parsePriceAmount(raw string) (float64, error) {
+	s := priceCleanPattern.ReplaceAllString(raw, "")
+	if s == "" {
+		return 0, fmt.Errorf("invalid price %q", raw)
+	}
+	lastDot, lastComma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		// The later separator is the decimal one
+		if lastComma > lastDot {
+			s = strings.ReplaceAll(s, ".", "")
+			s = strings.Replace(s, ",", ".", 1)
+		} else {
+			s = strings.ReplaceAll(s, ",", "")
+		}
+	case lastComma >= 0:
+		if len(s)-lastComma-1 == 3 {
+			s = strings.ReplaceAll(s, ",", "")
+		} else {
+			s = strings.Replace(s, ",", ".", 1)
+		}
+	case lastDot >= 0 && strings.Count(s, ".") > 1, lastDot >= 0 && len(s)-lastDot-1 == 3:
+		s = strings.ReplaceAll(s, ".", "")
+	}
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid price %q", raw)
+	}
+	return amount, nil
+}
+
+func parseBoolCell(raw string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "1", "y", "yes", "true", "available", "active", "enabled":
+		return true, nil
+	case "0", "n", "no", "false", "unavailable", "inactive", "disabled", "sold out":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid availability %q (want yes/no)", raw)
+}
+
+func splitTags(raw string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ';' || r == '|' }) {
+		t = strings.TrimSpace(t)
+		if t != "" && !seen[strings.ToLower(t)] {
+			seen[strings.ToLower(t)] = true
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func isDefaultVariant(v string) bool {
+	switch strings.ToLower(v) {
+	case "regular", "default", "default title", "standard", "-":
+		return true
+	}
+	return false
+}
+
+func isBlankRow(row []string) bool {
+	for _, v := range row {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// readImportRows parses an uploaded CSV/TSV or XLSX file into rows
+func readImportRows(filename string, data []byte, sheet string) ([][]string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".xlsx" || bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return readXLSXSheet(data, sheet)
+	}
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	if ext == ".tsv" {
+		r.Comma = '\t'
+	} else if firstLine, _, _ := bytes.Cut(data, []byte("\n")); bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		// European spreadsheet exports use semicolons
+		r.Comma = ';'
+	}
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	return rows, nil
+}
+
+// saveMenuSnapshot stores content as the branch's newest menu snapshot
+func saveMenuSnapshot(ctx context.Context, branchID string, content json.RawMessage, notes, createdBy string) (MenuSnapshot, error) {
+	return Snapshots.Create(ctx, MenuSnapshot{
+		BranchID:    branchID,
+		Content:     content,
+		ContentHash: generateHash(content),
+		Notes:       notes,
+		CreatedBy:   createdBy,
+	})
+}
+
+// ImportMenu converts an uploaded CSV or XLSX menu into structured menu content.
+// Multipart fields: file, and optionally preset (square|toast|shopify), mapping (ColumnMapping JSON),
+// sheet, currency, notes and commit. Without commit=true only the preview is returned;
+// with it the menu is saved as a snapshot, unless rows failed and allow_partial=true was not sent.
+func ImportMenu(c *gin.Context) {
+	branchID := c.Param("branchId")
+	ctx := c.Request.Context()
+
+	if _, err := Branches.Get(ctx, branchID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if fh.Size > maxImportFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file is too large"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxImportFileSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var mapping ColumnMapping
+	if preset := c.PostForm("preset"); preset != "" {
+		p, ok := importPresets[strings.ToLower(preset)]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown preset %q", preset)})
+			return
+		}
+		mapping = p
+	}
+	if raw := c.PostForm("mapping"); raw != "" {
+		// Explicit mapping fields override the preset
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mapping: " + err.Error()})
+			return
+		}
+	}
+
+	rows, err := readImportRows(fh.Filename, data, c.PostForm("sheet"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := importMenuRows(rows, mapping, c.PostForm("currency"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "mapping": result.Mapping})
+		return
+	}
+
+	content, err := json.Marshal(result.Menu)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode menu"})
+		return
+	}
+	// The importer builds typed menus, so this only catches values the schema rejects (e.g. a bad currency)
+	fieldErrs, err := validateContent(content, ContentFormatMenu)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate content", "details": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"preview":   result,
+		"committed": false,
+	}
+	if len(fieldErrs) > 0 {
+		response["fields"] = fieldErrs
+	}
+
+	if c.PostForm("commit") != "true" {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if len(fieldErrs) > 0 || result.Items == 0 {
+		response["error"] = "Menu is not valid; nothing was saved"
+		c.JSON(http.StatusUnprocessableEntity, response)
+		return
+	}
+	if len(result.Errors) > 0 && c.PostForm("allow_partial") != "true" {
+		response["error"] = "Some rows failed; fix them or send allow_partial=true"
+		c.JSON(http.StatusUnprocessableEntity, response)
+		return
+	}
+
+	notes := c.PostForm("notes")
+	if notes == "" {
+		notes = "Imported from " + fh.Filename
+	}
+	snapshot, err := saveMenuSnapshot(ctx, branchID, content, notes, currentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save snapshot"})
+		return
+	}
+
+	response["committed"] = true
+	response["snapshot_id"] = snapshot.ID
+	response["content_hash"] = snapshot.ContentHash
+	c.JSON(http.StatusCreated, response)
+}
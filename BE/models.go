@@ -22,6 +22,8 @@ type Branch struct {
 	Name         string    `json:"name" db:"name"`
 	Address      string    `json:"address" db:"address"`
 	HasChatbot   bool      `json:"has_chatbot" db:"has_chatbot"`
+	Timezone     string    `json:"timezone" db:"timezone"` // IANA name used for menu availability, default UTC
+	Profile      BranchProfile `json:"profile" db:"profile"`
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -34,6 +36,11 @@ type Chatbot struct {
 	ContentHash string    `json:"content_hash" db:"content_hash"`
 	ActiveVersionID string    `json:"active_version_id" db:"active_version_id"`
 	LastIndexedVersionID string `json:"last_indexed_version_id" db:"last_indexed_version_id"`
+	ActiveNamespace string `json:"active_namespace" db:"active_namespace"` // vector namespace queries read; see IndexBuild
+	ActiveBuildID   string `json:"active_build_id" db:"active_build_id"`
+	Settings        RetrievalSettings `json:"retrieval_settings" db:"retrieval_settings"`
+	LastError       string     `json:"last_error,omitempty" db:"last_error"` // why indexing last failed, kept after later successes
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty" db:"last_error_at"`
 	Version     int       `json:"version" db:"version"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
@@ -41,8 +48,9 @@ type Chatbot struct {
 
 // ChatbotContent represents the request for creating a chatbot
 type ChatbotContent struct {
-	BranchID string          `json:"branch_id" binding:"required"`
-	Content  json.RawMessage `json:"content" binding:"required"`
+	BranchID      string          `json:"branch_id" binding:"required"`
+	Content       json.RawMessage `json:"content" binding:"required"`
+	ContentFormat string          `json:"content_format"` // "auto" (default), "menu" or "legacy"; see validateContent
 }
 
 // TextChunk represents a chunk of text with embedding
@@ -61,6 +69,12 @@ type Metadata struct {
 	Category     string `json:"category"`
 	ItemKey   string `json:"item_key,omitempty"`
 	ItemIndex int    `json:"item_index,omitempty"`
+	Availability []Availability `json:"availability,omitempty"` // when the item is served; empty means always
+	Unavailable  bool           `json:"unavailable,omitempty"`  // marked available: false in the menu
+	Allergens         []string `json:"allergens,omitempty"`
+	AllergensDeclared bool     `json:"allergens_declared,omitempty"` // the menu lists allergens, possibly none
+	Dietary           []string `json:"dietary,omitempty"`
+	Price             *float64 `json:"price,omitempty"` // numeric dish price for range filters
 }
 
 // ChatbotVersion stores a versioned snapshot of chatbot content
@@ -83,4 +97,114 @@ type MenuSnapshot struct {
 	Notes       string          `json:"notes" db:"notes"`
 	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
 	CreatedBy   string          `json:"created_by" db:"created_by"`
+	Status      string          `json:"status" db:"status"`                   // 'published' or 'draft'; drafts are never indexed
+	Provenance  json.RawMessage `json:"provenance,omitempty" db:"provenance"` // where extracted items came from, for review
 }
+
+// Snapshot statuses
+const (
+	SnapshotPublished = "published"
+	SnapshotDraft     = "draft"
+)
+
+// IndexJob is a persisted background indexing job for a chatbot
+type IndexJob struct {
+	ID             string          `json:"id" db:"id"`
+	Kind           string          `json:"kind" db:"kind"`
+	ChatbotID      string          `json:"chatbot_id" db:"chatbot_id"`
+	Payload        json.RawMessage `json:"payload,omitempty" db:"payload"`
+	Status         string          `json:"status" db:"status"` // 'queued', 'running', 'succeeded', 'dead'
+	Attempts       int             `json:"attempts" db:"attempts"`
+	MaxAttempts    int             `json:"max_attempts" db:"max_attempts"`
+	RunAt          time.Time       `json:"run_at" db:"run_at"`
+	LeaseOwner     string          `json:"lease_owner,omitempty" db:"lease_owner"`
+	LeaseExpiresAt *time.Time      `json:"lease_expires_at,omitempty" db:"lease_expires_at"`
+	LastError      string          `json:"last_error,omitempty" db:"last_error"`
+	Progress       JobProgress     `json:"progress" db:"progress"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at" db:"updated_at"`
+	FinishedAt     *time.Time      `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// IndexJobPayload is the input of an indexing job
+type IndexJobPayload struct {
+	BranchID  string          `json:"branch_id"`
+	Content   json.RawMessage `json:"content,omitempty"`    // if omitted, VersionID or else the latest menu snapshot is indexed
+	VersionID string          `json:"version_id,omitempty"` // becomes the chatbot's last_indexed_version_id on success
+}
+
+// JobProgress reports how far an indexing job has got
+type JobProgress struct {
+	Stage            string `json:"stage,omitempty"`
+	ChunksTotal      int    `json:"chunks_total"`
+	ChunksEmbedded   int    `json:"chunks_embedded"`
+	VectorsNew       int    `json:"vectors_new"`
+	VectorsUpdated   int    `json:"vectors_updated"`
+	VectorsUnchanged int    `json:"vectors_unchanged"`
+	VectorsUpserted  int    `json:"vectors_upserted"`
+	VectorsDeleted   int    `json:"vectors_deleted"` // live vectors the new build no longer has
+}
+
+// IndexBuild is one complete index of a chatbot's content in its own vector namespace.
+// Builds are written off to the side and only go live once finished; the previous live
+// build is retired but kept for instant rollback until the retention period ends.
+type IndexBuild struct {
+	ID          string     `json:"id" db:"id"`
+	ChatbotID   string     `json:"chatbot_id" db:"chatbot_id"`
+	JobID       string     `json:"job_id,omitempty" db:"job_id"`
+	Namespace   string     `json:"namespace" db:"namespace"`
+	VersionID   string     `json:"version_id,omitempty" db:"version_id"`
+	ContentHash string     `json:"content_hash" db:"content_hash"`
+	VectorCount int        `json:"vector_count" db:"vector_count"`
+	Status      string     `json:"status" db:"status"` // see the Build* constants
+	Error       string     `json:"error,omitempty" db:"error"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty" db:"activated_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty" db:"retired_at"`
+}
+
+// Index build statuses
+const (
+	BuildBuilding = "building"
+	BuildLive     = "live"
+	BuildRetired  = "retired" // superseded; kept for rollback until garbage collected
+	BuildFailed   = "failed"
+	BuildDeleted  = "deleted" // namespace removed
+)
+
+// IndexEvent is streamed to admin clients while a chatbot is being indexed
+type IndexEvent struct {
+	Type      string       `json:"type"` // 'status', 'progress', 'retry', 'done', 'failed'
+	ChatbotID string       `json:"chatbot_id"`
+	JobID     string       `json:"job_id,omitempty"`
+	Status    string       `json:"status,omitempty"`
+	Progress  *JobProgress `json:"progress,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Attempt   int          `json:"attempt,omitempty"`
+	RetryAt   *time.Time   `json:"retry_at,omitempty"`
+	Time      time.Time    `json:"time"`
+}
+
+// GuestSession is what a guest QR code encodes: access to one branch's chat, optionally for one
+// table, until it expires, is revoked or has answered MaxUses questions
+type GuestSession struct {
+	ID         string     `json:"id" db:"id"`
+	BranchID   string     `json:"branch_id" db:"branch_id"`
+	Table      string     `json:"table,omitempty" db:"table_label"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	MaxUses    int        `json:"max_uses" db:"max_uses"` // 0 means unlimited
+	Uses       int        `json:"uses" db:"uses"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	RotatedTo  string     `json:"rotated_to,omitempty" db:"rotated_to"` // the session that replaced this one
+	CreatedBy  string     `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+}
+
+// Guest session states, see GuestSession.state
+const (
+	SessionActive    = "active"
+	SessionExpired   = "expired"
+	SessionRevoked   = "revoked"
+	SessionExhausted = "exhausted" // MaxUses reached
+)
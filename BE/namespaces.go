@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// branchNamespace is the vector namespace of a branch. It is keyed on immutable IDs,
+// so renaming a branch keeps its vectors and same-named branches never collide.
+func branchNamespace(restaurantID, branchID string) string {
+	return fmt.Sprintf("%s_%s", restaurantID, branchID)
+}
+
+// legacyBranchNamespace is the old name-based namespace; only the migration should use it
+func legacyBranchNamespace(restaurantID, branchName string) string {
+	return fmt.Sprintf("%s_%s", restaurantID, strings.ReplaceAll(branchName, " ", "_"))
+}
+
+// namespaceMigrationResult reports the migration of one branch
+type namespaceMigrationResult struct {
+	BranchID string
+	Legacy   string
+	Target   string
+	Source   int
+	Migrated int
+	Verified bool
+	Skipped  string
+}
+
+// runNamespaceMigration implements `mindmenu migrate-namespaces`. It moves every branch's vectors from the
+// legacy name-based namespace to branchNamespace, either by copying the stored vectors (default) or by
+// re-embedding the latest menu snapshot, then verifies the counts. Legacy vectors are only deleted
+// with -delete-legacy and only once verification passed.
+func runNamespaceMigration(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate-namespaces", flag.ContinueOnError)
+	mode := fs.String("mode", "copy", "copy stored vectors or reembed the latest menu snapshot (copy|reembed)")
+	branchID := fs.String("branch", "", "migrate a single branch ID")
+	dryRun := fs.Bool("dry-run", false, "report what would be migrated without writing")
+	deleteLegacy := fs.Bool("delete-legacy", false, "delete the legacy namespace after a verified migration")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *mode != "copy" && *mode != "reembed" {
+		return fmt.Errorf("unknown mode %q", *mode)
+	}
+
+	var branches []Branch
+	if *branchID != "" {
+		b, err := Branches.Get(ctx, *branchID)
+		if err != nil {
+			return fmt.Errorf("failed to load branch %s: %w", *branchID, err)
+		}
+		branches = []Branch{b}
+	} else {
+		var err error
+		branches, err = Branches.List(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list branches: %w", err)
+		}
+	}
+
+	failed := 0
+	for _, b := range branches {
+		res, err := migrateBranchNamespace(ctx, b, *mode, *dryRun, *deleteLegacy)
+		switch {
+		case err != nil:
+			failed++
+			log.Printf("Branch %s: migration failed: %v", b.ID, err)
+		case res.Skipped != "":
+			log.Printf("Branch %s: skipped (%s)", b.ID, res.Skipped)
+		default:
+			log.Printf("Branch %s: %s -> %s source=%d migrated=%d verified=%v", b.ID, res.Legacy, res.Target, res.Source, res.Migrated, res.Verified)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d branches failed to migrate", failed, len(branches))
+	}
+	return nil
+}
+
+func migrateBranchNamespace(ctx context.Context, b Branch, mode string, dryRun, deleteLegacy bool) (namespaceMigrationResult, error) {
+	res := namespaceMigrationResult{
+		BranchID: b.ID,
+		Legacy:   legacyBranchNamespace(b.RestaurantID, b.Name),
+		Target:   branchNamespace(b.RestaurantID, b.ID),
+	}
+
+	legacyIDs, err := Vectors.ListIDs(ctx, res.Legacy)
+	if err != nil {
+		return res, fmt.Errorf("failed to list legacy namespace: %w", err)
+	}
+	res.Source = len(legacyIDs)
+	if len(legacyIDs) == 0 {
+		res.Skipped = "legacy namespace is empty"
+		return res, nil
+	}
+	if dryRun {
+		res.Skipped = fmt.Sprintf("dry run, %d vectors to migrate", len(legacyIDs))
+		return res, nil
+	}
+
+	// expected is the ID set the target namespace must contain afterwards
+	var expected []string
+	switch mode {
+	case "copy":
+		for _, batch := range batchStrings(legacyIDs, 100) {
+			records, err := Vectors.Fetch(ctx, res.Legacy, batch)
+			if err != nil {
+				return res, fmt.Errorf("failed to fetch legacy vectors: %w", err)
+			}
+			upsert := make([]VectorRecord, 0, len(records))
+			for _, r := range records {
+				upsert = append(upsert, r)
+			}
+			if err := Vectors.Upsert(ctx, res.Target, upsert); err != nil {
+				return res, fmt.Errorf("failed to copy vectors: %w", err)
+			}
+			res.Migrated += len(upsert)
+		}
+		expected = legacyIDs
+	case "reembed":
+		// Rebuild what is indexed today: the last indexed version, else the latest snapshot
+		var payload IndexJobPayload
+		if bot, err := Chatbots.Get(ctx, b.ID); err == nil {
+			payload.VersionID = bot.LastIndexedVersionID
+		}
+		content, err := resolveIndexContent(ctx, b.ID, b.ID, payload)
+		if err != nil {
+			return res, err
+		}
+		chunks, err := prepareChunks(content, b.RestaurantID, b.ID)
+		if err != nil {
+			return res, err
+		}
+		chunks, err = generateEmbeddings(ctx, chunks, nil)
+		if err != nil {
+			return res, fmt.Errorf("embeddings error: %w", err)
+		}
+		stats, err := storeChunksInPinecone(ctx, chunks, res.Target)
+		if err != nil {
+			return res, err
+		}
+		res.Migrated = stats.New + stats.Updated + stats.Unchanged
+		for _, chunk := range chunks {
+			expected = append(expected, computeDeterministicID(chunk.Metadata))
+		}
+	}
+
+	// Verify: every expected ID is present in the target namespace
+	targetIDs, err := Vectors.ListIDs(ctx, res.Target)
+	if err != nil {
+		return res, fmt.Errorf("failed to list target namespace: %w", err)
+	}
+	present := make(map[string]struct{}, len(targetIDs))
+	for _, id := range targetIDs {
+		present[id] = struct{}{}
+	}
+	var missing []string
+	for _, id := range expected {
+		if _, ok := present[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return res, fmt.Errorf("verification failed: %d of %d vectors missing from %s (first: %s)", len(missing), len(expected), res.Target, missing[0])
+	}
+	res.Verified = true
+
+	if deleteLegacy {
+		if err := deleteVectorsByID(ctx, res.Legacy, legacyIDs); err != nil {
+			return res, fmt.Errorf("migrated and verified, but failed to delete legacy namespace: %w", err)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	// qrSessionTTL is the default lifetime of sessions created for printed codes
+	qrSessionTTL   = 90 * 24 * time.Hour
+	defaultQRSize  = 512
+	minQRSize      = 128
+	maxQRSize      = 2048
+	maxBatchTables = 500
+)
+
+var (
+	tableRangePattern = regexp.MustCompile(`^(\d+)\s*-\s*(\d+)$`)
+	unsafeFileChars   = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+)
+
+// clientURL is the guest chat page a code opens: CLIENT_URL (the FE origin) + /c/<session id>
+func clientURL(sessionID string) string {
+	return strings.TrimRight(envOr("CLIENT_URL", "http://localhost:3000"), "/") + "/c/" + sessionID
+}
+
+// qrSessionOptions are the expiry and usage limit of sessions a QR request creates
+type qrSessionOptions struct {
+	expiresIn string
+	maxUses   int
+}
+
+// bindQRSessionOptions reads expires_in (default 90 days) and max_uses from the query string
+func bindQRSessionOptions(c *gin.Context) (qrSessionOptions, bool) {
+	opts := qrSessionOptions{expiresIn: c.DefaultQuery("expires_in", qrSessionTTL.String())}
+	if v := c.Query("max_uses"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_uses must be 0 (unlimited) or more"})
+			return opts, false
+		}
+		opts.maxUses = n
+	}
+	if _, msg := sessionLifetime(opts.expiresIn, nil, time.Now()); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return opts, false
+	}
+	return opts, true
+}
+
+// activeTableSessions maps each table of a branch to its newest active session; "" is the branch-wide one
+func activeTableSessions(ctx context.Context, branchID string) (map[string]GuestSession, error) {
+	sessions, err := Sessions.ListByBranch(ctx, branchID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	active := map[string]GuestSession{}
+	for _, s := range sessions {
+		if _, seen := active[s.Table]; !seen && s.state(now) == SessionActive {
+			active[s.Table] = s
+		}
+	}
+	return active, nil
+}
+
+// sessionForTable reuses the table's active session, or creates one, so reprinting a code does not
+// invalidate the one already on the table
+func sessionForTable(ctx context.Context, active map[string]GuestSession, branchID, table, createdBy string, opts qrSessionOptions) (GuestSession, error) {
+	if s, ok := active[table]; ok {
+		return s, nil
+	}
+	expiresAt, _ := sessionLifetime(opts.expiresIn, nil, time.Now())
+	return Sessions.Create(ctx, GuestSession{
+		BranchID:  branchID,
+		Table:     table,
+		ExpiresAt: expiresAt,
+		MaxUses:   opts.maxUses,
+		CreatedBy: createdBy,
+	})
+}
+
+// qrSize reads the size query parameter: the PNG edge in pixels, also used as the SVG's width and height
+func qrSize(c *gin.Context) (int, bool) {
+	v := c.Query("size")
+	if v == "" {
+		return defaultQRSize, true
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil || size < minQRSize || size > maxQRSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("size must be %d..%d pixels", minQRSize, maxQRSize)})
+		return 0, false
+	}
+	return size, true
+}
+
+// renderQR encodes url as a PNG or SVG image and returns it with its content type
+func renderQR(url, format string, size int) ([]byte, string, error) {
+	code, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	switch format {
+	case "svg":
+		return qrSVG(code.Bitmap(), size), "image/svg+xml", nil
+	default:
+		png, err := code.PNG(size)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to render QR code: %w", err)
+		}
+		return png, "image/png", nil
+	}
+}
+
+// qrSVG draws a module bitmap (quiet zone included) as one path, merging each row's dark runs
+func qrSVG(bitmap [][]bool, size int) []byte {
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, len(bitmap), len(bitmap))
+	fmt.Fprintf(&out, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`, path.String())
+	return out.Bytes()
+}
+
+// fileStem makes a label safe to use in a file name
+func fileStem(label string) string {
+	return strings.Trim(unsafeFileChars.ReplaceAllString(label, "_"), "_")
+}
+
+// tableFileName names the image of a table's code, or of the branch-wide code
+func tableFileName(table string) string {
+	if name := fileStem(table); name != "" {
+		return "table-" + name
+	}
+	return "branch"
+}
+
+// GetBranchQR returns the QR code of a branch's table (or of the whole branch without table),
+// creating the guest session it points at unless an active one exists
+func GetBranchQR(c *gin.Context) {
+	branchID := c.Param("branchId")
+	table := strings.TrimSpace(c.Query("table"))
+	format := strings.ToLower(c.DefaultQuery("format", "png"))
+	if format != "png" && format != "svg" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be png or svg"})
+		return
+	}
+	size, ok := qrSize(c)
+	if !ok {
+		return
+	}
+	opts, ok := bindQRSessionOptions(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	active, err := activeTableSessions(ctx, branchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions", "details": err.Error()})
+		return
+	}
+	session, err := sessionForTable(ctx, active, branchID, table, currentUser(c).ID, opts)
+	if err != nil {
+		log.Printf("QR session error for branch %s: %v", branchID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session", "details": err.Error()})
+		return
+	}
+	url := clientURL(session.ID)
+	image, contentType, err := renderQR(url, format, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("X-Session-Id", session.ID)
+	c.Header("X-Client-Url", url)
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s.%s"`, tableFileName(table), format))
+	c.Data(http.StatusOK, contentType, image)
+}
+
+// parseTables expands a tables parameter such as "1-12,Bar,Patio 2" into labels, keeping order
+func parseTables(spec string) ([]string, error) {
+	var tables []string
+	seen := map[string]bool{}
+	add := func(t string) error {
+		if seen[t] {
+			return nil
+		}
+		if len(tables) == maxBatchTables {
+			return fmt.Errorf("at most %d tables per batch", maxBatchTables)
+		}
+		seen[t] = true
+		tables = append(tables, t)
+		return nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if m := tableRangePattern.FindStringSubmatch(part); m != nil {
+			from, _ := strconv.Atoi(m[1])
+			until, _ := strconv.Atoi(m[2])
+			if from > until || until-from >= maxBatchTables {
+				return nil, fmt.Errorf("invalid table range %q", part)
+			}
+			for n := from; n <= until; n++ {
+				if err := add(strconv.Itoa(n)); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err := add(part); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+// knownTables lists the tables that have active sessions, numbers first in numeric order
+func knownTables(active map[string]GuestSession) []string {
+	var tables []string
+	for table := range active {
+		if table != "" {
+			tables = append(tables, table)
+		}
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		a, errA := strconv.Atoi(tables[i])
+		b, errB := strconv.Atoi(tables[j])
+		switch {
+		case errA == nil && errB == nil:
+			return a < b
+		case errA == nil || errB == nil:
+			return errA == nil
+		}
+		return tables[i] < tables[j]
+	})
+	return tables
+}
+
+// tableCode is one rendered code of a batch
+type tableCode struct {
+	Table   string
+	Session GuestSession
+	URL     string
+	PNG     []byte
+}
+
+// GetBranchQRBatch renders codes for many tables as a printable PDF (six per A4 page) or a ZIP of
+// PNG or SVG images. Tables come from ?tables=1-12,Bar, else from the branch's active sessions.
+func GetBranchQRBatch(c *gin.Context) {
+	branchID := c.Param("branchId")
+	ctx := c.Request.Context()
+	format := strings.ToLower(c.DefaultQuery("format", "pdf"))
+	image := strings.ToLower(c.DefaultQuery("image", "png"))
+	if format != "pdf" && format != "zip" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be pdf or zip"})
+		return
+	}
+	if image != "png" && (image != "svg" || format != "zip") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image must be png, or svg for zip batches"})
+		return
+	}
+	size, ok := qrSize(c)
+	if !ok {
+		return
+	}
+	opts, ok := bindQRSessionOptions(c)
+	if !ok {
+		return
+	}
+
+	tables, err := parseTables(c.Query("tables"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	active, err := activeTableSessions(ctx, branchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions", "details": err.Error()})
+		return
+	}
+	if len(tables) == 0 {
+		tables = knownTables(active)
+	}
+	if len(tables) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No tables given; pass tables, e.g. tables=1-12,Bar"})
+		return
+	}
+	branch, err := Branches.Get(ctx, branchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branch", "details": err.Error()})
+		return
+	}
+
+	codes := make([]tableCode, 0, len(tables))
+	for _, table := range tables {
+		session, err := sessionForTable(ctx, active, branchID, table, currentUser(c).ID, opts)
+		if err != nil {
+			log.Printf("QR batch session error for branch %s table %s: %v", branchID, table, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session for table " + table, "details": err.Error()})
+			return
+		}
+		codes = append(codes, tableCode{Table: table, Session: session, URL: clientURL(session.ID)})
+	}
+
+	var out []byte
+	var contentType string
+	if format == "pdf" {
+		out, err = qrPDF(branch, codes)
+		contentType = "application/pdf"
+	} else {
+		out, err = qrZIP(codes, image, size)
+		contentType = "application/zip"
+	}
+	if err != nil {
+		log.Printf("QR batch render error for branch %s: %v", branchID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR codes", "details": err.Error()})
+		return
+	}
+	name := "qr-codes"
+	if stem := fileStem(branch.Name); stem != "" {
+		name = "qr-" + stem
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	c.Data(http.StatusOK, contentType, out)
+}
+
+// qrZIP packs one image per table
+func qrZIP(codes []tableCode, image string, size int) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, code := range codes {
+		data, _, err := renderQR(code.URL, image, size)
+		if err != nil {
+			return nil, err
+		}
+		w, err := archive.Create(tableFileName(code.Table) + "." + image)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// qrPDF lays codes out two across and three down on A4 pages, each captioned with its table
+func qrPDF(branch Branch, codes []tableCode) ([]byte, error) {
+	const (
+		cols, rows   = 2, 3
+		cellW, cellH = 95.0, 92.0
+		marginX      = 10.0
+		marginY      = 10.0
+		codeSize     = 70.0
+	)
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("QR codes - "+branch.Name, true)
+	pdf.SetAutoPageBreak(false, 0)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	for i, code := range codes {
+		if i%(cols*rows) == 0 {
+			pdf.AddPage()
+		}
+		slot := i % (cols * rows)
+		x := marginX + float64(slot%cols)*cellW
+		y := marginY + float64(slot/cols)*cellH
+
+		png, _, err := renderQR(code.URL, "png", defaultQRSize)
+		if err != nil {
+			return nil, err
+		}
+		name := "qr-" + code.Session.ID
+		pdf.RegisterImageOptionsReader(name, gofpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(png))
+		pdf.ImageOptions(name, x+(cellW-codeSize)/2, y, codeSize, codeSize, false, gofpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+
+		pdf.SetXY(x, y+codeSize+1)
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.CellFormat(cellW, 7, tr("Table "+code.Table), "", 2, "C", false, 0, "")
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.CellFormat(cellW, 4, tr(branch.Name), "", 2, "C", false, 0, "")
+		pdf.CellFormat(cellW, 4, code.URL, "", 0, "C", false, 0, "")
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("failed to write PDF: %w", err)
+	}
+	return buf.Bytes(), nil
+}
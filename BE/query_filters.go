@@ -0,0 +1,246 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// courseTerms maps each course to the category words that name it. The same table tags chunks at
+// index time (from their category and JSON path) and reads questions, so "Starters" in the menu
+// matches "any appetizers?" in a question.
+var courseTerms = map[string][]string{
+	"appetizer": {"appetizer", "appetizers", "appetiser", "appetisers", "starter", "starters", "small plates", "snacks", "tapas"},
+	"main":      {"main", "mains", "main course", "main courses", "main dishes", "entrees"},
+	"dessert":   {"dessert", "desserts", "sweets", "pastries"},
+	"drink":     {"drink", "drinks", "beverage", "beverages"},
+	"alcohol":   {"alcohol", "alcoholic", "wine", "wines", "beer", "beers", "cocktail", "cocktails", "spirits"},
+	"breakfast": {"breakfast", "brunch"},
+	"side":      {"sides", "side dish", "side dishes"},
+	"soup":      {"soup", "soups"},
+	"salad":     {"salad", "salads"},
+	"kids":      {"kids", "kids menu", "children"},
+}
+
+// nonAlcoholicPattern keeps "non-alcoholic drinks" from being read as alcohol
+var nonAlcoholicPattern = regexp.MustCompile(`\b(non|no|alcohol) (alcoholic|free)\b|\bmocktails?\b`)
+
+// A price bound is a number with an optional currency before it and an optional "k" or word after it
+const (
+	priceCurrency = `([$€£¥₹]|\brp\.?|\bidr|\busd|\beur|\bsgd)?`
+	priceAmount   = `(\d[\d.,]*)\s*(k\b)?(?:\s*([a-z]+))?`
+)
+
+var (
+	// "under $10", "less than 35k", "up to Rp 50.000"
+	maxPricePattern = regexp.MustCompile(`\b(?:under|below|less than|cheaper than|up to|at most|max|maximum|no more than|within)\s*` + priceCurrency + `\s*` + priceAmount)
+	// "over $20", "more than 15k", "at least 10 dollars"
+	minPricePattern = regexp.MustCompile(`\b(?:over|above|more than|at least|min|minimum|pricier than)\s*` + priceCurrency + `\s*` + priceAmount)
+	// "between $10 and $20", "from 5k to 8k"
+	rangePricePattern = regexp.MustCompile(`\b(?:between|from)\s*` + priceCurrency + `\s*(\d[\d.,]*)\s*(k\b)?\s*(?:and|to|-)\s*` + priceCurrency + `\s*` + priceAmount)
+
+	// Without a currency marker a bound only counts as a price when the question talks about prices
+	priceWordPattern = regexp.MustCompile(`\b(?:price[sd]?|pricing|cost|costs|cheap|cheaper|cheapest|expensive|pricier|budget)\b`)
+)
+
+// priceCurrencyWords mark the number before them as an amount: "under 20 dollars"
+var priceCurrencyWords = map[string]bool{
+	"dollar": true, "dollars": true, "bucks": true, "rupiah": true, "idr": true, "usd": true, "eur": true,
+	"euro": true, "euros": true, "sgd": true, "rb": true, "ribu": true,
+}
+
+// priceUnitWords make the number before them something other than a price: "under 500 calories"
+var priceUnitWords = map[string]bool{
+	"minute": true, "minutes": true, "min": true, "mins": true, "hour": true, "hours": true, "hr": true, "hrs": true,
+	"people": true, "person": true, "persons": true, "guests": true, "pax": true, "seats": true, "years": true,
+	"calorie": true, "calories": true, "cal": true, "kcal": true, "g": true, "gram": true, "grams": true,
+	"kg": true, "ml": true, "pcs": true, "pc": true, "piece": true, "pieces": true, "servings": true,
+	"items": true, "dishes": true, "percent": true,
+}
+
+// isPriceBound reports whether a matched bound is a price: it must not be followed by a unit word,
+// and it needs a currency marker, a thousands "k" or a price word somewhere in the question
+func isPriceBound(q, currency, k, next string) bool {
+	if priceUnitWords[next] {
+		return false
+	}
+	return currency != "" || k != "" || priceCurrencyWords[next] || priceWordPattern.MatchString(q)
+}
+
+// queryFilters is what the question asks to narrow retrieval to
+type queryFilters struct {
+	Courses  []string `json:"courses,omitempty"`
+	Dietary  []string `json:"dietary,omitempty"`
+	MinPrice *float64 `json:"min_price,omitempty"`
+	MaxPrice *float64 `json:"max_price,omitempty"`
+}
+
+// understandQuery maps a question to metadata filters. It only picks up explicit category words,
+// dietary tags and price bounds; anything else leaves retrieval unfiltered.
+func understandQuery(question string) queryFilters {
+	q := strings.ToLower(question)
+	var f queryFilters
+	f.Courses = matchCourses(q)
+	for _, tag := range sortedTermKeys(dietaryTerms) {
+		if containsTerm(q, dietaryTerms[tag]) {
+			f.Dietary = append(f.Dietary, tag)
+		}
+	}
+
+	if m := rangePricePattern.FindStringSubmatch(q); m != nil && isPriceBound(q, m[1]+m[4], m[3]+m[6], m[7]) {
+		f.MinPrice = queryPrice(m[2], m[3])
+		f.MaxPrice = queryPrice(m[5], m[6])
+	} else {
+		if m := maxPricePattern.FindStringSubmatch(q); m != nil && isPriceBound(q, m[1], m[3], m[4]) {
+			f.MaxPrice = queryPrice(m[2], m[3])
+		}
+		if m := minPricePattern.FindStringSubmatch(q); m != nil && isPriceBound(q, m[1], m[3], m[4]) {
+			f.MinPrice = queryPrice(m[2], m[3])
+		}
+	}
+	return f
+}
+
+// Empty reports whether the question asked for no filter at all
+func (f queryFilters) Empty() bool {
+	return len(f.Courses) == 0 && len(f.Dietary) == 0 && f.MinPrice == nil && f.MaxPrice == nil
+}
+
+// metadataFilter renders the filters in vector store syntax, or nil when there are none
+func (f queryFilters) metadataFilter() map[string]interface{} {
+	var conds []interface{}
+	if len(f.Courses) > 0 {
+		courses := make([]interface{}, len(f.Courses))
+		for i, c := range f.Courses {
+			courses[i] = c
+		}
+		conds = append(conds, map[string]interface{}{"courses": map[string]interface{}{"$in": courses}})
+	}
+	for _, d := range f.Dietary {
+		conds = append(conds, map[string]interface{}{"dietary": map[string]interface{}{"$in": []interface{}{d}}})
+	}
+	if f.MinPrice != nil {
+		conds = append(conds, map[string]interface{}{"price": map[string]interface{}{"$gte": *f.MinPrice}})
+	}
+	if f.MaxPrice != nil {
+		conds = append(conds, map[string]interface{}{"price": map[string]interface{}{"$lte": *f.MaxPrice}})
+	}
+	if len(conds) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"$and": conds}
+}
+
+func (f queryFilters) debug(fallback bool) gin.H {
+	return gin.H{"filters": f, "applied": !f.Empty(), "fallback": fallback}
+}
+
+// andFilter combines filters, skipping nil ones
+func andFilter(filters ...map[string]interface{}) map[string]interface{} {
+	var conds []interface{}
+	for _, f := range filters {
+		if f != nil {
+			conds = append(conds, f)
+		}
+	}
+	switch len(conds) {
+	case 0:
+		return nil
+	case 1:
+		return conds[0].(map[string]interface{})
+	}
+	return map[string]interface{}{"$and": conds}
+}
+
+// matchCourses returns the courses whose category words occur in text
+func matchCourses(text string) []string {
+	text = strings.ToLower(text)
+	nonAlcoholic := nonAlcoholicPattern.MatchString(strings.Join(wordPattern.FindAllString(text, -1), " "))
+	var courses []string
+	for _, course := range sortedTermKeys(courseTerms) {
+		if course == "alcohol" && nonAlcoholic {
+			continue
+		}
+		if containsTerm(text, courseTerms[course]) {
+			courses = append(courses, course)
+		}
+	}
+	return courses
+}
+
+// chunkCourses tags a chunk with the courses named by its category and JSON path
+func chunkCourses(m Metadata) []string {
+	return matchCourses(m.Category + " " + pathLabel(m.Source))
+}
+
+// queryMetadata is the vector metadata that query understanding filters on
+func queryMetadata(m Metadata) map[string]interface{} {
+	meta := map[string]interface{}{}
+	if courses := chunkCourses(m); len(courses) > 0 {
+		list := make([]interface{}, len(courses))
+		for i, c := range courses {
+			list[i] = c
+		}
+		meta["courses"] = list
+	}
+	if m.Price != nil {
+		meta["price"] = *m.Price
+	}
+	return meta
+}
+
+// dishPrice parses a dish's price text into a number for range filters; variants use the lowest price
+func dishPrice(text string) *float64 {
+	var lowest *float64
+	for _, part := range strings.Split(text, ",") {
+		match := pricePattern.FindString(part)
+		if match == "" {
+			match = strings.TrimSpace(part)
+		}
+		amount, err := parsePriceAmount(match)
+		if err != nil {
+			continue
+		}
+		if strings.HasSuffix(strings.ToLower(strings.TrimSpace(match)), "k") {
+			amount *= 1000
+		}
+		if lowest == nil || amount < *lowest {
+			lowest = &amount
+		}
+	}
+	return lowest
+}
+
+// queryPrice parses an amount from a question, honouring a "k" suffix
+func queryPrice(amount, k string) *float64 {
+	v, err := parsePriceAmount(amount)
+	if err != nil {
+		return nil
+	}
+	if k != "" {
+		v *= 1000
+	}
+	return &v
+}
+
+// containsTerm reports whether any term occurs in text as whole words
+func containsTerm(text string, terms []string) bool {
+	padded := " " + strings.Join(wordPattern.FindAllString(strings.ToLower(text), -1), " ") + " "
+	for _, term := range terms {
+		if strings.Contains(padded, " "+term+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedTermKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestUnderstandQueryPrices(t *testing.T) {
+	price := func(v float64) *float64 { return &v }
+	tests := []struct {
+		question string
+		min, max *float64
+	}{
+		{"anything under $10?", nil, price(10)},
+		{"mains less than 35k", nil, price(35000)},
+		{"something up to Rp 50.000", nil, price(50000)},
+		{"dishes under 20 dollars", nil, price(20)},
+		{"what costs under 15?", nil, price(15)},
+		{"cheap food below 30000", nil, price(30000)},
+		{"wine over $40", price(40), nil},
+		{"price at least 100k", price(100000), nil},
+		{"between $10 and $20", price(10), price(20)},
+		{"priced from 5k to 8k", price(5000), price(8000)},
+
+		// Numbers that are not prices
+		{"anything under 500 calories?", nil, nil},
+		{"a table for more than 4 people", nil, nil},
+		{"desserts ready within 10 minutes", nil, nil},
+		{"sets with at least 6 pcs", nil, nil},
+		{"anything under 20?", nil, nil},
+		{"between 2 and 4 people", nil, nil},
+		{"cheap snacks under 300 kcal", nil, nil},
+		{"I'm an admin 5 years", nil, nil},
+	}
+	for _, tt := range tests {
+		f := understandQuery(tt.question)
+		if !samePrice(f.MinPrice, tt.min) || !samePrice(f.MaxPrice, tt.max) {
+			t.Errorf("%q: min %v max %v, want min %v max %v", tt.question, deref(f.MinPrice), deref(f.MaxPrice), deref(tt.min), deref(tt.max))
+		}
+	}
+}
+
+func samePrice(a, b *float64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func deref(p *float64) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by repositories when no row matches
+var ErrNotFound = errors.New("not found")
+
+// RestaurantRepo stores restaurants. Deleting a restaurant deletes its branches through the
+// schema's ON DELETE CASCADE; vector namespaces are cleaned up by the caller.
+type RestaurantRepo interface {
+	Create(ctx context.Context, r Restaurant) (Restaurant, error)
+	Get(ctx context.Context, id string) (Restaurant, error)
+	// ListByOwner returns an owner's restaurants, oldest first
+	ListByOwner(ctx context.Context, ownerID string) ([]Restaurant, error)
+	Update(ctx context.Context, id string, fields map[string]interface{}) error
+	Delete(ctx context.Context, id string) error
+}
+
+// BranchRepo stores restaurant branches. Update takes a column -> value map; "profile" takes a BranchProfile.
+type BranchRepo interface {
+	Create(ctx context.Context, b Branch) (Branch, error)
+	Get(ctx context.Context, id string) (Branch, error)
+	List(ctx context.Context) ([]Branch, error)
+	ListByRestaurant(ctx context.Context, restaurantID string) ([]Branch, error)
+	Update(ctx context.Context, id string, fields map[string]interface{}) error
+	SetHasChatbot(ctx context.Context, id string, hasChatbot bool) error
+	// Delete removes a branch with its chatbot, snapshots, sessions and chat history (ON DELETE CASCADE)
+	Delete(ctx context.Context, id string) error
+}
+
+// ChatbotRepo stores chatbots. Update takes a column -> value map like the PostgREST update body.
+type ChatbotRepo interface {
+	Create(ctx context.Context, c Chatbot) (Chatbot, error)
+	Get(ctx context.Context, id string) (Chatbot, error)
+	FindByBranchAndHash(ctx context.Context, branchID, contentHash string) (Chatbot, error)
+	Update(ctx context.Context, id string, fields map[string]interface{}) error
+	// Delete removes a chatbot with its versions, jobs and build records (ON DELETE CASCADE)
+	Delete(ctx context.Context, id string) error
+}
+
+// VersionRepo stores chatbot content versions. Content hashes are unique per chatbot.
+type VersionRepo interface {
+	Create(ctx context.Context, v ChatbotVersion) (ChatbotVersion, error)
+	Get(ctx context.Context, id string) (ChatbotVersion, error)
+	FindByHash(ctx context.Context, chatbotID, contentHash string) (ChatbotVersion, error)
+	// List returns a chatbot's versions newest first, without content
+	List(ctx context.Context, chatbotID string) ([]ChatbotVersion, error)
+}
+
+// SnapshotRepo stores raw menu snapshots per branch. Latest only considers published snapshots;
+// Publish turns a draft into the branch's newest published snapshot.
+type SnapshotRepo interface {
+	Create(ctx context.Context, s MenuSnapshot) (MenuSnapshot, error)
+	Get(ctx context.Context, id string) (MenuSnapshot, error)
+	Latest(ctx context.Context, branchID string) (MenuSnapshot, error)
+	Publish(ctx context.Context, id string) (MenuSnapshot, error)
+	// List returns a page of a branch's snapshots, newest first, without content, and the total count.
+	// status filters by SnapshotPublished or SnapshotDraft; empty lists both.
+	List(ctx context.Context, branchID, status string, limit, offset int) ([]MenuSnapshot, int, error)
+}
+
+// IndexBuildRepo records the vector namespaces built for each chatbot
+type IndexBuildRepo interface {
+	Create(ctx context.Context, b IndexBuild) (IndexBuild, error)
+	Get(ctx context.Context, id string) (IndexBuild, error)
+	// List returns a chatbot's builds, newest first
+	List(ctx context.Context, chatbotID string) ([]IndexBuild, error)
+	Update(ctx context.Context, id string, fields map[string]interface{}) error
+	// ListGarbage returns failed builds and builds retired before retiredBefore, whose namespaces can go
+	ListGarbage(ctx context.Context, retiredBefore time.Time) ([]IndexBuild, error)
+}
+
+// ChatHistoryRepo stores guest conversations keyed by session; rows carry their branch so
+// deleting the branch deletes them
+type ChatHistoryRepo interface {
+	Append(ctx context.Context, h ChatHistory) error
+	// Recent returns up to limit interactions for a session, oldest first
+	Recent(ctx context.Context, sessionID string, limit int) ([]ChatHistory, error)
+}
+
+// JobRepo persists background jobs. Claim leases the oldest runnable job to owner
+// and returns ErrNotFound when nothing is runnable; the lease must be extended while the job runs.
+// At most one job per chatbot runs at a time, so builds of the same chatbot switch in queue order.
+type JobRepo interface {
+	Enqueue(ctx context.Context, job IndexJob) (IndexJob, error)
+	Get(ctx context.Context, id string) (IndexJob, error)
+	Claim(ctx context.Context, owner string, lease time.Duration) (IndexJob, error)
+	ExtendLease(ctx context.Context, id, owner string, lease time.Duration) error
+	UpdateProgress(ctx context.Context, id string, progress JobProgress) error
+	Complete(ctx context.Context, id, owner string) error
+	// Fail records an error; the job is requeued at retryAt, or dead-lettered when retryAt is nil
+	Fail(ctx context.Context, id, owner, errMsg string, retryAt *time.Time) error
+	// RecoverExpired requeues running jobs whose lease has expired
+	RecoverExpired(ctx context.Context) (int, error)
+}
+
+// AccountRepo registers and signs in restaurant owners. Register returns ErrAccountExists for a
+// taken email and SignIn returns ErrInvalidCredentials for a wrong email or password.
+type AccountRepo interface {
+	Register(ctx context.Context, email, password string) (AuthUser, error)
+	SignIn(ctx context.Context, email, password string) (AuthSession, error)
+}
+
+// GuestSessionRepo stores guest sessions. Use counts one question against a usable session and
+// reports false, changing nothing, when the session is revoked, expired or used up, or is still
+// usable but too contended to count.
+type GuestSessionRepo interface {
+	Create(ctx context.Context, s GuestSession) (GuestSession, error)
+	Get(ctx context.Context, id string) (GuestSession, error)
+	// ListByBranch returns a branch's sessions, newest first
+	ListByBranch(ctx context.Context, branchID string) ([]GuestSession, error)
+	Update(ctx context.Context, id string, fields map[string]interface{}) error
+	Use(ctx context.Context, id string, now time.Time) (GuestSession, bool, error)
+}
+
+var (
+	Accounts    AccountRepo
+	Restaurants RestaurantRepo
+	Branches    BranchRepo
+	Chatbots    ChatbotRepo
+	Versions    VersionRepo
+	Snapshots   SnapshotRepo
+	Histories   ChatHistoryRepo
+	Jobs        JobRepo
+	Builds      IndexBuildRepo
+	Sessions    GuestSessionRepo
+)
+
+// initRepositories wires the repositories for DB_BACKEND ("supabase" or "postgres")
+func initRepositories(ctx context.Context) error {
+	backend := strings.ToLower(envOr("DB_BACKEND", "supabase"))
+	switch backend {
+	case "supabase":
+		return initSupabaseRepositories(os.Getenv("SUPABASE_URL"), os.Getenv("SUPABASE_SERVICE_ROLE_KEY"))
+	case "postgres", "sql":
+		return initSQLRepositories(ctx, os.Getenv("DATABASE_URL"))
+	default:
+		return fmt.Errorf("unknown DB_BACKEND %q", backend)
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memTable is an in-memory table for the test repositories. Rows are kept in insertion order and
+// updated through their JSON form, so column -> value maps apply as they do to the real tables.
+type memTable[T any] struct {
+	mu   sync.Mutex
+	rows []T
+	id   func(T) string
+}
+
+func (t *memTable[T]) find(id string) int {
+	for i, row := range t.rows {
+		if t.id(row) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *memTable[T]) get(id string) (T, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var zero T
+	i := t.find(id)
+	if i < 0 {
+		return zero, ErrNotFound
+	}
+	return t.rows[i], nil
+}
+
+func (t *memTable[T]) insert(row T) T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows = append(t.rows, row)
+	return row
+}
+
+func (t *memTable[T]) update(id string, fields map[string]interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := t.find(id)
+	if i < 0 {
+		return nil // UPDATE ... WHERE id matching nothing is not an error
+	}
+	updated, err := applyFields(t.rows[i], fields)
+	if err != nil {
+		return err
+	}
+	t.rows[i] = updated
+	return nil
+}
+
+func (t *memTable[T]) remove(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := t.find(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	t.rows = append(t.rows[:i], t.rows[i+1:]...)
+	return nil
+}
+
+// filter returns matching rows, newest first
+func (t *memTable[T]) filter(match func(T) bool) []T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := []T{}
+	for i := len(t.rows) - 1; i >= 0; i-- {
+		if match(t.rows[i]) {
+			out = append(out, t.rows[i])
+		}
+	}
+	return out
+}
+
+// applyFields sets columns on row by their JSON names; nil clears a column
+func applyFields[T any](row T, fields map[string]interface{}) (T, error) {
+	var out T
+	data, err := json.Marshal(row)
+	if err != nil {
+		return out, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return out, err
+	}
+	for k, v := range fields {
+		m[k] = v
+	}
+	if data, err = json.Marshal(m); err != nil {
+		return out, err
+	}
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
+
+func newID(id string) string {
+	if id == "" {
+		return uuid.New().String()
+	}
+	return id
+}
+
+// useMemoryRepositories points every repository at a fresh in-memory store
+func useMemoryRepositories() {
+	Restaurants = &memRestaurantRepo{memTable[Restaurant]{id: func(r Restaurant) string { return r.ID }}}
+	Branches = &memBranchRepo{memTable[Branch]{id: func(b Branch) string { return b.ID }}}
+	Chatbots = &memChatbotRepo{memTable[Chatbot]{id: func(c Chatbot) string { return c.ID }}}
+	Versions = &memVersionRepo{memTable[ChatbotVersion]{id: func(v ChatbotVersion) string { return v.ID }}}
+	Snapshots = &memSnapshotRepo{memTable[MenuSnapshot]{id: func(s MenuSnapshot) string { return s.ID }}}
+	Histories = &memChatHistoryRepo{memTable[ChatHistory]{id: func(h ChatHistory) string { return h.ID }}}
+	Jobs = &memJobRepo{memTable[IndexJob]{id: func(j IndexJob) string { return j.ID }}}
+	Builds = &memIndexBuildRepo{memTable[IndexBuild]{id: func(b IndexBuild) string { return b.ID }}}
+	Sessions = &memGuestSessionRepo{memTable[GuestSession]{id: func(s GuestSession) string { return s.ID }}}
+}
+
+type memRestaurantRepo struct{ t memTable[Restaurant] }
+
+func (r *memRestaurantRepo) Create(ctx context.Context, x Restaurant) (Restaurant, error) {
+	x.ID = newID(x.ID)
+	x.CreatedAt = time.Now().UTC()
+	return r.t.insert(x), nil
+}
+
+func (r *memRestaurantRepo) Get(ctx context.Context, id string) (Restaurant, error) {
+	return r.t.get(id)
+}
+
+func (r *memRestaurantRepo) ListByOwner(ctx context.Context, ownerID string) ([]Restaurant, error) {
+	out := r.t.filter(func(x Restaurant) bool { return x.OwnerID == ownerID })
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (r *memRestaurantRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return r.t.update(id, fields)
+}
+
+func (r *memRestaurantRepo) Delete(ctx context.Context, id string) error {
+	return r.t.remove(id)
+}
+
+type memBranchRepo struct{ t memTable[Branch] }
+
+func (r *memBranchRepo) Create(ctx context.Context, b Branch) (Branch, error) {
+	b.ID = newID(b.ID)
+	b.CreatedAt = time.Now().UTC()
+	return r.t.insert(b), nil
+}
+
+func (r *memBranchRepo) Get(ctx context.Context, id string) (Branch, error) {
+	return r.t.get(id)
+}
+
+func (r *memBranchRepo) List(ctx context.Context) ([]Branch, error) {
+	return r.t.filter(func(Branch) bool { return true }), nil
+}
+
+func (r *memBranchRepo) ListByRestaurant(ctx context.Context, restaurantID string) ([]Branch, error) {
+	return r.t.filter(func(b Branch) bool { return b.RestaurantID == restaurantID }), nil
+}
+
+func (r *memBranchRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return r.t.update(id, fields)
+}
+
+func (r *memBranchRepo) SetHasChatbot(ctx context.Context, id string, hasChatbot bool) error {
+	return r.t.update(id, map[string]interface{}{"has_chatbot": hasChatbot})
+}
+
+func (r *memBranchRepo) Delete(ctx context.Context, id string) error {
+	return r.t.remove(id)
+}
+
+type memChatbotRepo struct{ t memTable[Chatbot] }
+
+func (r *memChatbotRepo) Create(ctx context.Context, c Chatbot) (Chatbot, error) {
+	c.ID = newID(c.ID)
+	c.CreatedAt = time.Now().UTC()
+	return r.t.insert(c), nil
+}
+
+func (r *memChatbotRepo) Get(ctx context.Context, id string) (Chatbot, error) {
+	return r.t.get(id)
+}
+
+func (r *memChatbotRepo) FindByBranchAndHash(ctx context.Context, branchID, contentHash string) (Chatbot, error) {
+	for _, c := range r.t.filter(func(c Chatbot) bool { return c.BranchID == branchID && c.ContentHash == contentHash }) {
+		return c, nil
+	}
+	return Chatbot{}, ErrNotFound
+}
+
+func (r *memChatbotRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return r.t.update(id, fields)
+}
+
+func (r *memChatbotRepo) Delete(ctx context.Context, id string) error {
+	return r.t.remove(id)
+}
+
+type memVersionRepo struct{ t memTable[ChatbotVersion] }
+
+func (r *memVersionRepo) Create(ctx context.Context, v ChatbotVersion) (ChatbotVersion, error) {
+	v.ID = newID(v.ID)
+	v.CreatedAt = time.Now().UTC()
+	return r.t.insert(v), nil
+}
+
+func (r *memVersionRepo) Get(ctx context.Context, id string) (ChatbotVersion, error) {
+	return r.t.get(id)
+}
+
+func (r *memVersionRepo) FindByHash(ctx context.Context, chatbotID, contentHash string) (ChatbotVersion, error) {
+	for _, v := range r.t.filter(func(v ChatbotVersion) bool { return v.ChatbotID == chatbotID && v.ContentHash == contentHash }) {
+		return v, nil
+	}
+	return ChatbotVersion{}, ErrNotFound
+}
+
+func (r *memVersionRepo) List(ctx context.Context, chatbotID string) ([]ChatbotVersion, error) {
+	versions := r.t.filter(func(v ChatbotVersion) bool { return v.ChatbotID == chatbotID })
+	for i := range versions {
+		versions[i].Content = nil
+	}
+	return versions, nil
+}
+
+type memSnapshotRepo struct{ t memTable[MenuSnapshot] }
+
+func (r *memSnapshotRepo) Create(ctx context.Context, s MenuSnapshot) (MenuSnapshot, error) {
+	s.ID = newID(s.ID)
+	s.CreatedAt = time.Now().UTC()
+	if s.Status == "" {
+		s.Status = SnapshotPublished
+	}
+	return r.t.insert(s), nil
+}
+
+func (r *memSnapshotRepo) Get(ctx context.Context, id string) (MenuSnapshot, error) {
+	return r.t.get(id)
+}
+
+func (r *memSnapshotRepo) Latest(ctx context.Context, branchID string) (MenuSnapshot, error) {
+	snaps := r.t.filter(func(s MenuSnapshot) bool { return s.BranchID == branchID && s.Status == SnapshotPublished })
+	if len(snaps) == 0 {
+		return MenuSnapshot{}, ErrNotFound
+	}
+	sort.SliceStable(snaps, func(i, j int) bool { return snaps[i].CreatedAt.After(snaps[j].CreatedAt) })
+	return snaps[0], nil
+}
+
+func (r *memSnapshotRepo) Publish(ctx context.Context, id string) (MenuSnapshot, error) {
+	if _, err := r.t.get(id); err != nil {
+		return MenuSnapshot{}, err
+	}
+	if err := r.t.update(id, map[string]interface{}{"status": SnapshotPublished, "created_at": time.Now().UTC()}); err != nil {
+		return MenuSnapshot{}, err
+	}
+	return r.t.get(id)
+}
+
+func (r *memSnapshotRepo) List(ctx context.Context, branchID, status string, limit, offset int) ([]MenuSnapshot, int, error) {
+	snaps := r.t.filter(func(s MenuSnapshot) bool {
+		return s.BranchID == branchID && (status == "" || s.Status == status)
+	})
+	sort.SliceStable(snaps, func(i, j int) bool { return snaps[i].CreatedAt.After(snaps[j].CreatedAt) })
+	total := len(snaps)
+	snaps = snaps[min(offset, total):min(offset+limit, total)]
+	for i := range snaps {
+		snaps[i].Content = nil
+	}
+	return snaps, total, nil
+}
+
+type memChatHistoryRepo struct{ t memTable[ChatHistory] }
+
+func (r *memChatHistoryRepo) Append(ctx context.Context, h ChatHistory) error {
+	h.ID = newID(h.ID)
+	r.t.insert(h)
+	return nil
+}
+
+func (r *memChatHistoryRepo) Recent(ctx context.Context, sessionID string, limit int) ([]ChatHistory, error) {
+	history := r.t.filter(func(h ChatHistory) bool { return h.SessionID == sessionID })
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history, nil
+}
+
+type memJobRepo struct{ t memTable[IndexJob] }
+
+func (r *memJobRepo) Enqueue(ctx context.Context, job IndexJob) (IndexJob, error) {
+	now := time.Now().UTC()
+	job.ID = newID(job.ID)
+	job.Status = "queued"
+	job.RunAt, job.CreatedAt, job.UpdatedAt = now, now, now
+	return r.t.insert(job), nil
+}
+
+func (r *memJobRepo) Get(ctx context.Context, id string) (IndexJob, error) {
+	return r.t.get(id)
+}
+
+func (r *memJobRepo) Claim(ctx context.Context, owner string, lease time.Duration) (IndexJob, error) {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	now := time.Now().UTC()
+	busy := map[string]bool{}
+	for _, j := range r.t.rows {
+		if j.Status == "running" {
+			busy[j.ChatbotID] = true
+		}
+	}
+	best := -1
+	for i, j := range r.t.rows {
+		if j.Status == "queued" && !busy[j.ChatbotID] && !j.RunAt.After(now) && (best < 0 || j.RunAt.Before(r.t.rows[best].RunAt)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return IndexJob{}, ErrNotFound
+	}
+	expires := now.Add(lease)
+	j := &r.t.rows[best]
+	j.Status, j.Attempts, j.LeaseOwner, j.LeaseExpiresAt, j.UpdatedAt = "running", j.Attempts+1, owner, &expires, now
+	return *j, nil
+}
+
+// leased runs fn on a running job held by owner
+func (r *memJobRepo) leased(id, owner string, fn func(j *IndexJob)) bool {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	i := r.t.find(id)
+	if i < 0 || r.t.rows[i].Status != "running" || r.t.rows[i].LeaseOwner != owner {
+		return false
+	}
+	fn(&r.t.rows[i])
+	r.t.rows[i].UpdatedAt = time.Now().UTC()
+	return true
+}
+
+func (r *memJobRepo) ExtendLease(ctx context.Context, id, owner string, lease time.Duration) error {
+	if !r.leased(id, owner, func(j *IndexJob) {
+		expires := time.Now().Add(lease)
+		j.LeaseExpiresAt = &expires
+	}) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *memJobRepo) UpdateProgress(ctx context.Context, id string, progress JobProgress) error {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	if i := r.t.find(id); i >= 0 {
+		r.t.rows[i].Progress = progress
+	}
+	return nil
+}
+
+func (r *memJobRepo) Complete(ctx context.Context, id, owner string) error {
+	r.leased(id, owner, func(j *IndexJob) {
+		now := time.Now().UTC()
+		j.Status, j.LeaseOwner, j.LeaseExpiresAt, j.LastError, j.FinishedAt = "succeeded", "", nil, "", &now
+	})
+	return nil
+}
+
+func (r *memJobRepo) Fail(ctx context.Context, id, owner, errMsg string, retryAt *time.Time) error {
+	r.leased(id, owner, func(j *IndexJob) {
+		j.LastError, j.LeaseOwner, j.LeaseExpiresAt = errMsg, "", nil
+		if retryAt != nil {
+			j.Status, j.RunAt = "queued", *retryAt
+			return
+		}
+		now := time.Now().UTC()
+		j.Status, j.FinishedAt = "dead", &now
+	})
+	return nil
+}
+
+func (r *memJobRepo) RecoverExpired(ctx context.Context) (int, error) {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	now := time.Now().UTC()
+	n := 0
+	for i := range r.t.rows {
+		j := &r.t.rows[i]
+		if j.Status == "running" && j.LeaseExpiresAt != nil && j.LeaseExpiresAt.Before(now) {
+			j.Status, j.LeaseOwner, j.LeaseExpiresAt, j.RunAt = "queued", "", nil, now
+			n++
+		}
+	}
+	return n, nil
+}
+
+type memIndexBuildRepo struct{ t memTable[IndexBuild] }
+
+func (r *memIndexBuildRepo) Create(ctx context.Context, b IndexBuild) (IndexBuild, error) {
+	b.ID = newID(b.ID)
+	b.CreatedAt = time.Now().UTC()
+	if b.Status == "" {
+		b.Status = BuildBuilding
+	}
+	return r.t.insert(b), nil
+}
+
+func (r *memIndexBuildRepo) Get(ctx context.Context, id string) (IndexBuild, error) {
+	return r.t.get(id)
+}
+
+func (r *memIndexBuildRepo) List(ctx context.Context, chatbotID string) ([]IndexBuild, error) {
+	return r.t.filter(func(b IndexBuild) bool { return b.ChatbotID == chatbotID }), nil
+}
+
+func (r *memIndexBuildRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return r.t.update(id, fields)
+}
+
+func (r *memIndexBuildRepo) ListGarbage(ctx context.Context, retiredBefore time.Time) ([]IndexBuild, error) {
+	return r.t.filter(func(b IndexBuild) bool {
+		return b.Status == BuildFailed || (b.Status == BuildRetired && b.RetiredAt != nil && b.RetiredAt.Before(retiredBefore))
+	}), nil
+}
+
+type memGuestSessionRepo struct{ t memTable[GuestSession] }
+
+func (r *memGuestSessionRepo) Create(ctx context.Context, s GuestSession) (GuestSession, error) {
+	s.ID = newID(s.ID)
+	s.CreatedAt = time.Now().UTC()
+	return r.t.insert(s), nil
+}
+
+func (r *memGuestSessionRepo) Get(ctx context.Context, id string) (GuestSession, error) {
+	return r.t.get(id)
+}
+
+func (r *memGuestSessionRepo) ListByBranch(ctx context.Context, branchID string) ([]GuestSession, error) {
+	return r.t.filter(func(s GuestSession) bool { return s.BranchID == branchID }), nil
+}
+
+func (r *memGuestSessionRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return r.t.update(id, fields)
+}
+
+func (r *memGuestSessionRepo) Use(ctx context.Context, id string, now time.Time) (GuestSession, bool, error) {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	i := r.t.find(id)
+	if i < 0 {
+		return GuestSession{}, false, ErrNotFound
+	}
+	s := &r.t.rows[i]
+	if s.state(now) != SessionActive {
+		return *s, false, nil
+	}
+	s.Uses++
+	s.LastUsedAt = &now
+	return *s, true, nil
+}
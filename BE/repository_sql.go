@@ -0,0 +1,915 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DB is the database/sql handle used when DB_BACKEND=postgres
+var DB *sql.DB
+
+// initSQLRepositories opens a plain Postgres database that has database/schema.sql applied
+// (run database/local_bootstrap.sql first when the database is not a Supabase project)
+func initSQLRepositories(ctx context.Context, dsn string) error {
+	if dsn == "" {
+		return fmt.Errorf("DATABASE_URL is required for DB_BACKEND=postgres")
+	}
+	var err error
+	DB, err = sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := DB.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	log.Printf("Using Postgres repositories")
+
+	Accounts = sqlAccountRepo{db: DB}
+	Restaurants = sqlRestaurantRepo{db: DB}
+	Branches = sqlBranchRepo{db: DB}
+	Chatbots = sqlChatbotRepo{db: DB}
+	Versions = sqlVersionRepo{db: DB}
+	Snapshots = sqlSnapshotRepo{db: DB}
+	Histories = sqlChatHistoryRepo{db: DB}
+	Jobs = sqlJobRepo{db: DB}
+	Builds = sqlIndexBuildRepo{db: DB}
+	Sessions = sqlGuestSessionRepo{db: DB}
+	return nil
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+var sqlColumnName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// sqlUpdate runs UPDATE table SET ... WHERE id = $n for a column -> value map
+func sqlUpdate(ctx context.Context, db *sql.DB, table, id string, fields map[string]interface{}) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	cols := make([]string, 0, len(fields))
+	for col := range fields {
+		if !sqlColumnName.MatchString(col) {
+			return fmt.Errorf("invalid column name %q", col)
+		}
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	sets := make([]string, len(cols))
+	args := make([]interface{}, 0, len(cols)+1)
+	for i, col := range cols {
+		sets[i] = fmt.Sprintf("%s = $%d", col, i+1)
+		args = append(args, sqlValue(fields[col]))
+	}
+	args = append(args, id)
+
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE id = $%d", table, strings.Join(sets, ", "), len(args))
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to update %s: %w", table, err)
+	}
+	return nil
+}
+
+// sqlDelete runs DELETE FROM table WHERE id = $1, returning ErrNotFound when no row matched
+func sqlDelete(ctx context.Context, db *sql.DB, table, id string) error {
+	res, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = $1", table), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", table, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// sqlValue converts values the PostgREST repositories send as JSON into driver values
+func sqlValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []byte:
+		return string(t)
+	case time.Time:
+		return t.UTC()
+	}
+	return v
+}
+
+type sqlRestaurantRepo struct{ db *sql.DB }
+
+const restaurantColumns = `id, name, COALESCE(description, ''), owner_id, COALESCE(created_at, now())`
+
+func scanRestaurant(row rowScanner) (Restaurant, error) {
+	var r Restaurant
+	err := row.Scan(&r.ID, &r.Name, &r.Description, &r.OwnerID, &r.CreatedAt)
+	return r, err
+}
+
+func (s sqlRestaurantRepo) Create(ctx context.Context, r Restaurant) (Restaurant, error) {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO restaurants (id, name, description, owner_id) VALUES ($1, $2, $3, $4)
+		 RETURNING `+restaurantColumns,
+		r.ID, r.Name, r.Description, r.OwnerID)
+	created, err := scanRestaurant(row)
+	if err != nil {
+		return Restaurant{}, fmt.Errorf("failed to insert restaurant: %w", err)
+	}
+	return created, nil
+}
+
+func (s sqlRestaurantRepo) Get(ctx context.Context, id string) (Restaurant, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+restaurantColumns+` FROM restaurants WHERE id = $1`, id)
+	r, err := scanRestaurant(row)
+	if err != nil {
+		return Restaurant{}, notFound(err)
+	}
+	return r, nil
+}
+
+func (s sqlRestaurantRepo) ListByOwner(ctx context.Context, ownerID string) ([]Restaurant, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+restaurantColumns+` FROM restaurants WHERE owner_id = $1 ORDER BY created_at`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list restaurants: %w", err)
+	}
+	defer rows.Close()
+
+	restaurants := []Restaurant{}
+	for rows.Next() {
+		r, err := scanRestaurant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan restaurant: %w", err)
+		}
+		restaurants = append(restaurants, r)
+	}
+	return restaurants, rows.Err()
+}
+
+func (s sqlRestaurantRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return sqlUpdate(ctx, s.db, "restaurants", id, fields)
+}
+
+func (s sqlRestaurantRepo) Delete(ctx context.Context, id string) error {
+	return sqlDelete(ctx, s.db, "restaurants", id)
+}
+
+// sqlAccountRepo keeps owner accounts in auth.users (see local_bootstrap.sql) with bcrypt password
+// hashes and issues its own tokens
+type sqlAccountRepo struct{ db *sql.DB }
+
+func (s sqlAccountRepo) Register(ctx context.Context, email, password string) (AuthUser, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return AuthUser{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+	user := AuthUser{ID: uuid.New().String(), Role: "authenticated"}
+	err = s.db.QueryRowContext(ctx,
+		`INSERT INTO auth.users (id, email, password_hash) VALUES ($1, $2, $3)
+		 ON CONFLICT (email) DO NOTHING RETURNING email`,
+		user.ID, email, string(hash)).Scan(&user.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AuthUser{}, ErrAccountExists
+	}
+	if err != nil {
+		return AuthUser{}, fmt.Errorf("failed to insert account: %w", err)
+	}
+	return user, nil
+}
+
+func (s sqlAccountRepo) SignIn(ctx context.Context, email, password string) (AuthSession, error) {
+	user := AuthUser{Role: "authenticated"}
+	var hash string
+	err := s.db.QueryRowContext(ctx, `SELECT id, email, COALESCE(password_hash, '') FROM auth.users WHERE email = $1`, email).
+		Scan(&user.ID, &user.Email, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AuthSession{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return AuthSession{}, fmt.Errorf("failed to get account: %w", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return AuthSession{}, ErrInvalidCredentials
+	}
+	return issueToken(user, time.Now())
+}
+
+type sqlBranchRepo struct{ db *sql.DB }
+
+const branchColumns = `id, restaurant_id, name, COALESCE(address, ''), COALESCE(has_chatbot, false), COALESCE(timezone, 'UTC'),
+	COALESCE(profile, '{}'::jsonb), COALESCE(created_at, now())`
+
+func scanBranch(row rowScanner) (Branch, error) {
+	var b Branch
+	var profile []byte
+	err := row.Scan(&b.ID, &b.RestaurantID, &b.Name, &b.Address, &b.HasChatbot, &b.Timezone, &profile, &b.CreatedAt)
+	if err == nil {
+		if perr := json.Unmarshal(profile, &b.Profile); perr != nil {
+			log.Printf("Branch %s: unreadable profile: %v", b.ID, perr)
+		}
+	}
+	return b, err
+}
+
+func (s sqlBranchRepo) Create(ctx context.Context, b Branch) (Branch, error) {
+	if b.ID == "" {
+		b.ID = uuid.New().String()
+	}
+	profile, err := json.Marshal(b.Profile)
+	if err != nil {
+		return Branch{}, err
+	}
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO branches (id, restaurant_id, name, address, has_chatbot, timezone, profile) VALUES ($1, $2, $3, $4, false, COALESCE($5, 'UTC'), $6)
+		 RETURNING `+branchColumns,
+		b.ID, b.RestaurantID, b.Name, b.Address, nullIfEmpty(b.Timezone), string(profile))
+	created, err := scanBranch(row)
+	if err != nil {
+		return Branch{}, fmt.Errorf("failed to insert branch: %w", err)
+	}
+	return created, nil
+}
+
+func (s sqlBranchRepo) Get(ctx context.Context, id string) (Branch, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+branchColumns+` FROM branches WHERE id = $1`, id)
+	b, err := scanBranch(row)
+	if err != nil {
+		return Branch{}, notFound(err)
+	}
+	return b, nil
+}
+
+func (s sqlBranchRepo) List(ctx context.Context) ([]Branch, error) {
+	return s.query(ctx, `SELECT `+branchColumns+` FROM branches ORDER BY created_at`)
+}
+
+func (s sqlBranchRepo) ListByRestaurant(ctx context.Context, restaurantID string) ([]Branch, error) {
+	return s.query(ctx, `SELECT `+branchColumns+` FROM branches WHERE restaurant_id = $1 ORDER BY created_at`, restaurantID)
+}
+
+func (s sqlBranchRepo) query(ctx context.Context, query string, args ...interface{}) ([]Branch, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+	defer rows.Close()
+
+	branches := []Branch{}
+	for rows.Next() {
+		b, err := scanBranch(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan branch: %w", err)
+		}
+		branches = append(branches, b)
+	}
+	return branches, rows.Err()
+}
+
+func (s sqlBranchRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	if profile, ok := fields["profile"]; ok {
+		data, err := json.Marshal(profile)
+		if err != nil {
+			return err
+		}
+		copied := make(map[string]interface{}, len(fields))
+		for k, v := range fields {
+			copied[k] = v
+		}
+		copied["profile"] = string(data)
+		fields = copied
+	}
+	return sqlUpdate(ctx, s.db, "branches", id, fields)
+}
+
+func (s sqlBranchRepo) SetHasChatbot(ctx context.Context, id string, hasChatbot bool) error {
+	return sqlUpdate(ctx, s.db, "branches", id, map[string]interface{}{"has_chatbot": hasChatbot})
+}
+
+func (s sqlBranchRepo) Delete(ctx context.Context, id string) error {
+	return sqlDelete(ctx, s.db, "branches", id)
+}
+
+type sqlChatbotRepo struct{ db *sql.DB }
+
+const chatbotColumns = `id, branch_id, status, COALESCE(content_hash, ''), COALESCE(active_version_id::text, ''),
+	COALESCE(last_indexed_version_id::text, ''), COALESCE(active_namespace, ''), COALESCE(active_build_id::text, ''),
+	version, COALESCE(retrieval_settings, '{}'::jsonb), COALESCE(last_error, ''), last_error_at, COALESCE(created_at, now())`
+
+func scanChatbot(row rowScanner) (Chatbot, error) {
+	var c Chatbot
+	var settings []byte
+	var lastErrorAt sql.NullTime
+	err := row.Scan(&c.ID, &c.BranchID, &c.Status, &c.ContentHash, &c.ActiveVersionID,
+		&c.LastIndexedVersionID, &c.ActiveNamespace, &c.ActiveBuildID, &c.Version, &settings,
+		&c.LastError, &lastErrorAt, &c.CreatedAt)
+	if lastErrorAt.Valid {
+		c.LastErrorAt = &lastErrorAt.Time
+	}
+	if err == nil {
+		if serr := json.Unmarshal(settings, &c.Settings); serr != nil {
+			log.Printf("Chatbot %s: unreadable retrieval settings: %v", c.ID, serr)
+		}
+	}
+	return c, err
+}
+
+func (s sqlChatbotRepo) Create(ctx context.Context, c Chatbot) (Chatbot, error) {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO chatbots (id, branch_id, status, content_hash, version, active_version_id, last_indexed_version_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING `+chatbotColumns,
+		c.ID, c.BranchID, c.Status, c.ContentHash, c.Version,
+		nullIfEmpty(c.ActiveVersionID), nullIfEmpty(c.LastIndexedVersionID))
+	created, err := scanChatbot(row)
+	if err != nil {
+		return Chatbot{}, fmt.Errorf("failed to insert chatbot: %w", err)
+	}
+	return created, nil
+}
+
+func (s sqlChatbotRepo) Get(ctx context.Context, id string) (Chatbot, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+chatbotColumns+` FROM chatbots WHERE id = $1`, id)
+	c, err := scanChatbot(row)
+	if err != nil {
+		return Chatbot{}, notFound(err)
+	}
+	return c, nil
+}
+
+func (s sqlChatbotRepo) FindByBranchAndHash(ctx context.Context, branchID, contentHash string) (Chatbot, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+chatbotColumns+` FROM chatbots WHERE branch_id = $1 AND content_hash = $2`,
+		branchID, contentHash)
+	c, err := scanChatbot(row)
+	if err != nil {
+		return Chatbot{}, notFound(err)
+	}
+	return c, nil
+}
+
+func (s sqlChatbotRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	if settings, ok := fields["retrieval_settings"]; ok {
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		copied := make(map[string]interface{}, len(fields))
+		for k, v := range fields {
+			copied[k] = v
+		}
+		copied["retrieval_settings"] = string(data)
+		fields = copied
+	}
+	return sqlUpdate(ctx, s.db, "chatbots", id, fields)
+}
+
+func (s sqlChatbotRepo) Delete(ctx context.Context, id string) error {
+	return sqlDelete(ctx, s.db, "chatbots", id)
+}
+
+type sqlVersionRepo struct{ db *sql.DB }
+
+const versionColumns = `id, chatbot_id, content, content_hash, COALESCE(notes, ''), COALESCE(created_by, ''), COALESCE(created_at, now())`
+
+func scanVersion(row rowScanner) (ChatbotVersion, error) {
+	var v ChatbotVersion
+	var content []byte
+	err := row.Scan(&v.ID, &v.ChatbotID, &content, &v.ContentHash, &v.Notes, &v.CreatedBy, &v.CreatedAt)
+	v.Content = content
+	return v, err
+}
+
+func (s sqlVersionRepo) Create(ctx context.Context, v ChatbotVersion) (ChatbotVersion, error) {
+	if v.ID == "" {
+		v.ID = uuid.New().String()
+	}
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO chatbot_versions (id, chatbot_id, content, content_hash, notes, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING `+versionColumns,
+		v.ID, v.ChatbotID, string(v.Content), v.ContentHash, v.Notes, v.CreatedBy)
+	created, err := scanVersion(row)
+	if err != nil {
+		return ChatbotVersion{}, fmt.Errorf("failed to insert version: %w", err)
+	}
+	return created, nil
+}
+
+func (s sqlVersionRepo) Get(ctx context.Context, id string) (ChatbotVersion, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+versionColumns+` FROM chatbot_versions WHERE id = $1`, id)
+	v, err := scanVersion(row)
+	if err != nil {
+		return ChatbotVersion{}, notFound(err)
+	}
+	return v, nil
+}
+
+func (s sqlVersionRepo) FindByHash(ctx context.Context, chatbotID, contentHash string) (ChatbotVersion, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+versionColumns+` FROM chatbot_versions WHERE chatbot_id = $1 AND content_hash = $2`,
+		chatbotID, contentHash)
+	v, err := scanVersion(row)
+	if err != nil {
+		return ChatbotVersion{}, notFound(err)
+	}
+	return v, nil
+}
+
+func (s sqlVersionRepo) List(ctx context.Context, chatbotID string) ([]ChatbotVersion, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, chatbot_id, content_hash, COALESCE(notes, ''), COALESCE(created_by, ''), COALESCE(created_at, now())
+		 FROM chatbot_versions WHERE chatbot_id = $1 ORDER BY created_at DESC`, chatbotID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []ChatbotVersion{}
+	for rows.Next() {
+		var v ChatbotVersion
+		if err := rows.Scan(&v.ID, &v.ChatbotID, &v.ContentHash, &v.Notes, &v.CreatedBy, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+type sqlSnapshotRepo struct{ db *sql.DB }
+
+const snapshotColumns = `id, branch_id, content, content_hash, COALESCE(notes, ''), COALESCE(created_by, ''), COALESCE(created_at, now()), status, provenance`
+
+func scanSnapshot(row rowScanner) (MenuSnapshot, error) {
+	var s MenuSnapshot
+	var content, provenance []byte
+	err := row.Scan(&s.ID, &s.BranchID, &content, &s.ContentHash, &s.Notes, &s.CreatedBy, &s.CreatedAt, &s.Status, &provenance)
+	s.Content = content
+	if len(provenance) > 0 {
+		s.Provenance = provenance
+	}
+	return s, err
+}
+
+func (s sqlSnapshotRepo) Create(ctx context.Context, snap MenuSnapshot) (MenuSnapshot, error) {
+	if snap.ID == "" {
+		snap.ID = uuid.New().String()
+	}
+	if snap.Status == "" {
+		snap.Status = SnapshotPublished
+	}
+	var provenance interface{}
+	if len(snap.Provenance) > 0 {
+		provenance = string(snap.Provenance)
+	}
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO menu_snapshots (id, branch_id, content, content_hash, notes, created_by, status, provenance)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 RETURNING `+snapshotColumns,
+		snap.ID, snap.BranchID, string(snap.Content), snap.ContentHash, snap.Notes, snap.CreatedBy, snap.Status, provenance)
+	created, err := scanSnapshot(row)
+	if err != nil {
+		return MenuSnapshot{}, fmt.Errorf("failed to insert snapshot: %w", err)
+	}
+	return created, nil
+}
+
+func (s sqlSnapshotRepo) Latest(ctx context.Context, branchID string) (MenuSnapshot, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+snapshotColumns+` FROM menu_snapshots WHERE branch_id = $1 AND status = 'published' ORDER BY created_at DESC LIMIT 1`,
+		branchID)
+	snap, err := scanSnapshot(row)
+	if err != nil {
+		return MenuSnapshot{}, notFound(err)
+	}
+	return snap, nil
+}
+
+func (s sqlSnapshotRepo) Get(ctx context.Context, id string) (MenuSnapshot, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+snapshotColumns+` FROM menu_snapshots WHERE id = $1`, id)
+	snap, err := scanSnapshot(row)
+	if err != nil {
+		return MenuSnapshot{}, notFound(err)
+	}
+	return snap, nil
+}
+
+func (s sqlSnapshotRepo) Publish(ctx context.Context, id string) (MenuSnapshot, error) {
+	row := s.db.QueryRowContext(ctx,
+		`UPDATE menu_snapshots SET status = 'published', created_at = now() WHERE id = $1 RETURNING `+snapshotColumns, id)
+	snap, err := scanSnapshot(row)
+	if err != nil {
+		return MenuSnapshot{}, notFound(err)
+	}
+	return snap, nil
+}
+
+func (s sqlSnapshotRepo) List(ctx context.Context, branchID, status string, limit, offset int) ([]MenuSnapshot, int, error) {
+	var total int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT count(*) FROM menu_snapshots WHERE branch_id = $1 AND ($2 = '' OR status = $2)`,
+		branchID, status).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count snapshots: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, branch_id, content_hash, COALESCE(notes, ''), COALESCE(created_by, ''), COALESCE(created_at, now()), status
+		 FROM menu_snapshots WHERE branch_id = $1 AND ($2 = '' OR status = $2)
+		 ORDER BY created_at DESC LIMIT $3 OFFSET $4`,
+		branchID, status, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var out []MenuSnapshot
+	for rows.Next() {
+		var snap MenuSnapshot
+		if err := rows.Scan(&snap.ID, &snap.BranchID, &snap.ContentHash, &snap.Notes, &snap.CreatedBy, &snap.CreatedAt, &snap.Status); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan snapshot: %w", err)
+		}
+		out = append(out, snap)
+	}
+	return out, total, rows.Err()
+}
+
+type sqlChatHistoryRepo struct{ db *sql.DB }
+
+func (s sqlChatHistoryRepo) Append(ctx context.Context, h ChatHistory) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO chat_history (session_id, branch_id, query, response, language, timestamp) VALUES ($1, $2, $3, $4, $5, $6)`,
+		h.SessionID, nullIfEmpty(h.BranchID), h.Query, h.Response, h.Language, h.Timestamp.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store interaction: %w", err)
+	}
+	return nil
+}
+
+func (s sqlChatHistoryRepo) Recent(ctx context.Context, sessionID string, limit int) ([]ChatHistory, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, session_id, COALESCE(branch_id::text, ''), query, response, COALESCE(language, 'en'), timestamp FROM (
+		   SELECT * FROM chat_history WHERE session_id = $1 ORDER BY timestamp DESC LIMIT $2
+		 ) recent ORDER BY timestamp ASC`,
+		sessionID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve chat history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []ChatHistory{}
+	for rows.Next() {
+		var h ChatHistory
+		if err := rows.Scan(&h.ID, &h.SessionID, &h.BranchID, &h.Query, &h.Response, &h.Language, &h.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan chat history: %w", err)
+		}
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+type sqlJobRepo struct{ db *sql.DB }
+
+const jobColumns = `id, kind, chatbot_id, payload, status, attempts, max_attempts, run_at, COALESCE(lease_owner, ''),
+	lease_expires_at, COALESCE(last_error, ''), progress, created_at, updated_at, finished_at`
+
+func scanJob(row rowScanner) (IndexJob, error) {
+	var j IndexJob
+	var payload, progress []byte
+	var leaseExpiresAt, finishedAt sql.NullTime
+	err := row.Scan(&j.ID, &j.Kind, &j.ChatbotID, &payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt,
+		&j.LeaseOwner, &leaseExpiresAt, &j.LastError, &progress, &j.CreatedAt, &j.UpdatedAt, &finishedAt)
+	if err != nil {
+		return IndexJob{}, err
+	}
+	j.Payload = payload
+	if len(progress) > 0 {
+		if err := json.Unmarshal(progress, &j.Progress); err != nil {
+			return IndexJob{}, fmt.Errorf("invalid job progress: %w", err)
+		}
+	}
+	if leaseExpiresAt.Valid {
+		j.LeaseExpiresAt = &leaseExpiresAt.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return j, nil
+}
+
+func (s sqlJobRepo) Enqueue(ctx context.Context, job IndexJob) (IndexJob, error) {
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	payload := job.Payload
+	if len(payload) == 0 {
+		payload = json.RawMessage(`{}`)
+	}
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO index_jobs (id, kind, chatbot_id, payload, status, max_attempts, run_at)
+		 VALUES ($1, $2, $3, $4, 'queued', $5, now())
+		 RETURNING `+jobColumns,
+		job.ID, job.Kind, job.ChatbotID, string(payload), job.MaxAttempts)
+	created, err := scanJob(row)
+	if err != nil {
+		return IndexJob{}, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return created, nil
+}
+
+func (s sqlJobRepo) Get(ctx context.Context, id string) (IndexJob, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM index_jobs WHERE id = $1`, id)
+	j, err := scanJob(row)
+	if err != nil {
+		return IndexJob{}, notFound(err)
+	}
+	return j, nil
+}
+
+// Claim skips chatbots that already have a running job. Two workers can still pick queued jobs of
+// the same chatbot at once; idx_index_jobs_one_running rejects the second, which then looks again.
+func (s sqlJobRepo) Claim(ctx context.Context, owner string, lease time.Duration) (IndexJob, error) {
+	for attempt := 0; ; attempt++ {
+		row := s.db.QueryRowContext(ctx,
+			`UPDATE index_jobs SET status = 'running', attempts = attempts + 1, lease_owner = $1,
+			        lease_expires_at = now() + make_interval(secs => $2), updated_at = now()
+			 WHERE id = (
+			   SELECT id FROM index_jobs q
+			   WHERE status = 'queued' AND run_at <= now()
+			     AND NOT EXISTS (SELECT 1 FROM index_jobs r WHERE r.chatbot_id = q.chatbot_id AND r.status = 'running')
+			   ORDER BY run_at
+			   FOR UPDATE SKIP LOCKED
+			   LIMIT 1
+			 )
+			 RETURNING `+jobColumns,
+			owner, lease.Seconds())
+		j, err := scanJob(row)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			if attempt < 3 {
+				continue
+			}
+			return IndexJob{}, ErrNotFound
+		}
+		if err != nil {
+			return IndexJob{}, notFound(err)
+		}
+		return j, nil
+	}
+}
+
+func (s sqlJobRepo) ExtendLease(ctx context.Context, id, owner string, lease time.Duration) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE index_jobs SET lease_expires_at = now() + make_interval(secs => $3), updated_at = now()
+		 WHERE id = $1 AND status = 'running' AND lease_owner = $2`,
+		id, owner, lease.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to extend lease: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("lease on job %s lost", id)
+	}
+	return nil
+}
+
+func (s sqlJobRepo) UpdateProgress(ctx context.Context, id string, progress JobProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE index_jobs SET progress = $2, updated_at = now() WHERE id = $1`, id, string(data)); err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
+	}
+	return nil
+}
+
+func (s sqlJobRepo) Complete(ctx context.Context, id, owner string) error {
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE index_jobs SET status = 'succeeded', lease_owner = NULL, lease_expires_at = NULL, last_error = NULL,
+		        finished_at = now(), updated_at = now()
+		 WHERE id = $1 AND lease_owner = $2`, id, owner); err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+	return nil
+}
+
+func (s sqlJobRepo) Fail(ctx context.Context, id, owner, errMsg string, retryAt *time.Time) error {
+	var err error
+	if retryAt != nil {
+		_, err = s.db.ExecContext(ctx,
+			`UPDATE index_jobs SET status = 'queued', run_at = $3, last_error = $4, lease_owner = NULL,
+			        lease_expires_at = NULL, updated_at = now()
+			 WHERE id = $1 AND lease_owner = $2`, id, owner, retryAt.UTC(), errMsg)
+	} else {
+		_, err = s.db.ExecContext(ctx,
+			`UPDATE index_jobs SET status = 'dead', last_error = $3, lease_owner = NULL, lease_expires_at = NULL,
+			        finished_at = now(), updated_at = now()
+			 WHERE id = $1 AND lease_owner = $2`, id, owner, errMsg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record job failure: %w", err)
+	}
+	return nil
+}
+
+func (s sqlJobRepo) RecoverExpired(ctx context.Context) (int, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE index_jobs SET status = 'queued', lease_owner = NULL, lease_expires_at = NULL, run_at = now(), updated_at = now()
+		 WHERE status = 'running' AND lease_expires_at < now()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to recover jobs: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+type sqlIndexBuildRepo struct{ db *sql.DB }
+
+const buildColumns = `id, chatbot_id, COALESCE(job_id::text, ''), namespace, COALESCE(version_id::text, ''), content_hash,
+	vector_count, status, COALESCE(error, ''), created_at, activated_at, retired_at`
+
+func scanBuild(row rowScanner) (IndexBuild, error) {
+	var b IndexBuild
+	var activatedAt, retiredAt sql.NullTime
+	err := row.Scan(&b.ID, &b.ChatbotID, &b.JobID, &b.Namespace, &b.VersionID, &b.ContentHash,
+		&b.VectorCount, &b.Status, &b.Error, &b.CreatedAt, &activatedAt, &retiredAt)
+	if activatedAt.Valid {
+		b.ActivatedAt = &activatedAt.Time
+	}
+	if retiredAt.Valid {
+		b.RetiredAt = &retiredAt.Time
+	}
+	return b, err
+}
+
+func (s sqlIndexBuildRepo) Create(ctx context.Context, b IndexBuild) (IndexBuild, error) {
+	if b.ID == "" {
+		b.ID = uuid.New().String()
+	}
+	if b.Status == "" {
+		b.Status = BuildBuilding
+	}
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO index_builds (id, chatbot_id, job_id, namespace, version_id, content_hash, vector_count, status, activated_at, retired_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 RETURNING `+buildColumns,
+		b.ID, b.ChatbotID, nullIfEmpty(b.JobID), b.Namespace, nullIfEmpty(b.VersionID), b.ContentHash, b.VectorCount, b.Status,
+		b.ActivatedAt, b.RetiredAt)
+	created, err := scanBuild(row)
+	if err != nil {
+		return IndexBuild{}, fmt.Errorf("failed to insert index build: %w", err)
+	}
+	return created, nil
+}
+
+func (s sqlIndexBuildRepo) Get(ctx context.Context, id string) (IndexBuild, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+buildColumns+` FROM index_builds WHERE id = $1`, id)
+	b, err := scanBuild(row)
+	if err != nil {
+		return IndexBuild{}, notFound(err)
+	}
+	return b, nil
+}
+
+func (s sqlIndexBuildRepo) List(ctx context.Context, chatbotID string) ([]IndexBuild, error) {
+	return s.query(ctx, `SELECT `+buildColumns+` FROM index_builds WHERE chatbot_id = $1 ORDER BY created_at DESC`, chatbotID)
+}
+
+func (s sqlIndexBuildRepo) ListGarbage(ctx context.Context, retiredBefore time.Time) ([]IndexBuild, error) {
+	return s.query(ctx,
+		`SELECT `+buildColumns+` FROM index_builds
+		 WHERE status = 'failed' OR (status = 'retired' AND retired_at < $1)
+		 ORDER BY created_at`, retiredBefore.UTC())
+}
+
+func (s sqlIndexBuildRepo) query(ctx context.Context, query string, args ...interface{}) ([]IndexBuild, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list index builds: %w", err)
+	}
+	defer rows.Close()
+
+	builds := []IndexBuild{}
+	for rows.Next() {
+		b, err := scanBuild(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan index build: %w", err)
+		}
+		builds = append(builds, b)
+	}
+	return builds, rows.Err()
+}
+
+func (s sqlIndexBuildRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return sqlUpdate(ctx, s.db, "index_builds", id, fields)
+}
+
+type sqlGuestSessionRepo struct{ db *sql.DB }
+
+const sessionColumns = `id, branch_id, COALESCE(table_label, ''), expires_at, max_uses, uses, revoked_at,
+	COALESCE(rotated_to::text, ''), COALESCE(created_by::text, ''), created_at, last_used_at`
+
+func scanSession(row rowScanner) (GuestSession, error) {
+	var s GuestSession
+	var revokedAt, lastUsedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.BranchID, &s.Table, &s.ExpiresAt, &s.MaxUses, &s.Uses, &revokedAt,
+		&s.RotatedTo, &s.CreatedBy, &s.CreatedAt, &lastUsedAt)
+	if revokedAt.Valid {
+		s.RevokedAt = &revokedAt.Time
+	}
+	if lastUsedAt.Valid {
+		s.LastUsedAt = &lastUsedAt.Time
+	}
+	return s, err
+}
+
+func (r sqlGuestSessionRepo) Create(ctx context.Context, s GuestSession) (GuestSession, error) {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	row := r.db.QueryRowContext(ctx,
+		`INSERT INTO guest_sessions (id, branch_id, table_label, expires_at, max_uses, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING `+sessionColumns,
+		s.ID, s.BranchID, nullIfEmpty(s.Table), s.ExpiresAt.UTC(), s.MaxUses, nullIfEmpty(s.CreatedBy))
+	created, err := scanSession(row)
+	if err != nil {
+		return GuestSession{}, fmt.Errorf("failed to insert guest session: %w", err)
+	}
+	return created, nil
+}
+
+func (r sqlGuestSessionRepo) Get(ctx context.Context, id string) (GuestSession, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM guest_sessions WHERE id = $1`, id)
+	s, err := scanSession(row)
+	if err != nil {
+		return GuestSession{}, notFound(err)
+	}
+	return s, nil
+}
+
+func (r sqlGuestSessionRepo) ListByBranch(ctx context.Context, branchID string) ([]GuestSession, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+sessionColumns+` FROM guest_sessions WHERE branch_id = $1 ORDER BY created_at DESC`, branchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list guest sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []GuestSession{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan guest session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func (r sqlGuestSessionRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return sqlUpdate(ctx, r.db, "guest_sessions", id, fields)
+}
+
+// Use checks and counts in one statement, so concurrent guests cannot exceed max_uses
+func (r sqlGuestSessionRepo) Use(ctx context.Context, id string, now time.Time) (GuestSession, bool, error) {
+	row := r.db.QueryRowContext(ctx,
+		`UPDATE guest_sessions SET uses = uses + 1, last_used_at = $2
+		 WHERE id = $1 AND revoked_at IS NULL AND expires_at > $2 AND (max_uses = 0 OR uses < max_uses)
+		 RETURNING `+sessionColumns, id, now.UTC())
+	s, err := scanSession(row)
+	if err == nil {
+		return s, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return GuestSession{}, false, fmt.Errorf("failed to use guest session: %w", err)
+	}
+	s, err = r.Get(ctx, id)
+	return s, false, err
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sqlTestDB applies database/local_bootstrap.sql and database/schema.sql to a fresh schema of the
+// database in DATABASE_URL and returns a handle whose search_path points at it. The schema is dropped
+// when the test ends. Tests using it are skipped when DATABASE_URL is unset.
+func sqlTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("mindmenu_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	// lib/pq passes unknown connection parameters on as run-time settings
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema+",public")
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema + ",public"
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, file := range []string{"database/local_bootstrap.sql", "database/schema.sql"} {
+		script, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(script)); err != nil {
+			t.Fatalf("apply %s: %v", file, err)
+		}
+	}
+	return db
+}
+
+// sqlFixture is an owner with one restaurant, branch and chatbot
+type sqlFixture struct {
+	owner      AuthUser
+	restaurant Restaurant
+	branch     Branch
+	chatbot    Chatbot
+}
+
+func newSQLFixture(t *testing.T, db *sql.DB) sqlFixture {
+	t.Helper()
+	ctx := context.Background()
+	var f sqlFixture
+	var err error
+	email := fmt.Sprintf("owner-%d@example.com", time.Now().UnixNano())
+	if f.owner, err = (sqlAccountRepo{db: db}).Register(ctx, email, "password"); err != nil {
+		t.Fatal(err)
+	}
+	// auth.users is shared by every test schema, so remove the account again
+	t.Cleanup(func() { db.Exec(`DELETE FROM auth.users WHERE id = $1`, f.owner.ID) })
+
+	if f.restaurant, err = (sqlRestaurantRepo{db: db}).Create(ctx, Restaurant{Name: "Warung SQL", OwnerID: f.owner.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if f.branch, err = (sqlBranchRepo{db: db}).Create(ctx, Branch{RestaurantID: f.restaurant.ID, Name: "Main Street"}); err != nil {
+		t.Fatal(err)
+	}
+	if f.chatbot, err = (sqlChatbotRepo{db: db}).Create(ctx, Chatbot{BranchID: f.branch.ID, Status: "idle", ContentHash: "h1", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestSQLUpdateRejectsBadColumns(t *testing.T) {
+	db := sqlTestDB(t)
+	f := newSQLFixture(t, db)
+	ctx := context.Background()
+	restaurants := sqlRestaurantRepo{db: db}
+
+	for _, col := range []string{"name = 'x'; --", "Name", "1name", "name\"", ""} {
+		if err := restaurants.Update(ctx, f.restaurant.ID, map[string]interface{}{col: "x"}); err == nil {
+			t.Errorf("column %q accepted", col)
+		}
+	}
+	if err := restaurants.Update(ctx, f.restaurant.ID, map[string]interface{}{"name": "Renamed", "description": "Family run"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := restaurants.Get(ctx, f.restaurant.ID)
+	if err != nil || got.Name != "Renamed" || got.Description != "Family run" {
+		t.Errorf("after update: %+v, %v", got, err)
+	}
+	if err := restaurants.Update(ctx, f.restaurant.ID, nil); err != nil {
+		t.Errorf("empty update: %v", err)
+	}
+}
+
+func TestSQLRepositories(t *testing.T) {
+	db := sqlTestDB(t)
+	f := newSQLFixture(t, db)
+	ctx := context.Background()
+
+	t.Run("accounts", func(t *testing.T) {
+		accounts := sqlAccountRepo{db: db}
+		if _, err := accounts.Register(ctx, f.owner.Email, "again"); !errors.Is(err, ErrAccountExists) {
+			t.Errorf("duplicate register: %v", err)
+		}
+		if _, err := accounts.SignIn(ctx, f.owner.Email, "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("wrong password: %v", err)
+		}
+		session, err := accounts.SignIn(ctx, f.owner.Email, "password")
+		if err != nil || session.User.ID != f.owner.ID {
+			t.Errorf("sign in: %+v, %v", session, err)
+		}
+	})
+
+	t.Run("restaurants", func(t *testing.T) {
+		restaurants := sqlRestaurantRepo{db: db}
+		list, err := restaurants.ListByOwner(ctx, f.owner.ID)
+		if err != nil || len(list) != 1 || list[0].ID != f.restaurant.ID {
+			t.Errorf("ListByOwner = %+v, %v", list, err)
+		}
+		if _, err := restaurants.Get(ctx, "00000000-0000-0000-0000-000000000000"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("missing restaurant: %v", err)
+		}
+		if err := restaurants.Delete(ctx, "00000000-0000-0000-0000-000000000000"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleting a missing restaurant: %v", err)
+		}
+	})
+
+	t.Run("branches", func(t *testing.T) {
+		branches := sqlBranchRepo{db: db}
+		if f.branch.Timezone != "UTC" || f.branch.HasChatbot {
+			t.Errorf("created branch = %+v", f.branch)
+		}
+		profile := BranchProfile{Phone: "+62 21 555 0101"}
+		if err := branches.Update(ctx, f.branch.ID, map[string]interface{}{"profile": profile, "timezone": "Asia/Jakarta"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := branches.SetHasChatbot(ctx, f.branch.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		list, err := branches.ListByRestaurant(ctx, f.restaurant.ID)
+		if err != nil || len(list) != 1 {
+			t.Fatalf("ListByRestaurant = %+v, %v", list, err)
+		}
+		if b := list[0]; b.Profile.Phone != profile.Phone || b.Timezone != "Asia/Jakarta" || !b.HasChatbot {
+			t.Errorf("updated branch = %+v", b)
+		}
+	})
+
+	t.Run("chatbots and versions", func(t *testing.T) {
+		chatbots := sqlChatbotRepo{db: db}
+		versions := sqlVersionRepo{db: db}
+		v, err := versions.Create(ctx, ChatbotVersion{ChatbotID: f.chatbot.ID, Content: []byte(`{"sections": []}`), ContentHash: "h1", CreatedBy: f.owner.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := versions.Create(ctx, ChatbotVersion{ChatbotID: f.chatbot.ID, Content: []byte(`{}`), ContentHash: "h1"}); err == nil {
+			t.Error("duplicate content hash accepted")
+		}
+		if got, err := versions.FindByHash(ctx, f.chatbot.ID, "h1"); err != nil || got.ID != v.ID || got.CreatedBy != f.owner.ID {
+			t.Errorf("FindByHash = %+v, %v", got, err)
+		}
+		if list, err := versions.List(ctx, f.chatbot.ID); err != nil || len(list) != 1 || list[0].Content != nil {
+			t.Errorf("List = %+v, %v (content should be left out)", list, err)
+		}
+
+		settings := RetrievalSettings{TopK: 7}
+		if err := chatbots.Update(ctx, f.chatbot.ID, map[string]interface{}{"retrieval_settings": settings, "active_version_id": v.ID}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := chatbots.FindByBranchAndHash(ctx, f.branch.ID, "h1")
+		if err != nil || got.Settings.TopK != 7 || got.ActiveVersionID != v.ID {
+			t.Errorf("FindByBranchAndHash = %+v, %v", got, err)
+		}
+	})
+
+	t.Run("snapshots", func(t *testing.T) {
+		snapshots := sqlSnapshotRepo{db: db}
+		published, err := snapshots.Create(ctx, MenuSnapshot{BranchID: f.branch.ID, Content: []byte(`{"v": 1}`), ContentHash: "s1"})
+		if err != nil || published.Status != SnapshotPublished {
+			t.Fatalf("Create = %+v, %v", published, err)
+		}
+		draft, err := snapshots.Create(ctx, MenuSnapshot{BranchID: f.branch.ID, Content: []byte(`{"v": 2}`), ContentHash: "s2",
+			Status: SnapshotDraft, Provenance: []byte(`{"source": "photo"}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest, err := snapshots.Latest(ctx, f.branch.ID); err != nil || latest.ID != published.ID {
+			t.Errorf("Latest = %+v, %v; drafts must not count", latest, err)
+		}
+		if _, total, err := snapshots.List(ctx, f.branch.ID, SnapshotDraft, 10, 0); err != nil || total != 1 {
+			t.Errorf("draft count = %d, %v", total, err)
+		}
+		if _, err := snapshots.Publish(ctx, draft.ID); err != nil {
+			t.Fatal(err)
+		}
+		if latest, _ := snapshots.Latest(ctx, f.branch.ID); latest.ID != draft.ID || len(latest.Provenance) == 0 {
+			t.Errorf("Latest after publish = %+v", latest)
+		}
+		page, total, err := snapshots.List(ctx, f.branch.ID, "", 1, 1)
+		if err != nil || total != 2 || len(page) != 1 || page[0].ID != published.ID {
+			t.Errorf("second page = %+v (total %d), %v", page, total, err)
+		}
+	})
+
+	t.Run("builds", func(t *testing.T) {
+		builds := sqlIndexBuildRepo{db: db}
+		b, err := builds.Create(ctx, IndexBuild{ChatbotID: f.chatbot.ID, Namespace: "ns-1", ContentHash: "h1"})
+		if err != nil || b.Status != BuildBuilding {
+			t.Fatalf("Create = %+v, %v", b, err)
+		}
+		retired := time.Now().Add(-time.Hour)
+		if err := builds.Update(ctx, b.ID, map[string]interface{}{"status": BuildRetired, "retired_at": retired}); err != nil {
+			t.Fatal(err)
+		}
+		if garbage, err := builds.ListGarbage(ctx, time.Now()); err != nil || len(garbage) != 1 || garbage[0].ID != b.ID {
+			t.Errorf("ListGarbage = %+v, %v", garbage, err)
+		}
+		if garbage, _ := builds.ListGarbage(ctx, retired.Add(-time.Minute)); len(garbage) != 0 {
+			t.Errorf("build retired inside the retention period collected: %+v", garbage)
+		}
+	})
+
+	t.Run("history", func(t *testing.T) {
+		histories := sqlChatHistoryRepo{db: db}
+		start := time.Now()
+		for i := 0; i < 4; i++ {
+			err := histories.Append(ctx, ChatHistory{SessionID: "s1", BranchID: f.branch.ID, Query: fmt.Sprint("q", i),
+				Response: "a", Language: "en", Timestamp: start.Add(time.Duration(i) * time.Second)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		recent, err := histories.Recent(ctx, "s1", 2)
+		if err != nil || len(recent) != 2 || recent[0].Query != "q2" || recent[1].Query != "q3" {
+			t.Errorf("Recent = %+v, %v; want the last two, oldest first", recent, err)
+		}
+	})
+}
+
+func TestSQLJobClaimSkipsLockedRows(t *testing.T) {
+	db := sqlTestDB(t)
+	f := newSQLFixture(t, db)
+	ctx := context.Background()
+	jobs := sqlJobRepo{db: db}
+	other, err := (sqlChatbotRepo{db: db}).Create(ctx, Chatbot{BranchID: f.branch.ID, Status: "idle", ContentHash: "h2", Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chatbotID := range []string{f.chatbot.ID, other.ID} {
+		if _, err := jobs.Enqueue(ctx, IndexJob{Kind: jobKindIndex, ChatbotID: chatbotID, MaxAttempts: 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Many workers race for two jobs: each job is handed out exactly once
+	var mu sync.Mutex
+	claimed := map[string]string{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			job, err := jobs.Claim(ctx, owner, time.Minute)
+			if errors.Is(err, ErrNotFound) {
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if prev, ok := claimed[job.ID]; ok {
+				t.Errorf("job %s claimed by %s and %s", job.ID, prev, owner)
+			}
+			claimed[job.ID] = owner
+		}(fmt.Sprint("worker-", i))
+	}
+	wg.Wait()
+	if len(claimed) != 2 {
+		t.Fatalf("claimed %d jobs, want 2", len(claimed))
+	}
+
+	var id, owner string
+	for id, owner = range claimed {
+		break
+	}
+	if err := jobs.ExtendLease(ctx, id, "someone-else", time.Minute); err == nil {
+		t.Error("lease extended by a worker that does not hold it")
+	}
+	retryAt := time.Now().Add(-time.Second)
+	if err := jobs.Fail(ctx, id, owner, "boom", &retryAt); err != nil {
+		t.Fatal(err)
+	}
+	retried, err := jobs.Claim(ctx, "worker-retry", time.Minute)
+	if err != nil || retried.ID != id || retried.Attempts != 2 || retried.LastError != "boom" {
+		t.Errorf("retry claim = %+v, %v", retried, err)
+	}
+	if err := jobs.Complete(ctx, id, "worker-retry"); err != nil {
+		t.Fatal(err)
+	}
+	if done, _ := jobs.Get(ctx, id); done.Status != "succeeded" || done.FinishedAt == nil {
+		t.Errorf("completed job = %+v", done)
+	}
+}
+
+func TestSQLJobClaimOneRunningPerChatbot(t *testing.T) {
+	db := sqlTestDB(t)
+	f := newSQLFixture(t, db)
+	ctx := context.Background()
+	jobs := sqlJobRepo{db: db}
+	var queued []IndexJob
+	for i := 0; i < 2; i++ {
+		job, err := jobs.Enqueue(ctx, IndexJob{Kind: jobKindIndex, ChatbotID: f.chatbot.ID, MaxAttempts: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued = append(queued, job)
+	}
+
+	// Racing workers get one job of the chatbot; the other waits until it finishes
+	var mu sync.Mutex
+	var claimed []IndexJob
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			job, err := jobs.Claim(ctx, owner, time.Minute)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				t.Error(err)
+			}
+			if err == nil {
+				mu.Lock()
+				claimed = append(claimed, job)
+				mu.Unlock()
+			}
+		}(fmt.Sprint("worker-", i))
+	}
+	wg.Wait()
+	if len(claimed) != 1 || claimed[0].ID != queued[0].ID {
+		t.Fatalf("claimed %+v, want only the first job", claimed)
+	}
+	if err := jobs.Complete(ctx, claimed[0].ID, claimed[0].LeaseOwner); err != nil {
+		t.Fatal(err)
+	}
+	if next, err := jobs.Claim(ctx, "worker-next", time.Minute); err != nil || next.ID != queued[1].ID {
+		t.Errorf("claim after completion = %+v, %v; want the second job", next, err)
+	}
+}
+
+func TestSQLJobRecoverExpired(t *testing.T) {
+	db := sqlTestDB(t)
+	f := newSQLFixture(t, db)
+	ctx := context.Background()
+	jobs := sqlJobRepo{db: db}
+	job, err := jobs.Enqueue(ctx, IndexJob{Kind: jobKindIndex, ChatbotID: f.chatbot.ID, MaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobs.Claim(ctx, "crashed-worker", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := jobs.RecoverExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("RecoverExpired = %d, %v", n, err)
+	}
+	if got, _ := jobs.Get(ctx, job.ID); got.Status != "queued" || got.LeaseOwner != "" {
+		t.Errorf("recovered job = %+v", got)
+	}
+}
+
+func TestSQLGuestSessionUse(t *testing.T) {
+	db := sqlTestDB(t)
+	f := newSQLFixture(t, db)
+	ctx := context.Background()
+	sessions := sqlGuestSessionRepo{db: db}
+	now := time.Now()
+	create := func(s GuestSession) GuestSession {
+		t.Helper()
+		s.BranchID = f.branch.ID
+		s.CreatedBy = f.owner.ID
+		created, err := sessions.Create(ctx, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created
+	}
+
+	// Concurrent guests cannot get past max_uses
+	limited := create(GuestSession{ExpiresAt: now.Add(time.Hour), MaxUses: 3})
+	var mu sync.Mutex
+	used := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok, err := sessions.Use(ctx, limited.ID, time.Now())
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				mu.Lock()
+				used++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if got, _ := sessions.Get(ctx, limited.ID); used != 3 || got.Uses != 3 || got.state(time.Now()) != SessionExhausted {
+		t.Errorf("%d uses granted, session = %+v; want 3", used, got)
+	}
+
+	expired := create(GuestSession{ExpiresAt: now.Add(-time.Minute)})
+	revoked := create(GuestSession{ExpiresAt: now.Add(time.Hour)})
+	if err := sessions.Update(ctx, revoked.ID, map[string]interface{}{"revoked_at": now}); err != nil {
+		t.Fatal(err)
+	}
+	for name, id := range map[string]string{"expired": expired.ID, "revoked": revoked.ID} {
+		if s, ok, err := sessions.Use(ctx, id, now); ok || err != nil || s.Uses != 0 {
+			t.Errorf("%s: Use = %+v, %v, %v", name, s, ok, err)
+		}
+	}
+	if _, _, err := sessions.Use(ctx, "00000000-0000-0000-0000-000000000000", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing session: %v", err)
+	}
+}
+
+func TestSQLCascades(t *testing.T) {
+	db := sqlTestDB(t)
+	f := newSQLFixture(t, db)
+	ctx := context.Background()
+	version, err := (sqlVersionRepo{db: db}).Create(ctx, ChatbotVersion{ChatbotID: f.chatbot.ID, Content: []byte(`{}`), ContentHash: "h1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := (sqlJobRepo{db: db}).Enqueue(ctx, IndexJob{Kind: jobKindIndex, ChatbotID: f.chatbot.ID, MaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	build, err := (sqlIndexBuildRepo{db: db}).Create(ctx, IndexBuild{ChatbotID: f.chatbot.ID, Namespace: "ns", ContentHash: "h1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting a chatbot removes its versions, jobs and builds
+	if err := (sqlChatbotRepo{db: db}).Delete(ctx, f.chatbot.ID); err != nil {
+		t.Fatal(err)
+	}
+	gone := map[string]error{}
+	_, gone["version"] = (sqlVersionRepo{db: db}).Get(ctx, version.ID)
+	_, gone["job"] = (sqlJobRepo{db: db}).Get(ctx, job.ID)
+	_, gone["build"] = (sqlIndexBuildRepo{db: db}).Get(ctx, build.ID)
+	for name, err := range gone {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s after chatbot delete: %v", name, err)
+		}
+	}
+
+	// Deleting a restaurant removes its branches and everything that belongs to them
+	snapshot, err := (sqlSnapshotRepo{db: db}).Create(ctx, MenuSnapshot{BranchID: f.branch.ID, Content: []byte(`{}`), ContentHash: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := (sqlGuestSessionRepo{db: db}).Create(ctx, GuestSession{BranchID: f.branch.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (sqlChatHistoryRepo{db: db}).Append(ctx, ChatHistory{SessionID: session.ID, BranchID: f.branch.ID, Query: "q", Response: "a", Timestamp: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (sqlRestaurantRepo{db: db}).Delete(ctx, f.restaurant.ID); err != nil {
+		t.Fatal(err)
+	}
+	gone = map[string]error{}
+	_, gone["branch"] = (sqlBranchRepo{db: db}).Get(ctx, f.branch.ID)
+	_, gone["snapshot"] = (sqlSnapshotRepo{db: db}).Get(ctx, snapshot.ID)
+	_, gone["session"] = (sqlGuestSessionRepo{db: db}).Get(ctx, session.ID)
+	for name, err := range gone {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s after restaurant delete: %v", name, err)
+		}
+	}
+	if history, err := (sqlChatHistoryRepo{db: db}).Recent(ctx, session.ID, 10); err != nil || len(history) != 0 {
+		t.Errorf("history after restaurant delete = %+v, %v", history, err)
+	}
+}
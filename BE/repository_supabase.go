@@ -0,0 +1,1032 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/gotrue-go/types"
+	"github.com/supabase-community/postgrest-go"
+	"github.com/supabase-community/supabase-go"
+)
+
+// initSupabaseRepositories connects to Supabase and wires the PostgREST-backed repositories
+func initSupabaseRepositories(url, key string) error {
+	var err error
+	SupabaseClient, err = supabase.NewClient(url, key, nil)
+	if err != nil {
+		log.Printf("Warning: Supabase client initialization issue: %v", err)
+	}
+
+	Accounts = supabaseAccountRepo{}
+	Restaurants = supabaseRestaurantRepo{}
+	Branches = supabaseBranchRepo{}
+	Chatbots = supabaseChatbotRepo{}
+	Versions = supabaseVersionRepo{}
+	Snapshots = supabaseSnapshotRepo{}
+	Histories = supabaseChatHistoryRepo{}
+	Jobs = supabaseJobRepo{}
+	Builds = supabaseIndexBuildRepo{}
+	Sessions = supabaseGuestSessionRepo{}
+	return nil
+}
+
+// supabaseAccountRepo signs owners up and in with Supabase Auth; tokens are Supabase access tokens.
+// It calls the GoTrue client directly so the shared service-role client never takes on a user session.
+type supabaseAccountRepo struct{}
+
+func (supabaseAccountRepo) Register(ctx context.Context, email, password string) (AuthUser, error) {
+	resp, err := SupabaseClient.Auth.Signup(types.SignupRequest{Email: email, Password: password})
+	if err != nil {
+		if strings.Contains(err.Error(), "already registered") || strings.Contains(err.Error(), "status code 422") {
+			return AuthUser{}, ErrAccountExists
+		}
+		return AuthUser{}, fmt.Errorf("failed to sign up: %w", err)
+	}
+	// Projects with email confirmation return the user; with autoconfirm, a session
+	user := resp.User
+	if resp.Session.User.ID != uuid.Nil {
+		user = resp.Session.User
+	}
+	return AuthUser{ID: user.ID.String(), Email: user.Email, Role: user.Role}, nil
+}
+
+func (supabaseAccountRepo) SignIn(ctx context.Context, email, password string) (AuthSession, error) {
+	resp, err := SupabaseClient.Auth.SignInWithEmailPassword(email, password)
+	if err != nil {
+		if strings.Contains(err.Error(), "status code 400") {
+			return AuthSession{}, ErrInvalidCredentials
+		}
+		return AuthSession{}, fmt.Errorf("failed to sign in: %w", err)
+	}
+	return AuthSession{
+		Token:     resp.AccessToken,
+		TokenType: resp.TokenType,
+		ExpiresIn: resp.ExpiresIn,
+		User:      AuthUser{ID: resp.User.ID.String(), Email: resp.User.Email, Role: resp.User.Role},
+	}, nil
+}
+
+type supabaseRestaurantRepo struct{}
+
+func (supabaseRestaurantRepo) Create(ctx context.Context, r Restaurant) (Restaurant, error) {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	insertData := map[string]interface{}{
+		"id":          r.ID,
+		"name":        r.Name,
+		"description": r.Description,
+		"owner_id":    r.OwnerID,
+	}
+
+	var inserted []Restaurant
+	count, err := SupabaseClient.
+		From("restaurants").
+		Insert(insertData, false, "", "", "").
+		ExecuteTo(&inserted)
+	if err != nil {
+		return Restaurant{}, fmt.Errorf("failed to insert restaurant: %w", err)
+	}
+	if count == 0 || len(inserted) == 0 {
+		// The row may have been created but not returned due to RLS
+		log.Printf("No restaurant rows returned on insert - Count: %d", count)
+		return supabaseRestaurantRepo{}.Get(ctx, r.ID)
+	}
+	return inserted[0], nil
+}
+
+func (supabaseRestaurantRepo) Get(ctx context.Context, id string) (Restaurant, error) {
+	var rows []Restaurant
+	_, err := SupabaseClient.
+		From("restaurants").
+		Select("*", "", false).
+		Eq("id", id).
+		ExecuteTo(&rows)
+	if err != nil {
+		return Restaurant{}, fmt.Errorf("failed to get restaurant: %w", err)
+	}
+	if len(rows) == 0 {
+		return Restaurant{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (supabaseRestaurantRepo) ListByOwner(ctx context.Context, ownerID string) ([]Restaurant, error) {
+	var rows []Restaurant
+	_, err := SupabaseClient.
+		From("restaurants").
+		Select("*", "", false).
+		Eq("owner_id", ownerID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list restaurants: %w", err)
+	}
+	return rows, nil
+}
+
+func (supabaseRestaurantRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	var updated []Restaurant
+	_, err := SupabaseClient.
+		From("restaurants").
+		Update(fields, "", "").
+		Eq("id", id).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to update restaurant: %w", err)
+	}
+	return nil
+}
+
+func (supabaseRestaurantRepo) Delete(ctx context.Context, id string) error {
+	return supabaseDelete("restaurants", id)
+}
+
+type supabaseBranchRepo struct{}
+
+func (supabaseBranchRepo) Create(ctx context.Context, b Branch) (Branch, error) {
+	if b.ID == "" {
+		b.ID = uuid.New().String()
+	}
+	// DON'T send timestamps - let the database handle them
+	insertData := map[string]interface{}{
+		"id":            b.ID,
+		"restaurant_id": b.RestaurantID,
+		"name":          b.Name,
+		"address":       b.Address,
+		"has_chatbot":   false,
+	}
+	if b.Timezone != "" {
+		insertData["timezone"] = b.Timezone
+	}
+	insertData["profile"] = b.Profile
+
+	var inserted []Branch
+	count, err := SupabaseClient.
+		From("branches").
+		Insert(insertData, false, "", "", "").
+		ExecuteTo(&inserted)
+	if err != nil {
+		return Branch{}, fmt.Errorf("failed to insert branch: %w", err)
+	}
+	if count == 0 || len(inserted) == 0 {
+		// The row may have been created but not returned due to RLS
+		log.Printf("No branch rows returned on insert - Count: %d", count)
+		return supabaseBranchRepo{}.Get(ctx, b.ID)
+	}
+	return inserted[0], nil
+}
+
+func (supabaseBranchRepo) Get(ctx context.Context, id string) (Branch, error) {
+	var rows []Branch
+	_, err := SupabaseClient.
+		From("branches").
+		Select("*", "", false).
+		Eq("id", id).
+		ExecuteTo(&rows)
+	if err != nil {
+		return Branch{}, fmt.Errorf("failed to get branch: %w", err)
+	}
+	if len(rows) == 0 {
+		return Branch{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (supabaseBranchRepo) List(ctx context.Context) ([]Branch, error) {
+	var rows []Branch
+	_, err := SupabaseClient.
+		From("branches").
+		Select("*", "", false).
+		ExecuteTo(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+	return rows, nil
+}
+
+func (supabaseBranchRepo) ListByRestaurant(ctx context.Context, restaurantID string) ([]Branch, error) {
+	var rows []Branch
+	_, err := SupabaseClient.
+		From("branches").
+		Select("*", "", false).
+		Eq("restaurant_id", restaurantID).
+		ExecuteTo(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+	return rows, nil
+}
+
+func (supabaseBranchRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	var updated []Branch
+	_, err := SupabaseClient.
+		From("branches").
+		Update(fields, "", "").
+		Eq("id", id).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to update branch: %w", err)
+	}
+	return nil
+}
+
+func (supabaseBranchRepo) SetHasChatbot(ctx context.Context, id string, hasChatbot bool) error {
+	var updated []Branch
+	_, err := SupabaseClient.
+		From("branches").
+		Update(map[string]interface{}{"has_chatbot": hasChatbot}, "", "").
+		Eq("id", id).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to update branch: %w", err)
+	}
+	return nil
+}
+
+func (supabaseBranchRepo) Delete(ctx context.Context, id string) error {
+	return supabaseDelete("branches", id)
+}
+
+type supabaseChatbotRepo struct{}
+
+func (supabaseChatbotRepo) Create(ctx context.Context, c Chatbot) (Chatbot, error) {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	insertData := map[string]interface{}{
+		"id":                      c.ID,
+		"branch_id":               c.BranchID,
+		"status":                  c.Status,
+		"content_hash":            c.ContentHash,
+		"version":                 c.Version,
+		"active_version_id":       nullIfEmpty(c.ActiveVersionID),
+		"last_indexed_version_id": nullIfEmpty(c.LastIndexedVersionID),
+	}
+
+	var inserted []Chatbot
+	_, err := SupabaseClient.
+		From("chatbots").
+		Insert(insertData, false, "", "", "").
+		ExecuteTo(&inserted)
+	if err != nil {
+		return Chatbot{}, fmt.Errorf("failed to insert chatbot: %w", err)
+	}
+	if len(inserted) == 0 {
+		return Chatbot{}, fmt.Errorf("no chatbot rows inserted")
+	}
+	return inserted[0], nil
+}
+
+func (supabaseChatbotRepo) Get(ctx context.Context, id string) (Chatbot, error) {
+	var rows []Chatbot
+	_, err := SupabaseClient.
+		From("chatbots").
+		Select("*", "", false).
+		Eq("id", id).
+		ExecuteTo(&rows)
+	if err != nil {
+		return Chatbot{}, fmt.Errorf("failed to get chatbot: %w", err)
+	}
+	if len(rows) == 0 {
+		return Chatbot{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (supabaseChatbotRepo) FindByBranchAndHash(ctx context.Context, branchID, contentHash string) (Chatbot, error) {
+	var rows []Chatbot
+	_, err := SupabaseClient.
+		From("chatbots").
+		Select("*", "", false).
+		Eq("branch_id", branchID).
+		Eq("content_hash", contentHash).
+		ExecuteTo(&rows)
+	if err != nil {
+		return Chatbot{}, fmt.Errorf("failed to find chatbot: %w", err)
+	}
+	if len(rows) == 0 {
+		return Chatbot{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (supabaseChatbotRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	var updated []Chatbot
+	_, err := SupabaseClient.
+		From("chatbots").
+		Update(fields, "", "").
+		Eq("id", id).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to update chatbot: %w", err)
+	}
+	return nil
+}
+
+func (supabaseChatbotRepo) Delete(ctx context.Context, id string) error {
+	return supabaseDelete("chatbots", id)
+}
+
+// supabaseDelete deletes a row by id, returning ErrNotFound when none was deleted
+func supabaseDelete(table, id string) error {
+	var deleted []struct {
+		ID string `json:"id"`
+	}
+	_, err := SupabaseClient.
+		From(table).
+		Delete("", "").
+		Eq("id", id).
+		ExecuteTo(&deleted)
+	if err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", table, err)
+	}
+	if len(deleted) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type supabaseVersionRepo struct{}
+
+func (supabaseVersionRepo) Create(ctx context.Context, v ChatbotVersion) (ChatbotVersion, error) {
+	if v.ID == "" {
+		v.ID = uuid.New().String()
+	}
+	insertData := map[string]interface{}{
+		"id":           v.ID,
+		"chatbot_id":   v.ChatbotID,
+		"content":      v.Content,
+		"content_hash": v.ContentHash,
+		"notes":        v.Notes,
+		"created_by":   v.CreatedBy,
+	}
+
+	var inserted []ChatbotVersion
+	_, err := SupabaseClient.
+		From("chatbot_versions").
+		Insert(insertData, false, "", "", "").
+		ExecuteTo(&inserted)
+	if err != nil {
+		return ChatbotVersion{}, fmt.Errorf("failed to insert version: %w", err)
+	}
+	if len(inserted) == 0 {
+		return ChatbotVersion{}, fmt.Errorf("no version rows inserted")
+	}
+	return inserted[0], nil
+}
+
+func (supabaseVersionRepo) Get(ctx context.Context, id string) (ChatbotVersion, error) {
+	return supabaseFindVersion(map[string]string{"id": id})
+}
+
+func (supabaseVersionRepo) FindByHash(ctx context.Context, chatbotID, contentHash string) (ChatbotVersion, error) {
+	return supabaseFindVersion(map[string]string{"chatbot_id": chatbotID, "content_hash": contentHash})
+}
+
+func supabaseFindVersion(filters map[string]string) (ChatbotVersion, error) {
+	var rows []ChatbotVersion
+	query := SupabaseClient.
+		From("chatbot_versions").
+		Select("*", "", false)
+	for col, val := range filters {
+		query = query.Eq(col, val)
+	}
+	if _, err := query.ExecuteTo(&rows); err != nil {
+		return ChatbotVersion{}, fmt.Errorf("failed to fetch version: %w", err)
+	}
+	if len(rows) == 0 {
+		return ChatbotVersion{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (supabaseVersionRepo) List(ctx context.Context, chatbotID string) ([]ChatbotVersion, error) {
+	var rows []ChatbotVersion
+	_, err := SupabaseClient.
+		From("chatbot_versions").
+		Select("id,chatbot_id,content_hash,notes,created_by,created_at", "", false).
+		Eq("chatbot_id", chatbotID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	return rows, nil
+}
+
+type supabaseSnapshotRepo struct{}
+
+func (supabaseSnapshotRepo) Create(ctx context.Context, s MenuSnapshot) (MenuSnapshot, error) {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	if s.Status == "" {
+		s.Status = SnapshotPublished
+	}
+	insertData := map[string]interface{}{
+		"id":           s.ID,
+		"branch_id":    s.BranchID,
+		"content":      s.Content,
+		"content_hash": s.ContentHash,
+		"notes":        s.Notes,
+		"created_by":   s.CreatedBy,
+		"status":       s.Status,
+	}
+	if len(s.Provenance) > 0 {
+		insertData["provenance"] = s.Provenance
+	}
+
+	var inserted []MenuSnapshot
+	_, err := SupabaseClient.
+		From("menu_snapshots").
+		Insert(insertData, false, "", "", "").
+		ExecuteTo(&inserted)
+	if err != nil {
+		return MenuSnapshot{}, fmt.Errorf("failed to insert snapshot: %w", err)
+	}
+	if len(inserted) == 0 {
+		return MenuSnapshot{}, fmt.Errorf("no snapshot rows inserted")
+	}
+	return inserted[0], nil
+}
+
+func (supabaseSnapshotRepo) Get(ctx context.Context, id string) (MenuSnapshot, error) {
+	var rows []MenuSnapshot
+	_, err := SupabaseClient.
+		From("menu_snapshots").
+		Select("*", "", false).
+		Eq("id", id).
+		ExecuteTo(&rows)
+	if err != nil {
+		return MenuSnapshot{}, fmt.Errorf("failed to fetch snapshot: %w", err)
+	}
+	if len(rows) == 0 {
+		return MenuSnapshot{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (supabaseSnapshotRepo) Publish(ctx context.Context, id string) (MenuSnapshot, error) {
+	var rows []MenuSnapshot
+	_, err := SupabaseClient.
+		From("menu_snapshots").
+		Update(map[string]interface{}{
+			"status":     SnapshotPublished,
+			"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+		}, "", "").
+		Eq("id", id).
+		ExecuteTo(&rows)
+	if err != nil {
+		return MenuSnapshot{}, fmt.Errorf("failed to publish snapshot: %w", err)
+	}
+	if len(rows) == 0 {
+		return MenuSnapshot{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (supabaseSnapshotRepo) Latest(ctx context.Context, branchID string) (MenuSnapshot, error) {
+	var rows []MenuSnapshot
+	_, err := SupabaseClient.
+		From("menu_snapshots").
+		Select("*", "", false).
+		Eq("branch_id", branchID).
+		Eq("status", SnapshotPublished).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(1, "").
+		ExecuteTo(&rows)
+	if err != nil {
+		return MenuSnapshot{}, fmt.Errorf("failed to fetch snapshots: %w", err)
+	}
+	if len(rows) == 0 {
+		return MenuSnapshot{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+// snapshotSummaryColumns leaves out content and provenance, which can be large
+const snapshotSummaryColumns = "id,branch_id,content_hash,notes,created_by,created_at,status"
+
+func (supabaseSnapshotRepo) List(ctx context.Context, branchID, status string, limit, offset int) ([]MenuSnapshot, int, error) {
+	var rows []MenuSnapshot
+	query := SupabaseClient.
+		From("menu_snapshots").
+		Select(snapshotSummaryColumns, "exact", false).
+		Eq("branch_id", branchID)
+	if status != "" {
+		query = query.Eq("status", status)
+	}
+	count, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Range(offset, offset+limit-1, "").
+		ExecuteTo(&rows)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	return rows, int(count), nil
+}
+
+type supabaseChatHistoryRepo struct{}
+
+func (supabaseChatHistoryRepo) Append(ctx context.Context, h ChatHistory) error {
+	data := map[string]interface{}{
+		"session_id": h.SessionID,
+		"branch_id":  nullIfEmpty(h.BranchID),
+		"query":      h.Query,
+		"response":   h.Response,
+		"language":   h.Language,
+		"timestamp":  h.Timestamp.UTC().Format(time.RFC3339),
+	}
+
+	var result []ChatHistory
+	_, err := SupabaseClient.
+		From("chat_history").
+		Insert(data, false, "", "", "").
+		ExecuteTo(&result)
+	if err != nil {
+		return fmt.Errorf("failed to store interaction: %w", err)
+	}
+	return nil
+}
+
+func (supabaseChatHistoryRepo) Recent(ctx context.Context, sessionID string, limit int) ([]ChatHistory, error) {
+	var history []ChatHistory
+	_, err := SupabaseClient.
+		From("chat_history").
+		Select("*", "", false).
+		Eq("session_id", sessionID).
+		Order("timestamp", nil).
+		Limit(limit, "").
+		ExecuteTo(&history)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve chat history: %w", err)
+	}
+
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history, nil
+}
+
+// nullIfEmpty maps "" to nil so optional UUID columns are stored as NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+type supabaseJobRepo struct{}
+
+func (supabaseJobRepo) Enqueue(ctx context.Context, job IndexJob) (IndexJob, error) {
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	insertData := map[string]interface{}{
+		"id":           job.ID,
+		"kind":         job.Kind,
+		"chatbot_id":   job.ChatbotID,
+		"payload":      job.Payload,
+		"status":       "queued",
+		"max_attempts": job.MaxAttempts,
+		"run_at":       time.Now().UTC().Format(time.RFC3339Nano),
+		"progress":     JobProgress{},
+	}
+
+	var inserted []IndexJob
+	_, err := SupabaseClient.
+		From("index_jobs").
+		Insert(insertData, false, "", "", "").
+		ExecuteTo(&inserted)
+	if err != nil {
+		return IndexJob{}, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	if len(inserted) == 0 {
+		return IndexJob{}, fmt.Errorf("no job rows inserted")
+	}
+	return inserted[0], nil
+}
+
+func (supabaseJobRepo) Get(ctx context.Context, id string) (IndexJob, error) {
+	var rows []IndexJob
+	_, err := SupabaseClient.
+		From("index_jobs").
+		Select("*", "", false).
+		Eq("id", id).
+		ExecuteTo(&rows)
+	if err != nil {
+		return IndexJob{}, fmt.Errorf("failed to get job: %w", err)
+	}
+	if len(rows) == 0 {
+		return IndexJob{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+// Claim picks runnable candidates and leases the first one whose conditional update wins.
+// PostgREST has no SELECT ... FOR UPDATE, so the status/attempts match acts as a compare-and-swap.
+// Chatbots with a running job are skipped; idx_index_jobs_one_running rejects a claim that races
+// another worker's claim for the same chatbot.
+func (supabaseJobRepo) Claim(ctx context.Context, owner string, lease time.Duration) (IndexJob, error) {
+	now := time.Now().UTC()
+	var running []IndexJob
+	_, err := SupabaseClient.
+		From("index_jobs").
+		Select("chatbot_id", "", false).
+		Eq("status", "running").
+		ExecuteTo(&running)
+	if err != nil {
+		return IndexJob{}, fmt.Errorf("failed to list running jobs: %w", err)
+	}
+	busy := make(map[string]bool, len(running))
+	for _, job := range running {
+		busy[job.ChatbotID] = true
+	}
+
+	var candidates []IndexJob
+	_, err = SupabaseClient.
+		From("index_jobs").
+		Select("*", "", false).
+		Eq("status", "queued").
+		Lte("run_at", now.Format(time.RFC3339Nano)).
+		Order("run_at", &postgrest.OrderOpts{Ascending: true}).
+		Limit(10, "").
+		ExecuteTo(&candidates)
+	if err != nil {
+		return IndexJob{}, fmt.Errorf("failed to list runnable jobs: %w", err)
+	}
+
+	for _, job := range candidates {
+		if busy[job.ChatbotID] {
+			continue
+		}
+		// Later jobs of this chatbot wait for this one whether or not the claim wins
+		busy[job.ChatbotID] = true
+		update := map[string]interface{}{
+			"status":           "running",
+			"attempts":         job.Attempts + 1,
+			"lease_owner":      owner,
+			"lease_expires_at": now.Add(lease).Format(time.RFC3339Nano),
+			"updated_at":       now.Format(time.RFC3339Nano),
+		}
+		var claimed []IndexJob
+		_, err := SupabaseClient.
+			From("index_jobs").
+			Update(update, "", "").
+			Eq("id", job.ID).
+			Eq("status", "queued").
+			Eq("attempts", strconv.Itoa(job.Attempts)).
+			ExecuteTo(&claimed)
+		if err != nil && strings.Contains(err.Error(), "(23505)") {
+			continue // another worker just started a job for this chatbot
+		}
+		if err != nil {
+			return IndexJob{}, fmt.Errorf("failed to claim job: %w", err)
+		}
+		if len(claimed) > 0 {
+			return claimed[0], nil
+		}
+	}
+	return IndexJob{}, ErrNotFound
+}
+
+func (supabaseJobRepo) ExtendLease(ctx context.Context, id, owner string, lease time.Duration) error {
+	now := time.Now().UTC()
+	var updated []IndexJob
+	_, err := SupabaseClient.
+		From("index_jobs").
+		Update(map[string]interface{}{
+			"lease_expires_at": now.Add(lease).Format(time.RFC3339Nano),
+			"updated_at":       now.Format(time.RFC3339Nano),
+		}, "", "").
+		Eq("id", id).
+		Eq("status", "running").
+		Eq("lease_owner", owner).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to extend lease: %w", err)
+	}
+	if len(updated) == 0 {
+		return fmt.Errorf("lease on job %s lost", id)
+	}
+	return nil
+}
+
+func (supabaseJobRepo) UpdateProgress(ctx context.Context, id string, progress JobProgress) error {
+	var updated []IndexJob
+	_, err := SupabaseClient.
+		From("index_jobs").
+		Update(map[string]interface{}{
+			"progress":   progress,
+			"updated_at": time.Now().UTC().Format(time.RFC3339Nano),
+		}, "", "").
+		Eq("id", id).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
+	}
+	return nil
+}
+
+func (supabaseJobRepo) Complete(ctx context.Context, id, owner string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	var updated []IndexJob
+	_, err := SupabaseClient.
+		From("index_jobs").
+		Update(map[string]interface{}{
+			"status":           "succeeded",
+			"lease_owner":      nil,
+			"lease_expires_at": nil,
+			"last_error":       nil,
+			"finished_at":      now,
+			"updated_at":       now,
+		}, "", "").
+		Eq("id", id).
+		Eq("lease_owner", owner).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+	return nil
+}
+
+func (supabaseJobRepo) Fail(ctx context.Context, id, owner, errMsg string, retryAt *time.Time) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	update := map[string]interface{}{
+		"lease_owner":      nil,
+		"lease_expires_at": nil,
+		"last_error":       errMsg,
+		"updated_at":       now,
+	}
+	if retryAt != nil {
+		update["status"] = "queued"
+		update["run_at"] = retryAt.UTC().Format(time.RFC3339Nano)
+	} else {
+		update["status"] = "dead"
+		update["finished_at"] = now
+	}
+
+	var updated []IndexJob
+	_, err := SupabaseClient.
+		From("index_jobs").
+		Update(update, "", "").
+		Eq("id", id).
+		Eq("lease_owner", owner).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to record job failure: %w", err)
+	}
+	return nil
+}
+
+func (supabaseJobRepo) RecoverExpired(ctx context.Context) (int, error) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	var recovered []IndexJob
+	_, err := SupabaseClient.
+		From("index_jobs").
+		Update(map[string]interface{}{
+			"status":           "queued",
+			"lease_owner":      nil,
+			"lease_expires_at": nil,
+			"run_at":           now,
+			"updated_at":       now,
+		}, "", "").
+		Eq("status", "running").
+		Lt("lease_expires_at", now).
+		ExecuteTo(&recovered)
+	if err != nil {
+		return 0, fmt.Errorf("failed to recover jobs: %w", err)
+	}
+	return len(recovered), nil
+}
+
+type supabaseIndexBuildRepo struct{}
+
+func (supabaseIndexBuildRepo) Create(ctx context.Context, b IndexBuild) (IndexBuild, error) {
+	if b.ID == "" {
+		b.ID = uuid.New().String()
+	}
+	if b.Status == "" {
+		b.Status = BuildBuilding
+	}
+	insertData := map[string]interface{}{
+		"id":           b.ID,
+		"chatbot_id":   b.ChatbotID,
+		"job_id":       nullIfEmpty(b.JobID),
+		"namespace":    b.Namespace,
+		"version_id":   nullIfEmpty(b.VersionID),
+		"content_hash": b.ContentHash,
+		"vector_count": b.VectorCount,
+		"status":       b.Status,
+		"activated_at": b.ActivatedAt,
+		"retired_at":   b.RetiredAt,
+	}
+
+	var inserted []IndexBuild
+	_, err := SupabaseClient.
+		From("index_builds").
+		Insert(insertData, false, "", "", "").
+		ExecuteTo(&inserted)
+	if err != nil {
+		return IndexBuild{}, fmt.Errorf("failed to insert index build: %w", err)
+	}
+	if len(inserted) == 0 {
+		return IndexBuild{}, fmt.Errorf("no index build rows inserted")
+	}
+	return inserted[0], nil
+}
+
+func (supabaseIndexBuildRepo) Get(ctx context.Context, id string) (IndexBuild, error) {
+	var rows []IndexBuild
+	_, err := SupabaseClient.
+		From("index_builds").
+		Select("*", "", false).
+		Eq("id", id).
+		ExecuteTo(&rows)
+	if err != nil {
+		return IndexBuild{}, fmt.Errorf("failed to get index build: %w", err)
+	}
+	if len(rows) == 0 {
+		return IndexBuild{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (supabaseIndexBuildRepo) List(ctx context.Context, chatbotID string) ([]IndexBuild, error) {
+	var rows []IndexBuild
+	_, err := SupabaseClient.
+		From("index_builds").
+		Select("*", "", false).
+		Eq("chatbot_id", chatbotID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list index builds: %w", err)
+	}
+	return rows, nil
+}
+
+func (supabaseIndexBuildRepo) ListGarbage(ctx context.Context, retiredBefore time.Time) ([]IndexBuild, error) {
+	var failed, retired []IndexBuild
+	_, err := SupabaseClient.
+		From("index_builds").
+		Select("*", "", false).
+		Eq("status", BuildFailed).
+		ExecuteTo(&failed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list failed index builds: %w", err)
+	}
+	_, err = SupabaseClient.
+		From("index_builds").
+		Select("*", "", false).
+		Eq("status", BuildRetired).
+		Lt("retired_at", retiredBefore.UTC().Format(time.RFC3339Nano)).
+		ExecuteTo(&retired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list retired index builds: %w", err)
+	}
+	return append(failed, retired...), nil
+}
+
+func (supabaseIndexBuildRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	var updated []IndexBuild
+	_, err := SupabaseClient.
+		From("index_builds").
+		Update(fields, "", "").
+		Eq("id", id).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to update index build: %w", err)
+	}
+	return nil
+}
+
+type supabaseGuestSessionRepo struct{}
+
+func (supabaseGuestSessionRepo) Create(ctx context.Context, s GuestSession) (GuestSession, error) {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	insertData := map[string]interface{}{
+		"id":          s.ID,
+		"branch_id":   s.BranchID,
+		"table_label": nullIfEmpty(s.Table),
+		"expires_at":  s.ExpiresAt.UTC().Format(time.RFC3339Nano),
+		"max_uses":    s.MaxUses,
+		"created_by":  nullIfEmpty(s.CreatedBy),
+	}
+
+	var inserted []supabaseGuestSession
+	_, err := SupabaseClient.
+		From("guest_sessions").
+		Insert(insertData, false, "", "", "").
+		ExecuteTo(&inserted)
+	if err != nil {
+		return GuestSession{}, fmt.Errorf("failed to insert guest session: %w", err)
+	}
+	if len(inserted) == 0 {
+		return GuestSession{}, fmt.Errorf("no guest session rows inserted")
+	}
+	return inserted[0].session(), nil
+}
+
+func (supabaseGuestSessionRepo) Get(ctx context.Context, id string) (GuestSession, error) {
+	var rows []supabaseGuestSession
+	_, err := SupabaseClient.
+		From("guest_sessions").
+		Select("*", "", false).
+		Eq("id", id).
+		ExecuteTo(&rows)
+	if err != nil {
+		return GuestSession{}, fmt.Errorf("failed to get guest session: %w", err)
+	}
+	if len(rows) == 0 {
+		return GuestSession{}, ErrNotFound
+	}
+	return rows[0].session(), nil
+}
+
+func (supabaseGuestSessionRepo) ListByBranch(ctx context.Context, branchID string) ([]GuestSession, error) {
+	var rows []supabaseGuestSession
+	_, err := SupabaseClient.
+		From("guest_sessions").
+		Select("*", "", false).
+		Eq("branch_id", branchID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list guest sessions: %w", err)
+	}
+	sessions := make([]GuestSession, len(rows))
+	for i, row := range rows {
+		sessions[i] = row.session()
+	}
+	return sessions, nil
+}
+
+func (supabaseGuestSessionRepo) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	var updated []supabaseGuestSession
+	_, err := SupabaseClient.
+		From("guest_sessions").
+		Update(fields, "", "").
+		Eq("id", id).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to update guest session: %w", err)
+	}
+	return nil
+}
+
+// sessionUseAttempts bounds how often Use retries after losing a race to other guests
+const sessionUseAttempts = 10
+
+// Use increments uses only while it still equals the value read, so concurrent guests cannot
+// both take the last use, and only while the session is not revoked or expired, so a rotation between
+// the read and the update wins. A lost race re-reads the session and tries again while it is usable.
+func (r supabaseGuestSessionRepo) Use(ctx context.Context, id string, now time.Time) (GuestSession, bool, error) {
+	for attempt := 0; attempt < sessionUseAttempts; attempt++ {
+		s, err := r.Get(ctx, id)
+		if err != nil {
+			return GuestSession{}, false, err
+		}
+		if s.state(now) != SessionActive {
+			return s, false, nil
+		}
+		var updated []supabaseGuestSession
+		_, err = SupabaseClient.
+			From("guest_sessions").
+			Update(map[string]interface{}{"uses": s.Uses + 1, "last_used_at": now.UTC().Format(time.RFC3339Nano)}, "", "").
+			Eq("id", id).
+			Eq("uses", strconv.Itoa(s.Uses)).
+			Is("revoked_at", "null").
+			Gt("expires_at", now.UTC().Format(time.RFC3339Nano)).
+			ExecuteTo(&updated)
+		if err != nil {
+			return GuestSession{}, false, fmt.Errorf("failed to use guest session: %w", err)
+		}
+		if len(updated) > 0 {
+			return updated[0].session(), true, nil
+		}
+	}
+	s, err := r.Get(ctx, id)
+	return s, false, err
+}
+
+// supabaseGuestSession maps the table_label column, which GuestSession exposes as "table" in JSON
+type supabaseGuestSession struct {
+	GuestSession
+	TableLabel *string `json:"table_label"`
+}
+
+func (s supabaseGuestSession) session() GuestSession {
+	if s.TableLabel != nil {
+		s.GuestSession.Table = *s.TableLabel
+	}
+	return s.GuestSession
+}
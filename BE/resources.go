@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// validClientID reports whether a client-supplied id can key a row; ids are UUID columns
+func validClientID(c *gin.Context, id string) bool {
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id must be a UUID", "id": id})
+		return false
+	}
+	return true
+}
+
+// chatbotNamespaces lists the vector namespaces a branch's chatbot may have written: every build
+// not yet deleted and the plain branch namespace used before blue/green builds
+func chatbotNamespaces(ctx context.Context, restaurantID, branchID string) ([]string, error) {
+	namespaces := []string{branchNamespace(restaurantID, branchID)}
+	builds, err := Builds.List(ctx, branchID)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range builds {
+		if b.Status != BuildDeleted && b.Namespace != "" && indexOf(namespaces, b.Namespace) < 0 {
+			namespaces = append(namespaces, b.Namespace)
+		}
+	}
+	return namespaces, nil
+}
+
+// dropNamespaces deletes vector namespaces once their rows are gone. The database delete cannot be
+// undone, so failures are logged and reported instead of failing the request.
+func dropNamespaces(ctx context.Context, namespaces []string) gin.H {
+	ctx = context.WithoutCancel(ctx)
+	failed := []string{}
+	for _, ns := range namespaces {
+		if err := Vectors.DeleteNamespace(ctx, ns); err != nil {
+			log.Printf("Failed to delete vector namespace %s: %v", ns, err)
+			failed = append(failed, ns)
+		}
+	}
+	return gin.H{"namespaces_deleted": len(namespaces) - len(failed), "namespaces_failed": failed}
+}
+
+// ownedBranches returns the signed-in owner's branches, of one restaurant when restaurantID is set
+func ownedBranches(c *gin.Context, restaurantID string) ([]Branch, bool) {
+	ctx := c.Request.Context()
+	if restaurantID != "" {
+		if !authorizeRestaurant(c, restaurantID) {
+			return nil, false
+		}
+		branches, err := Branches.ListByRestaurant(ctx, restaurantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branches", "details": err.Error()})
+			return nil, false
+		}
+		return branches, true
+	}
+
+	restaurants, err := Restaurants.ListByOwner(ctx, currentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list restaurants", "details": err.Error()})
+		return nil, false
+	}
+	branches := []Branch{}
+	for _, r := range restaurants {
+		more, err := Branches.ListByRestaurant(ctx, r.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branches", "details": err.Error()})
+			return nil, false
+		}
+		branches = append(branches, more...)
+	}
+	return branches, true
+}
+
+// ListRestaurants returns the signed-in owner's restaurants
+func ListRestaurants(c *gin.Context) {
+	restaurants, err := Restaurants.ListByOwner(c.Request.Context(), currentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list restaurants", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"count": len(restaurants), "restaurants": restaurants})
+}
+
+// GetRestaurant returns one restaurant
+func GetRestaurant(c *gin.Context) {
+	restaurant, err := Restaurants.Get(c.Request.Context(), c.Param("restaurantId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get restaurant", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, restaurant)
+}
+
+// PutRestaurant replaces a restaurant's name and description, creating it under the path id for
+// the signed-in owner when it does not exist yet, so retries are safe
+func PutRestaurant(c *gin.Context) {
+	restaurantID := c.Param("restaurantId")
+	var body struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validClientID(c, restaurantID) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	_, err := Restaurants.Get(ctx, restaurantID)
+	if errors.Is(err, ErrNotFound) {
+		created, err := Restaurants.Create(ctx, Restaurant{ID: restaurantID, Name: body.Name, Description: body.Description, OwnerID: currentUser(c).ID})
+		if err != nil {
+			log.Printf("Restaurant insert error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create restaurant", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, created)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get restaurant", "details": err.Error()})
+		return
+	}
+	if !authorizeRestaurant(c, restaurantID) {
+		return
+	}
+	updateRestaurant(c, restaurantID, map[string]interface{}{"name": body.Name, "description": body.Description})
+}
+
+// PatchRestaurant changes the fields present in the body
+func PatchRestaurant(c *gin.Context) {
+	var body struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields := map[string]interface{}{}
+	if body.Name != nil {
+		if strings.TrimSpace(*body.Name) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Restaurant name cannot be empty"})
+			return
+		}
+		fields["name"] = *body.Name
+	}
+	if body.Description != nil {
+		fields["description"] = *body.Description
+	}
+	updateRestaurant(c, c.Param("restaurantId"), fields)
+}
+
+func updateRestaurant(c *gin.Context, restaurantID string, fields map[string]interface{}) {
+	ctx := c.Request.Context()
+	if err := Restaurants.Update(ctx, restaurantID, fields); err != nil {
+		log.Printf("Restaurant update error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update restaurant", "details": err.Error()})
+		return
+	}
+	updated, err := Restaurants.Get(ctx, restaurantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Restaurant updated but could not be reloaded", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteRestaurant deletes a restaurant with all its branches and their chatbots' vectors
+func DeleteRestaurant(c *gin.Context) {
+	restaurantID := c.Param("restaurantId")
+	ctx := c.Request.Context()
+	branches, err := Branches.ListByRestaurant(ctx, restaurantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branches", "details": err.Error()})
+		return
+	}
+	var namespaces []string
+	for _, b := range branches {
+		ns, err := chatbotNamespaces(ctx, restaurantID, b.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list index builds", "details": err.Error()})
+			return
+		}
+		namespaces = append(namespaces, ns...)
+	}
+
+	if err := Restaurants.Delete(ctx, restaurantID); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Restaurant delete error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete restaurant", "details": err.Error()})
+		return
+	}
+	log.Printf("Deleted restaurant %s with %d branches", restaurantID, len(branches))
+	c.JSON(http.StatusOK, gin.H{
+		"deleted":          restaurantID,
+		"branches_deleted": len(branches),
+		"vectors":          dropNamespaces(ctx, namespaces),
+	})
+}
+
+// ListBranches returns the signed-in owner's branches, optionally of one ?restaurant_id
+func ListBranches(c *gin.Context) {
+	branches, ok := ownedBranches(c, c.Query("restaurant_id"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"count": len(branches), "branches": branches})
+}
+
+// PatchBranch changes the fields present in the body; a profile replaces the stored one
+func PatchBranch(c *gin.Context) {
+	branchID := c.Param("branchId")
+	var body struct {
+		Name     *string        `json:"name"`
+		Address  *string        `json:"address"`
+		Timezone *string        `json:"timezone"`
+		Profile  *BranchProfile `json:"profile"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields := map[string]interface{}{}
+	if body.Name != nil {
+		if strings.TrimSpace(*body.Name) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Branch name cannot be empty"})
+			return
+		}
+		fields["name"] = *body.Name
+	}
+	if body.Address != nil {
+		fields["address"] = *body.Address
+	}
+	if body.Timezone != nil {
+		if *body.Timezone == "" {
+			*body.Timezone = "UTC"
+		} else if !validTimezone(*body.Timezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone; use an IANA name such as Asia/Jakarta", "timezone": *body.Timezone})
+			return
+		}
+		fields["timezone"] = *body.Timezone
+	}
+	if body.Profile != nil {
+		if errs := validateBranchProfile(*body.Profile); len(errs) > 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid branch profile", "fields": errs})
+			return
+		}
+		fields["profile"] = *body.Profile
+	}
+	updateBranch(c, branchID, fields)
+}
+
+func updateBranch(c *gin.Context, branchID string, fields map[string]interface{}) {
+	ctx := c.Request.Context()
+	if err := Branches.Update(ctx, branchID, fields); err != nil {
+		log.Printf("Branch update error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update branch", "details": err.Error()})
+		return
+	}
+	updated, err := Branches.Get(ctx, branchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Branch updated but could not be reloaded", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteBranch deletes a branch with its chatbot, vector namespaces, snapshots, guest sessions
+// and chat history
+func DeleteBranch(c *gin.Context) {
+	branchID := c.Param("branchId")
+	ctx := c.Request.Context()
+	branch, err := Branches.Get(ctx, branchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branch", "details": err.Error()})
+		return
+	}
+	namespaces, err := chatbotNamespaces(ctx, branch.RestaurantID, branch.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list index builds", "details": err.Error()})
+		return
+	}
+
+	// Snapshots, sessions, the chatbot and chat history with this branch_id go with the row
+	// (ON DELETE CASCADE); history the migration could not attribute to a branch stays
+	if err := Branches.Delete(ctx, branchID); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Branch delete error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete branch", "details": err.Error()})
+		return
+	}
+	log.Printf("Deleted branch %s (%s)", branch.Name, branch.ID)
+	c.JSON(http.StatusOK, gin.H{"deleted": branchID, "vectors": dropNamespaces(ctx, namespaces)})
+}
+
+// ListChatbots returns the chatbots of the signed-in owner's branches, optionally of one ?restaurant_id
+func ListChatbots(c *gin.Context) {
+	branches, ok := ownedBranches(c, c.Query("restaurant_id"))
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	chatbots := []Chatbot{}
+	for _, b := range branches {
+		bot, err := Chatbots.Get(ctx, b.ID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chatbot", "details": err.Error()})
+			return
+		}
+		chatbots = append(chatbots, bot)
+	}
+	c.JSON(http.StatusOK, gin.H{"count": len(chatbots), "chatbots": chatbots})
+}
+
+// PutChatbot makes content the active version of the chatbot at the path id (the branch id),
+// creating the chatbot if needed; sending the active content again changes nothing
+func PutChatbot(c *gin.Context) {
+	chatbotID := c.Param("chatbotId")
+	var body struct {
+		Content       json.RawMessage `json:"content" binding:"required"`
+		ContentFormat string          `json:"content_format"`
+		Notes         string          `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validClientID(c, chatbotID) || !bindValidatedContent(c, body.Content, body.ContentFormat) {
+		return
+	}
+	if !authorizeBranch(c, chatbotID) {
+		return
+	}
+	upsertChatbot(c, chatbotID, body.Content, body.Notes)
+}
+
+// PatchChatbot replaces the retrieval settings and/or content present in the body
+func PatchChatbot(c *gin.Context) {
+	chatbotID := c.Param("chatbotId")
+	var body struct {
+		Content       json.RawMessage    `json:"content"`
+		ContentFormat string             `json:"content_format"`
+		Notes         string             `json:"notes"`
+		Settings      *RetrievalSettings `json:"retrieval_settings"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(body.Content) == 0 && body.Settings == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update; send content and/or retrieval_settings"})
+		return
+	}
+	if len(body.Content) > 0 && !bindValidatedContent(c, body.Content, body.ContentFormat) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if body.Settings != nil {
+		if errs := body.Settings.validate(); len(errs) > 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid retrieval settings", "fields": errs})
+			return
+		}
+		if err := Chatbots.Update(ctx, chatbotID, map[string]interface{}{"retrieval_settings": *body.Settings}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update retrieval settings", "details": err.Error()})
+			return
+		}
+	}
+	if len(body.Content) > 0 {
+		upsertChatbot(c, chatbotID, body.Content, body.Notes)
+		return
+	}
+	GetChatbot(c)
+}
+
+// DeleteChatbot deletes a chatbot with its versions, builds and vectors; the branch and its menu
+// snapshots stay, so the chatbot can be created again
+func DeleteChatbot(c *gin.Context) {
+	chatbotID := c.Param("chatbotId")
+	ctx := c.Request.Context()
+	bot, err := Chatbots.Get(ctx, chatbotID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chatbot", "details": err.Error()})
+		return
+	}
+	branch, err := Branches.Get(ctx, bot.BranchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branch", "details": err.Error()})
+		return
+	}
+	namespaces, err := chatbotNamespaces(ctx, branch.RestaurantID, branch.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list index builds", "details": err.Error()})
+		return
+	}
+
+	if err := Chatbots.Delete(ctx, chatbotID); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Chatbot delete error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete chatbot", "details": err.Error()})
+		return
+	}
+	if err := Branches.SetHasChatbot(ctx, branch.ID, false); err != nil {
+		log.Printf("Warning: failed to clear has_chatbot on branch %s: %v", branch.ID, err)
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": chatbotID, "vectors": dropNamespaces(ctx, namespaces)})
+}
+
+// upsertChatbot makes content the active version of a branch's chatbot and queues its indexing,
+// creating the chatbot on first use. Content that is already active changes nothing.
+func upsertChatbot(c *gin.Context, branchID string, content json.RawMessage, notes string) {
+	ctx := c.Request.Context()
+	hash := generateHash(content)
+	bot, err := Chatbots.Get(ctx, branchID)
+	if errors.Is(err, ErrNotFound) {
+		createChatbotWithContent(c, branchID, content, hash)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chatbot", "details": err.Error()})
+		return
+	}
+
+	v, err := Versions.FindByHash(ctx, bot.ID, hash)
+	unchanged := (err == nil && v.ID == bot.ActiveVersionID) || (bot.ActiveVersionID == "" && bot.ContentHash == hash)
+	if unchanged {
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Content unchanged. Skipping chatbot regeneration.",
+			"chatbot_id":  bot.ID,
+			"index_state": indexState(bot),
+		})
+		return
+	}
+	if errors.Is(err, ErrNotFound) {
+		v, err = Versions.Create(ctx, ChatbotVersion{ChatbotID: bot.ID, Content: content, ContentHash: hash, Notes: notes, CreatedBy: currentUser(c).ID})
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add version", "details": err.Error()})
+		return
+	}
+	activateVersion(c, bot, v, true)
+}
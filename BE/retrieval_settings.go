@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Defaults for settings a chatbot leaves unset
+const (
+	defaultTopK         = 5
+	defaultHistoryTurns = 5
+	maxTopK             = 50
+	maxHistoryTurns     = 50
+	maxContextCharLimit = 100000
+)
+
+// modelNamePattern accepts provider model names such as "models/gemini-2.5-flash"
+var modelNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// RetrievalSettings tune how a chatbot retrieves and answers. They are stored as JSON on the chatbot;
+// zero values mean the default, see withDefaults.
+type RetrievalSettings struct {
+	TopK            int     `json:"top_k,omitempty"`             // chunks retrieved per question, default 5
+	MinScore        float64 `json:"min_score,omitempty"`         // matches scoring below this are dropped
+	MaxContextChars int     `json:"max_context_chars,omitempty"` // budget for retrieved knowledge in the prompt, 0 = unlimited
+	HistoryTurns    int     `json:"history_turns,omitempty"`     // past exchanges included in history prompts, default 5
+	Model           string  `json:"model,omitempty"`             // chat model name, default the configured CHAT_MODEL
+}
+
+// withDefaults returns the settings a query actually uses
+func (s RetrievalSettings) withDefaults() RetrievalSettings {
+	if s.TopK <= 0 {
+		s.TopK = defaultTopK
+	}
+	if s.HistoryTurns <= 0 {
+		s.HistoryTurns = defaultHistoryTurns
+	}
+	if s.Model == "" {
+		s.Model = chatModelName(Chat)
+	}
+	return s
+}
+
+// debug lists every value a query used, zeros included
+func (s RetrievalSettings) debug() gin.H {
+	return gin.H{"top_k": s.TopK, "min_score": s.MinScore, "max_context_chars": s.MaxContextChars, "history_turns": s.HistoryTurns, "model": s.Model}
+}
+
+// validate returns field errors for out-of-range settings
+func (s RetrievalSettings) validate() []FieldError {
+	var errs []FieldError
+	if s.TopK < 0 || s.TopK > maxTopK {
+		errs = append(errs, FieldError{Field: "top_k", Message: "want 1..50, or 0 for the default"})
+	}
+	if s.MinScore < 0 || s.MinScore > 1 {
+		errs = append(errs, FieldError{Field: "min_score", Message: "want 0..1"})
+	}
+	if s.MaxContextChars < 0 || s.MaxContextChars > maxContextCharLimit {
+		errs = append(errs, FieldError{Field: "max_context_chars", Message: "want 0..100000"})
+	}
+	if s.HistoryTurns < 0 || s.HistoryTurns > maxHistoryTurns {
+		errs = append(errs, FieldError{Field: "history_turns", Message: "want 1..50, or 0 for the default"})
+	}
+	if s.Model != "" && !modelNamePattern.MatchString(s.Model) {
+		errs = append(errs, FieldError{Field: "model", Message: "not a model name"})
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+// chatbotSettings returns the effective settings of a branch's chatbot, or the defaults when it has none
+func chatbotSettings(ctx context.Context, branchID string) RetrievalSettings {
+	bot, err := Chatbots.Get(ctx, branchID)
+	if err != nil {
+		return RetrievalSettings{}.withDefaults()
+	}
+	return bot.Settings.withDefaults()
+}
+
+// aboveMinScore drops matches that score below min
+func aboveMinScore(matches []VectorMatch, min float64) []VectorMatch {
+	if min <= 0 {
+		return matches
+	}
+	kept := matches[:0:0]
+	for _, m := range matches {
+		if float64(m.Score) >= min {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// limitContext keeps the best-ranked chunks that fit in max characters; the first is truncated rather than dropped
+func limitContext(texts []string, max int) []string {
+	if max <= 0 {
+		return texts
+	}
+	total := 0
+	for i, t := range texts {
+		if total+len(t) > max {
+			if i == 0 {
+				cut := max
+				for cut > 0 && !utf8.RuneStart(t[cut]) {
+					cut--
+				}
+				return []string{t[:cut]}
+			}
+			return texts[:i]
+		}
+		total += len(t)
+	}
+	return texts
+}
+
+// GetChatbotSettings returns a chatbot's stored retrieval settings and the values queries use
+func GetChatbotSettings(c *gin.Context) {
+	bot, err := Chatbots.Get(c.Request.Context(), c.Param("chatbotId"))
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chatbot", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"chatbot_id":         bot.ID,
+		"retrieval_settings": bot.Settings,
+		"effective":          bot.Settings.withDefaults(),
+	})
+}
+
+// UpdateChatbotSettings replaces a chatbot's retrieval settings
+func UpdateChatbotSettings(c *gin.Context) {
+	chatbotID := c.Param("chatbotId")
+	var settings RetrievalSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errs := settings.validate(); len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid retrieval settings", "fields": errs})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := Chatbots.Get(ctx, chatbotID); errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chatbot", "details": err.Error()})
+		return
+	}
+	if err := Chatbots.Update(ctx, chatbotID, map[string]interface{}{"retrieval_settings": settings}); err != nil {
+		log.Printf("Chatbot settings update error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update retrieval settings", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"chatbot_id":         chatbotID,
+		"retrieval_settings": settings,
+		"effective":          settings.withDefaults(),
+	})
+}
@@ -8,21 +8,82 @@ func RegisterRoutes(r *gin.Engine) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Auth endpoints
+	r.POST("/auth/register", Register)
+	r.POST("/auth/login", Login)
+
+	// Menu content schema
+	r.GET("/schemas/menu.json", GetMenuSchema)
+
+	// Guest chat
+	r.POST("/branches/:branchId/query", QueryChatbot)
+	r.POST("/branches/:branchId/query-with-history", QueryChatbotWithHistory)
+	r.GET("/sessions/:sessionId/validate", ValidateGuestSession)
+
+	// Admin endpoints need a bearer token; routes on a resource also need its owner
+	admin := r.Group("", RequireAuth())
+	admin.GET("/me", Me)
+
 	// Restaurant endpoints
-	r.POST("/restaurants", CreateRestaurant)
-	r.GET("/restaurants/:restaurantId/branches", GetRestaurantBranches)
+	admin.GET("/restaurants", ListRestaurants)
+	admin.POST("/restaurants", CreateRestaurant)
+	admin.PUT("/restaurants/:restaurantId", PutRestaurant)
+	restaurants := admin.Group("/restaurants/:restaurantId", ownsRestaurant("restaurantId"))
+	restaurants.GET("", GetRestaurant)
+	restaurants.PATCH("", PatchRestaurant)
+	restaurants.DELETE("", DeleteRestaurant)
+	restaurants.GET("/branches", GetRestaurantBranches)
 
 	// Branch endpoints
-	r.POST("/branches", CreateBranch)
-	r.POST("/chatbots", CreateChatbot) 
-	r.POST("/chatbots/:chatbotId/reindex", ReindexChatbot) 
+	admin.GET("/branches", ListBranches)
+	admin.POST("/branches", CreateBranch)
+	admin.PUT("/branches/:branchId", UpdateBranch)
+	branches := admin.Group("/branches/:branchId", ownsBranch("branchId"))
+	branches.GET("", GetBranch)
+	branches.PATCH("", PatchBranch)
+	branches.DELETE("", DeleteBranch)
+	branches.GET("/chatbot", GetBranchChatbot)
+
+	// Chatbot endpoints
+	admin.GET("/chatbots", ListChatbots)
+	admin.POST("/chatbots", CreateChatbot)
+	admin.POST("/chatbots/lite", CreateChatbotLite)
+	admin.PUT("/chatbots/:chatbotId", PutChatbot)
+	chatbots := admin.Group("/chatbots/:chatbotId", ownsChatbot("chatbotId"))
+	chatbots.GET("", GetChatbot)
+	chatbots.PATCH("", PatchChatbot)
+	chatbots.DELETE("", DeleteChatbot)
+	chatbots.POST("/reindex", ReindexChatbot)
+	chatbots.GET("/versions", ListChatbotVersions)
+	chatbots.POST("/versions", AddChatbotVersion)
+	chatbots.GET("/versions/:versionId", GetChatbotVersion)
+	chatbots.POST("/versions/:versionId/activate", ActivateChatbotVersion)
+	chatbots.POST("/rollback", RollbackChatbotVersion)
+	chatbots.GET("/builds", ListIndexBuilds)
+	chatbots.POST("/builds/rollback", RollbackIndexBuild)
+	chatbots.GET("/events", StreamChatbotEvents)
+	chatbots.GET("/settings", GetChatbotSettings)
+	chatbots.PUT("/settings", UpdateChatbotSettings)
+
+	// Guest session endpoints
+	branches.POST("/sessions", CreateGuestSession)
+	branches.GET("/sessions", ListGuestSessions)
+	branches.GET("/qr", GetBranchQR)
+	branches.GET("/qr/batch", GetBranchQRBatch)
+	admin.POST("/sessions/:sessionId/rotate", ownsSession("sessionId"), RotateGuestSession)
+	admin.POST("/sessions/:sessionId/revoke", ownsSession("sessionId"), RevokeGuestSession)
+
+	// Background job status
+	admin.GET("/jobs/:jobId", ownsJob("jobId"), GetJob)
 
 	// Menu snapshot endpoints
-	r.POST("/branches/:branchId/menu-snapshots", SaveMenuSnapshot)
-	r.GET("/branches/:branchId/menu-snapshots/latest", GetLatestMenuSnapshot)
-	
-	
-	
-	r.POST("/branches/:branchId/query", QueryChatbot)
-	r.POST("/branches/:branchId/query-with-history", QueryChatbotWithHistory)
+	branches.POST("/menu-snapshots", SaveMenuSnapshot)
+	branches.GET("/menu-snapshots", ListMenuSnapshots)
+	branches.GET("/menu-snapshots/latest", GetLatestMenuSnapshot)
+	branches.GET("/menu-snapshots/diff", DiffMenuSnapshots)
+	branches.GET("/menu-snapshots/:snapshotId", GetMenuSnapshot)
+	branches.POST("/menu-snapshots/:snapshotId/restore", RestoreMenuSnapshot)
+	branches.POST("/menu-imports", ImportMenu)
+	branches.POST("/menu-uploads", UploadMenuDocument)
+	branches.POST("/menu-snapshots/:snapshotId/publish", PublishMenuSnapshot)
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultSessionTTL = 24 * time.Hour
+	maxSessionTTL     = 366 * 24 * time.Hour
+)
+
+// state reports whether a session can still be used at now
+func (s GuestSession) state(now time.Time) string {
+	switch {
+	case s.RevokedAt != nil:
+		return SessionRevoked
+	case !now.Before(s.ExpiresAt):
+		return SessionExpired
+	case s.MaxUses > 0 && s.Uses >= s.MaxUses:
+		return SessionExhausted
+	}
+	return SessionActive
+}
+
+// remainingUses is nil for unlimited sessions
+func (s GuestSession) remainingUses() *int {
+	if s.MaxUses == 0 {
+		return nil
+	}
+	left := s.MaxUses - s.Uses
+	if left < 0 {
+		left = 0
+	}
+	return &left
+}
+
+// sessionLifetime reads expires_in ("90m", "12h") or expires_at, defaulting to defaultSessionTTL
+func sessionLifetime(expiresIn string, expiresAt *time.Time, now time.Time) (time.Time, string) {
+	switch {
+	case expiresIn != "" && expiresAt != nil:
+		return time.Time{}, "Set expires_in or expires_at, not both"
+	case expiresAt != nil:
+		if !expiresAt.After(now) || expiresAt.Sub(now) > maxSessionTTL {
+			return time.Time{}, "expires_at must be in the future and within a year"
+		}
+		return *expiresAt, ""
+	case expiresIn != "":
+		ttl, err := time.ParseDuration(expiresIn)
+		if err != nil || ttl <= 0 || ttl > maxSessionTTL {
+			return time.Time{}, "expires_in must be a positive duration such as 12h, at most a year"
+		}
+		return now.Add(ttl), ""
+	}
+	return now.Add(defaultSessionTTL), ""
+}
+
+// lookupSession loads a session by the id a guest presented; ids that are not UUIDs are simply unknown
+func lookupSession(c *gin.Context, sessionID string) (GuestSession, bool) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ok": false, "status": "not_found", "error": "Session not found"})
+		return GuestSession{}, false
+	}
+	session, err := Sessions.Get(c.Request.Context(), sessionID)
+	if errors.Is(err, ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ok": false, "status": "not_found", "error": "Session not found"})
+		return GuestSession{}, false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session", "details": err.Error()})
+		return GuestSession{}, false
+	}
+	return session, true
+}
+
+// authorizeGuest admits a question to a branch's chat. Guests need an active session of that branch,
+// which is charged one use; the branch owner may preview without one, getting a fresh history id.
+func authorizeGuest(c *gin.Context, branchID string, sessionID *string) bool {
+	if *sessionID == "" {
+		if _, ok := optionalUser(c); ok && authorizeBranch(c, branchID) {
+			*sessionID = uuid.New().String()
+			return true
+		}
+		if !c.IsAborted() {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session_id is required"})
+		}
+		return false
+	}
+
+	session, ok := lookupSession(c, *sessionID)
+	if !ok {
+		return false
+	}
+	if session.BranchID != branchID {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"ok": false, "status": "wrong_branch", "error": "Session belongs to a different branch"})
+		return false
+	}
+	session, used, err := Sessions.Use(c.Request.Context(), session.ID, time.Now())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to use session", "details": err.Error()})
+		return false
+	}
+	if !used {
+		status := session.state(time.Now())
+		if status == SessionActive {
+			// Still usable, but every attempt to count the use lost to other guests
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"ok": false, "status": "busy", "error": "Session is busy; try again"})
+			return false
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"ok": false, "status": status, "error": "Session is " + status})
+		return false
+	}
+	return true
+}
+
+// ownsSession guards session routes by the owner of the session's branch
+func ownsSession(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, ok := lookupSession(c, c.Param(param))
+		if ok && authorizeBranch(c, session.BranchID) {
+			c.Set("guest_session", session)
+			c.Next()
+		}
+	}
+}
+
+// CreateGuestSession issues a session for a branch, optionally for one table, with an expiry and usage limit
+func CreateGuestSession(c *gin.Context) {
+	branchID := c.Param("branchId")
+	var body struct {
+		Table     string     `json:"table"`
+		ExpiresIn string     `json:"expires_in"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		MaxUses   int        `json:"max_uses"` // questions allowed; 0 means unlimited
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.MaxUses < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_uses must be 0 (unlimited) or more"})
+		return
+	}
+	expiresAt, msg := sessionLifetime(body.ExpiresIn, body.ExpiresAt, time.Now())
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	session, err := Sessions.Create(c.Request.Context(), GuestSession{
+		BranchID:  branchID,
+		Table:     strings.TrimSpace(body.Table),
+		ExpiresAt: expiresAt,
+		MaxUses:   body.MaxUses,
+		CreatedBy: currentUser(c).ID,
+	})
+	if err != nil {
+		log.Printf("Guest session insert error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, session)
+}
+
+// ListGuestSessions returns a branch's sessions, newest first, with their current state
+func ListGuestSessions(c *gin.Context) {
+	sessions, err := Sessions.ListByBranch(c.Request.Context(), c.Param("branchId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions", "details": err.Error()})
+		return
+	}
+	type sessionSummary struct {
+		GuestSession
+		Status string `json:"status"`
+	}
+	now := time.Now()
+	out := make([]sessionSummary, len(sessions))
+	for i, s := range sessions {
+		out[i] = sessionSummary{GuestSession: s, Status: s.state(now)}
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": out, "count": len(out)})
+}
+
+// ValidateGuestSession tells the guest page whether to open the chat; it does not use the session
+func ValidateGuestSession(c *gin.Context) {
+	session, ok := lookupSession(c, c.Param("sessionId"))
+	if !ok {
+		return
+	}
+	status := session.state(time.Now())
+	resp := gin.H{
+		"ok":         status == SessionActive,
+		"status":     status,
+		"session_id": session.ID,
+		"branch_id":  session.BranchID,
+		"expires_at": session.ExpiresAt,
+	}
+	if session.Table != "" {
+		resp["table"] = session.Table
+	}
+	if left := session.remainingUses(); left != nil {
+		resp["remaining_uses"] = *left
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RotateGuestSession replaces a session with a fresh one for the same branch and table, resetting its
+// uses, and revokes the old one so previously printed codes stop working
+func RotateGuestSession(c *gin.Context) {
+	old := c.MustGet("guest_session").(GuestSession)
+	var body struct {
+		ExpiresIn string     `json:"expires_in"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	now := time.Now()
+	// Without a new expiry the replacement keeps the old session's lifetime
+	expiresIn := body.ExpiresIn
+	if expiresIn == "" && body.ExpiresAt == nil {
+		expiresIn = old.ExpiresAt.Sub(old.CreatedAt).String()
+	}
+	expiresAt, msg := sessionLifetime(expiresIn, body.ExpiresAt, now)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	ctx := c.Request.Context()
+	replacement, err := Sessions.Create(ctx, GuestSession{
+		BranchID:  old.BranchID,
+		Table:     old.Table,
+		ExpiresAt: expiresAt,
+		MaxUses:   old.MaxUses,
+		CreatedBy: currentUser(c).ID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create replacement session", "details": err.Error()})
+		return
+	}
+	fields := map[string]interface{}{"rotated_to": replacement.ID}
+	if old.RevokedAt == nil {
+		fields["revoked_at"] = now.UTC()
+	}
+	if err := Sessions.Update(ctx, old.ID, fields); err != nil {
+		log.Printf("Failed to revoke rotated session %s: %v", old.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Replacement created but the old session could not be revoked", "session": replacement, "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"session": replacement, "replaced": old.ID})
+}
+
+// RevokeGuestSession ends a session immediately; revoking twice is a no-op
+func RevokeGuestSession(c *gin.Context) {
+	session := c.MustGet("guest_session").(GuestSession)
+	if session.RevokedAt == nil {
+		now := time.Now().UTC()
+		if err := Sessions.Update(c.Request.Context(), session.ID, map[string]interface{}{"revoked_at": now}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session", "details": err.Error()})
+			return
+		}
+		session.RevokedAt = &now
+	}
+	c.JSON(http.StatusOK, gin.H{"session": session, "status": session.state(time.Now())})
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSnapshotPageSize = 20
+	maxSnapshotPageSize     = 100
+	// renameSimilarity is how alike two names in the same section must be to count as a rename
+	renameSimilarity = 0.6
+)
+
+// MenuDiff is the structural difference between two snapshots, from the older to the newer
+type MenuDiff struct {
+	From         string        `json:"from"`
+	To           string        `json:"to"`
+	Added        []menuDish    `json:"added"`
+	Removed      []menuDish    `json:"removed"`
+	Renamed      []DishRename  `json:"renamed"`
+	PriceChanges []PriceChange `json:"price_changes"`
+	Unchanged    int           `json:"unchanged"`
+}
+
+// DishRename is a dish that kept its place in the menu under a new name
+type DishRename struct {
+	Section string `json:"section,omitempty"`
+	OldName string `json:"old_name"`
+	NewName string `json:"new_name"`
+	OldKey  string `json:"old_key"`
+	NewKey  string `json:"new_key"`
+}
+
+// PriceChange is a dish whose price text changed; Change reads "old → new"
+type PriceChange struct {
+	Section string `json:"section,omitempty"`
+	Name    string `json:"name"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Change  string `json:"change"`
+}
+
+// diffMenus compares the dishes the chunker finds in two contents. Dishes are matched by their
+// vector key first; unmatched dishes in the same list with similar names are reported as renames.
+func diffMenus(from, to json.RawMessage) (MenuDiff, error) {
+	oldMenu, err := chunkMenu(from)
+	if err != nil {
+		return MenuDiff{}, fmt.Errorf("failed to read older snapshot: %w", err)
+	}
+	newMenu, err := chunkMenu(to)
+	if err != nil {
+		return MenuDiff{}, fmt.Errorf("failed to read newer snapshot: %w", err)
+	}
+
+	diff := MenuDiff{Added: []menuDish{}, Removed: []menuDish{}, Renamed: []DishRename{}, PriceChanges: []PriceChange{}}
+
+	newByKey := make(map[string]menuDish, len(newMenu.dishes))
+	for _, d := range newMenu.dishes {
+		newByKey[d.Key] = d
+	}
+	matched := make(map[string]bool)
+	var removed []menuDish
+	for _, old := range oldMenu.dishes {
+		cur, ok := newByKey[old.Key]
+		if !ok {
+			removed = append(removed, old)
+			continue
+		}
+		matched[old.Key] = true
+		diff.compare(old, cur)
+	}
+	var added []menuDish
+	for _, d := range newMenu.dishes {
+		if !matched[d.Key] {
+			added = append(added, d)
+		}
+	}
+
+	// Name-keyed dishes change key when renamed; pair them up greedily by name similarity
+	type pair struct {
+		old, cur int
+		score    float64
+	}
+	var pairs []pair
+	for i, old := range removed {
+		for j, cur := range added {
+			if dishSource(old.Key) != dishSource(cur.Key) {
+				continue
+			}
+			if score := renameScore(old, cur); score >= renameSimilarity {
+				pairs = append(pairs, pair{i, j, score})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(a, b int) bool { return pairs[a].score > pairs[b].score })
+	usedOld, usedNew := make(map[int]bool), make(map[int]bool)
+	for _, p := range pairs {
+		if usedOld[p.old] || usedNew[p.cur] {
+			continue
+		}
+		usedOld[p.old], usedNew[p.cur] = true, true
+		diff.compare(removed[p.old], added[p.cur])
+	}
+
+	for i, d := range removed {
+		if !usedOld[i] {
+			diff.Removed = append(diff.Removed, d)
+		}
+	}
+	for i, d := range added {
+		if !usedNew[i] {
+			diff.Added = append(diff.Added, d)
+		}
+	}
+	return diff, nil
+}
+
+// compare records how one dish changed between snapshots
+func (d *MenuDiff) compare(old, cur menuDish) {
+	changed := false
+	if !strings.EqualFold(strings.TrimSpace(old.Name), strings.TrimSpace(cur.Name)) {
+		d.Renamed = append(d.Renamed, DishRename{Section: cur.Section, OldName: old.Name, NewName: cur.Name, OldKey: old.Key, NewKey: cur.Key})
+		changed = true
+	}
+	if old.Price != cur.Price {
+		d.PriceChanges = append(d.PriceChanges, PriceChange{
+			Section: cur.Section,
+			Name:    cur.Name,
+			Old:     old.Price,
+			New:     cur.Price,
+			Change:  fmt.Sprintf("%s → %s", orDash(old.Price), orDash(cur.Price)),
+		})
+		changed = true
+	}
+	if !changed {
+		d.Unchanged++
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "—"
+	}
+	return s
+}
+
+// dishSource is the list part of a dish key ("sections[name=Drinks].items|latte" -> "sections[name=Drinks].items")
+func dishSource(key string) string {
+	if i := strings.LastIndex(key, "|"); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// renameScore rates how likely cur is old under a new name. Edit distance alone misses
+// "Latte" -> "Caffe Latte", so containing the old name counts, and an unchanged price helps.
+func renameScore(old, cur menuDish) float64 {
+	score := nameSimilarity(old.Name, cur.Name)
+	a, b := " "+strings.ToLower(old.Name)+" ", " "+strings.ToLower(cur.Name)+" "
+	if strings.Contains(a, b) || strings.Contains(b, a) {
+		score = math.Max(score, 0.8)
+	}
+	if old.Price != "" && old.Price == cur.Price {
+		score += 0.1
+	}
+	return score
+}
+
+// nameSimilarity is 1 minus the normalised edit distance between two names, ignoring case
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(strings.ToLower(strings.TrimSpace(a))), []rune(strings.ToLower(strings.TrimSpace(b)))
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+// loadBranchSnapshot fetches a snapshot and checks it belongs to branchID, writing the error response if not
+func loadBranchSnapshot(c *gin.Context, branchID, snapshotID string) (MenuSnapshot, bool) {
+	snap, err := Snapshots.Get(c.Request.Context(), snapshotID)
+	if errors.Is(err, ErrNotFound) || (err == nil && snap.BranchID != branchID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found", "snapshot_id": snapshotID})
+		return MenuSnapshot{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch snapshot", "details": err.Error()})
+		return MenuSnapshot{}, false
+	}
+	return snap, true
+}
+
+// ListMenuSnapshots pages through a branch's snapshots, newest first.
+// Query: limit (default 20, max 100), offset, and status (published|draft).
+func ListMenuSnapshots(c *gin.Context) {
+	branchID := c.Param("branchId")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSnapshotPageSize)))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+	if limit > maxSnapshotPageSize {
+		limit = maxSnapshotPageSize
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+		return
+	}
+	status := c.Query("status")
+	if status != "" && status != SnapshotPublished && status != SnapshotDraft {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be published or draft"})
+		return
+	}
+
+	snapshots, total, err := Snapshots.List(c.Request.Context(), branchID, status, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list snapshots", "details": err.Error()})
+		return
+	}
+	if snapshots == nil {
+		snapshots = []MenuSnapshot{}
+	}
+
+	resp := gin.H{
+		"snapshots": snapshots,
+		"total":     total,
+		"limit":     limit,
+		"offset":    offset,
+	}
+	if offset+len(snapshots) < total {
+		resp["next_offset"] = offset + len(snapshots)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetMenuSnapshot returns one snapshot with its content
+func GetMenuSnapshot(c *gin.Context) {
+	snap, ok := loadBranchSnapshot(c, c.Param("branchId"), c.Param("snapshotId"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshot": snap})
+}
+
+// DiffMenuSnapshots compares two snapshots of a branch: ?from=<id>&to=<id>.
+// to defaults to the latest published snapshot.
+func DiffMenuSnapshots(c *gin.Context) {
+	branchID := c.Param("branchId")
+	fromID := c.Query("from")
+	if fromID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from is required"})
+		return
+	}
+
+	from, ok := loadBranchSnapshot(c, branchID, fromID)
+	if !ok {
+		return
+	}
+	var to MenuSnapshot
+	if toID := c.Query("to"); toID != "" {
+		if to, ok = loadBranchSnapshot(c, branchID, toID); !ok {
+			return
+		}
+	} else {
+		latest, err := Snapshots.Latest(c.Request.Context(), branchID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No published snapshot to compare against"})
+			return
+		}
+		to = latest
+	}
+
+	diff, err := diffMenus(from.Content, to.Content)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	diff.From, diff.To = from.ID, to.ID
+	c.JSON(http.StatusOK, diff)
+}
+
+// RestoreMenuSnapshot makes an older published snapshot current again by publishing a copy of it,
+// so history stays append-only. With reindex=true the branch chatbot is reindexed from the copy.
+func RestoreMenuSnapshot(c *gin.Context) {
+	branchID := c.Param("branchId")
+	var body struct {
+		Reindex bool   `json:"reindex"`
+		Notes   string `json:"notes"`
+	}
+	_ = c.ShouldBindJSON(&body) // accept empty
+
+	snap, ok := loadBranchSnapshot(c, branchID, c.Param("snapshotId"))
+	if !ok {
+		return
+	}
+	if snap.Status == SnapshotDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "Snapshot is a draft; publish it instead"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	// Restoring the current content publishes nothing, but a requested reindex still runs so a
+	// failed index can be repaired from it
+	status := http.StatusCreated
+	var restored MenuSnapshot
+	var resp gin.H
+	if latest, err := Snapshots.Latest(ctx, branchID); err == nil && latest.ContentHash == snap.ContentHash {
+		status, restored = http.StatusOK, latest
+		resp = gin.H{"message": "Snapshot is already current", "snapshot_id": latest.ID}
+		if !body.Reindex {
+			c.JSON(status, resp)
+			return
+		}
+	} else {
+		notes := body.Notes
+		if notes == "" {
+			notes = fmt.Sprintf("Restored from snapshot %s (%s)", snap.ID, snap.CreatedAt.Format("2006-01-02 15:04"))
+		}
+		restored, err = saveMenuSnapshot(ctx, branchID, snap.Content, notes, currentUser(c).ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore snapshot", "details": err.Error()})
+			return
+		}
+		resp = gin.H{
+			"message":       "Snapshot restored",
+			"snapshot_id":   restored.ID,
+			"restored_from": snap.ID,
+		}
+	}
+
+	if body.Reindex {
+		job, err := reindexBranchContent(ctx, branchID, restored.Content)
+		if err != nil {
+			log.Printf("Restore of snapshot %s: reindex not started: %v", snap.ID, err)
+			resp["reindex_error"] = err.Error()
+		} else {
+			resp["job_id"] = job.ID
+		}
+	}
+	c.JSON(status, resp)
+}
+
+// reindexBranchContent queues a reindex of the branch chatbot, as ReindexChatbot does
+func reindexBranchContent(ctx context.Context, branchID string, content json.RawMessage) (IndexJob, error) {
+	bot, err := Chatbots.Get(ctx, branchID) // chatbot id equals branch id
+	if err != nil {
+		return IndexJob{}, fmt.Errorf("branch has no chatbot: %w", err)
+	}
+	return enqueueIndexJob(ctx, bot.ID, IndexJobPayload{BranchID: branchID, Content: content})
+}
@@ -4,45 +4,15 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/pinecone-io/go-pinecone/v4/pinecone"
-	"google.golang.org/protobuf/types/known/structpb"
 )
 
-// createPineconeIndex creates a new Pinecone index if it doesn't exist
-func createPineconeIndex() error {
-	ctx := context.Background()
-
-	_, err := PineconeClient.DescribeIndex(ctx, "mindmenu-index")
-	if err == nil {
-		log.Printf("Index 'mindmenu-index' already exists")
-		return nil
-	}
-
-	log.Printf("Creating Pinecone index 'mindmenu-index'...")
-
-	dimension := int32(768)
-	metric := pinecone.Cosine
-	_, err = PineconeClient.CreateServerlessIndex(ctx, &pinecone.CreateServerlessIndexRequest{
-		Name:      "mindmenu-index",
-		Dimension: &dimension,
-		Metric:    &metric,
-		Cloud:     pinecone.Aws,
-		Region:    "us-east1",
-	})
-
-	if err != nil {
-		return fmt.Errorf("failed to create index: %w", err)
-	}
-
-	log.Printf("Successfully created index 'mindmenu-index'")
-	return nil
-}
-
 // computeContentHash returns a stable hash representing the meaningful content
 func computeContentHash(chunk TextChunk) string {
 	// Hash fields that should cause an update when changed
@@ -74,53 +44,39 @@ func computeDeterministicID(m Metadata) string {
 }
 
 // fetchExistingHashes gets existing content_hash for a set of IDs in this namespace
-func fetchExistingHashes(ctx context.Context, index *pinecone.IndexConnection, ids []string) (map[string]string, error) {
+func fetchExistingHashes(ctx context.Context, namespace string, ids []string) (map[string]string, error) {
 	result := make(map[string]string, len(ids))
 	if len(ids) == 0 {
 		return result, nil
 	}
-	for i := 0; i < len(ids); i += 100 {
-		end := i + 100
-		if end > len(ids) {
-			end = len(ids)
-		}
-		batch := ids[i:end]
-		fetchResp, err := index.FetchVectors(ctx, batch)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch existing vectors: %w", err)
-		}
-		for id, vec := range fetchResp.Vectors {
-			if vec.Metadata != nil {
-				if hv, ok := vec.Metadata.Fields["content_hash"]; ok {
-					result[id] = hv.GetStringValue()
-				}
-			}
+	existing, err := Vectors.Fetch(ctx, namespace, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch existing vectors: %w", err)
+	}
+	for id, rec := range existing {
+		if hv, ok := rec.Metadata["content_hash"].(string); ok {
+			result[id] = hv
 		}
 	}
 	return result, nil
 }
 
-// storeChunksInPinecone stores text chunks as vectors in Pinecone (selective upsert)
-func storeChunksInPinecone(ctx context.Context, chunks []TextChunk, namespace string) error {
+// StoreStats summarises a selective upsert
+type StoreStats struct {
+	New       int `json:"new"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Upserted  int `json:"upserted"`
+}
+
+// storeChunksInPinecone stores text chunks as vectors in the vector store (selective upsert)
+func storeChunksInPinecone(ctx context.Context, chunks []TextChunk, namespace string) (StoreStats, error) {
 	log.Printf("=== STORING VECTORS (SELECTIVE) ===")
 	log.Printf("Namespace: %s", namespace)
 	log.Printf("Number of chunks: %d", len(chunks))
 
-	idx, err := PineconeClient.DescribeIndex(ctx, "mindmenu-index")
-	if err != nil {
-		return fmt.Errorf("failed to describe index: %w", err)
-	}
-
-	idxConnection, err := PineconeClient.Index(pinecone.NewIndexConnParams{
-		Host:      idx.Host,
-		Namespace: namespace,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create IndexConnection: %w", err)
-	}
-
 	// Build vectors with deterministic IDs and content hashes
-	vectors := make([]*pinecone.Vector, len(chunks))
+	records := make([]VectorRecord, len(chunks))
 	ids := make([]string, 0, len(chunks))
 
 	for i, chunk := range chunks {
@@ -138,54 +94,52 @@ func storeChunksInPinecone(ctx context.Context, chunks []TextChunk, namespace st
 		log.Printf("  Restaurant ID: %s", chunk.Metadata.RestaurantID)
 		log.Printf("  Branch ID: %s", chunk.Metadata.BranchID)
 
-		metadataMap := map[string]interface{}{
-			"restaurant_id": chunk.Metadata.RestaurantID,
-			"branch_id":     chunk.Metadata.BranchID,
-			"source":        chunk.Metadata.Source,
-			"category":      chunk.Metadata.Category,
-			"item_key":      chunk.Metadata.ItemKey,
-			"item_index":    chunk.Metadata.ItemIndex,
-			"text":          chunk.Text,
-			"content_hash":  contentHash,
+		records[i] = VectorRecord{
+			ID:     chunk.ID,
+			Values: chunk.Embedding,
+			Metadata: map[string]interface{}{
+				"restaurant_id": chunk.Metadata.RestaurantID,
+				"branch_id":     chunk.Metadata.BranchID,
+				"source":        chunk.Metadata.Source,
+				"category":      chunk.Metadata.Category,
+				"item_key":      chunk.Metadata.ItemKey,
+				"item_index":    chunk.Metadata.ItemIndex,
+				"text":          chunk.Text,
+				"content_hash":  contentHash,
+			},
 		}
-		metadata, err := structpb.NewStruct(metadataMap)
-		if err != nil {
-			return fmt.Errorf("failed to create metadata struct: %w", err)
+		for k, v := range availabilityMetadata(chunk.Metadata) {
+			records[i].Metadata[k] = v
 		}
-
-		vectors[i] = &pinecone.Vector{
-			Id:       chunk.ID,
-			Values:   &chunk.Embedding,
-			Metadata: metadata,
+		for k, v := range allergenMetadata(chunk.Metadata) {
+			records[i].Metadata[k] = v
+		}
+		for k, v := range queryMetadata(chunk.Metadata) {
+			records[i].Metadata[k] = v
 		}
 		ids = append(ids, chunk.ID)
 	}
 
 	// Fetch existing hashes to diff
-	existingHashes, err := fetchExistingHashes(ctx, idxConnection, ids)
+	existingHashes, err := fetchExistingHashes(ctx, namespace, ids)
 	if err != nil {
-		return err
+		return StoreStats{}, err
 	}
 
 	// Determine which vectors to upsert
-	var toUpsert []*pinecone.Vector
+	var toUpsert []VectorRecord
 	var newCount, updatedCount, skipped int
 
-	for _, v := range vectors {
-		var incomingHash string
-		if v.Metadata != nil {
-			if hv, ok := v.Metadata.Fields["content_hash"]; ok {
-				incomingHash = hv.GetStringValue()
-			}
-		}
-		existingHash, exists := existingHashes[v.Id]
+	for _, r := range records {
+		incomingHash, _ := r.Metadata["content_hash"].(string)
+		existingHash, exists := existingHashes[r.ID]
 		switch {
 		case !exists:
 			newCount++
-			toUpsert = append(toUpsert, v)
+			toUpsert = append(toUpsert, r)
 		case existingHash != incomingHash:
 			updatedCount++
-			toUpsert = append(toUpsert, v)
+			toUpsert = append(toUpsert, r)
 		default:
 			skipped++
 		}
@@ -194,23 +148,20 @@ func storeChunksInPinecone(ctx context.Context, chunks []TextChunk, namespace st
 	log.Printf("Diff results: new=%d, updated=%d, unchanged=%d", newCount, updatedCount, skipped)
 
 	// Upsert only changed/new vectors. Do NOT delete by default.
-	for i := 0; i < len(toUpsert); i += 100 {
-		end := i + 100
-		if end > len(toUpsert) {
-			end = len(toUpsert)
-		}
-		batch := toUpsert[i:end]
-		if len(batch) == 0 {
-			continue
+	if len(toUpsert) > 0 {
+		if err := Vectors.Upsert(ctx, namespace, toUpsert); err != nil {
+			return StoreStats{}, err
 		}
-		if _, err := idxConnection.UpsertVectors(ctx, batch); err != nil {
-			return fmt.Errorf("failed to upsert vectors: %w", err)
-		}
-		log.Printf("Upserted %d vectors to namespace '%s'", len(batch), namespace)
+		log.Printf("Upserted %d vectors to namespace '%s'", len(toUpsert), namespace)
 	}
 
 	log.Printf("=== STORAGE COMPLETE ===")
-	return nil
+	return StoreStats{
+		New:       newCount,
+		Updated:   updatedCount,
+		Unchanged: skipped,
+		Upserted:  len(toUpsert),
+	}, nil
 }
 
 // Optional utility: delete specific vectors by ID
@@ -218,113 +169,197 @@ func deleteVectorsByID(ctx context.Context, namespace string, ids []string) erro
 	if len(ids) == 0 {
 		return nil
 	}
-	idx, err := PineconeClient.DescribeIndex(ctx, "mindmenu-index")
-	if err != nil {
-		return fmt.Errorf("failed to describe index: %w", err)
-	}
-	index, err := PineconeClient.Index(pinecone.NewIndexConnParams{
-		Host:      idx.Host,
-		Namespace: namespace,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create IndexConnection: %w", err)
-	}
-	for i := 0; i < len(ids); i += 100 {
-		end := i + 100
-		if end > len(ids) {
-			end = len(ids)
-		}
-		batch := ids[i:end]
-		if err := index.DeleteVectorsById(ctx, batch); err != nil {
-			return fmt.Errorf("failed to delete vectors: %w", err)
-		}
-		log.Printf("Deleted %d vectors from namespace '%s'", len(batch), namespace)
+	if err := Vectors.Delete(ctx, namespace, ids); err != nil {
+		return err
 	}
+	log.Printf("Deleted %d vectors from namespace '%s'", len(ids), namespace)
 	return nil
 }
 
-// queryChatbotInPinecone queries the vector database and generates AI responses
-func queryChatbotInPinecone(ctx context.Context, embedding []float32, namespace string, userQuestion string) (gin.H, error) {
-	log.Printf("=== QUERYING VECTORS ===")
-	log.Printf("Query namespace: %s", namespace)
-	log.Printf("User question: %s", userQuestion)
-	log.Printf("Embedding length: %d", len(embedding))
+// PruneResult reports which vectors in a namespace are no longer produced by the current content
+type PruneResult struct {
+	Existing int      `json:"existing"`
+	Kept     int      `json:"kept"`
+	StaleIDs []string `json:"stale_ids"`
+	Deleted  int      `json:"deleted"`
+	DryRun   bool     `json:"dry_run"`
+}
 
-	// Describe the index to get the host
-	idx, err := PineconeClient.DescribeIndex(ctx, "mindmenu-index")
-	if err != nil {
-		return nil, fmt.Errorf("failed to describe index: %w", err)
+// pruneStaleVectors deletes vectors whose deterministic ID is not produced by chunks.
+// Chunk metadata must already carry RestaurantID and BranchID. With dryRun nothing is deleted.
+func pruneStaleVectors(ctx context.Context, namespace string, chunks []TextChunk, dryRun bool) (PruneResult, error) {
+	// An empty chunk set would wipe the namespace; that is never what a reindex means
+	if len(chunks) == 0 {
+		return PruneResult{}, fmt.Errorf("refusing to prune: content produced no chunks")
 	}
 
-	// Create index connection WITH namespace
-	index, err := PineconeClient.Index(pinecone.NewIndexConnParams{
-		Host:      idx.Host,
-		Namespace: namespace,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create IndexConnection for Host: %w", err)
+	keep := make(map[string]struct{}, len(chunks))
+	for _, chunk := range chunks {
+		keep[computeDeterministicID(chunk.Metadata)] = struct{}{}
 	}
 
-	// Query the index
-	queryResp, err := index.QueryByVectorValues(ctx, &pinecone.QueryByVectorValuesRequest{
-		Vector:          embedding,
-		TopK:            5,
-		IncludeMetadata: true,
-	})
-
+	existing, err := Vectors.ListIDs(ctx, namespace)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query Pinecone: %w", err)
+		return PruneResult{}, fmt.Errorf("failed to list vectors: %w", err)
+	}
+
+	result := PruneResult{Existing: len(existing), StaleIDs: []string{}, DryRun: dryRun}
+	for _, id := range existing {
+		if _, ok := keep[id]; ok {
+			result.Kept++
+			continue
+		}
+		result.StaleIDs = append(result.StaleIDs, id)
 	}
+	log.Printf("Prune %s: existing=%d, kept=%d, stale=%d, dry_run=%v", namespace, result.Existing, result.Kept, len(result.StaleIDs), dryRun)
 
-	log.Printf("Query returned %d matches", len(queryResp.Matches))
+	if dryRun || len(result.StaleIDs) == 0 {
+		return result, nil
+	}
+	if err := deleteVectorsByID(ctx, namespace, result.StaleIDs); err != nil {
+		return result, fmt.Errorf("failed to delete stale vectors: %w", err)
+	}
+	result.Deleted = len(result.StaleIDs)
+	return result, nil
+}
 
+// contextFromMatches extracts the chunk text of each match, best match first
+func contextFromMatches(matches []VectorMatch) []string {
 	var contextTexts []string
-	var ids []string
-	for _, match := range queryResp.Matches {
-		ids = append(ids, match.Vector.Id)
-		log.Printf("Match ID: %s, Score: %f", match.Vector.Id, match.Score)
+	for _, match := range matches {
+		log.Printf("Match ID: %s, Score: %f", match.ID, match.Score)
+		if text, ok := match.Metadata["text"].(string); ok && text != "" {
+			log.Printf("Found text: %s", text)
+			contextTexts = append(contextTexts, text)
+		}
 	}
+	return contextTexts
+}
+
+// queryOptions carries per-request retrieval settings
+type queryOptions struct {
+	Now      time.Time         // the branch's local time; availability filtering uses its weekday and clock
+	Profile  string            // branch profile block, see branchProfileBlock
+	Settings RetrievalSettings // the chatbot's settings with defaults applied
+}
 
-	// Fix the FetchVectors call
-	if len(ids) > 0 {
-		fetchResp, err := index.FetchVectors(ctx, ids)
+// newQueryOptions prepares the options for a question asked at branch now
+func newQueryOptions(branch Branch, settings RetrievalSettings) queryOptions {
+	now := time.Now().In(branchLocation(branch))
+	return queryOptions{Now: now, Profile: branchProfileBlock(branch, now), Settings: settings}
+}
+
+// retrieval is the knowledge found for a question
+type retrieval struct {
+	Matches        []VectorMatch
+	Context        []string // chunks served now, or every match when the index has no availability metadata
+	NotServed      []string // relevant scheduled chunks that are not served at opts.Now
+	Filtered       bool
+	Filters        queryFilters // course, dietary and price filters read from the question
+	FilterFallback bool         // the filters matched nothing, so retrieval ignored them
+	Guard          allergenGuard
+	Safe           []string // items whose tags satisfy an allergen or dietary question
+}
+
+// retrieveContext queries the chunks served at opts.Now, plus a few relevant ones that are not, so the
+// model can say when those are available. Both queries are narrowed by the filters understandQuery reads
+// from the question, and repeated without them when nothing matches. Indexes built before availability
+// metadata match neither filter and are queried unfiltered. Allergen and dietary questions also fetch
+// the items their tags verify.
+func retrieveContext(ctx context.Context, namespace, question string, embedding []float32, opts queryOptions) (retrieval, error) {
+	r := retrieval{Filtered: true, Filters: understandQuery(question)}
+	matches, notServed, err := queryAvailability(ctx, namespace, embedding, opts, r.Filters.metadataFilter())
+	if err != nil {
+		return retrieval{}, err
+	}
+	if len(matches) == 0 && len(notServed) == 0 && !r.Filters.Empty() {
+		log.Printf("No matches for query filters %+v in %s, retrying without them", r.Filters, namespace)
+		if matches, notServed, err = queryAvailability(ctx, namespace, embedding, opts, nil); err != nil {
+			return retrieval{}, err
+		}
+		r.FilterFallback = true
+	}
+
+	if len(matches) == 0 && len(notServed) == 0 {
+		log.Printf("No availability metadata in %s, querying unfiltered", namespace)
+		matches, err = Vectors.Query(ctx, namespace, embedding, opts.Settings.TopK, nil)
 		if err != nil {
-			log.Printf("Fetch error: %v", err)
-			return gin.H{
-				"context": []string{},
-				"message": "This is where you would use Gemini to generate a response",
-				"error":   "Failed to fetch vector metadata",
-			}, nil
+			return retrieval{}, fmt.Errorf("failed to query vector store: %w", err)
 		}
+		matches = aboveMinScore(matches, opts.Settings.MinScore)
+		r.Filtered = false
+	}
+	r.Matches = matches
+	r.Context = limitContext(contextFromMatches(matches), opts.Settings.MaxContextChars)
+	r.NotServed = contextFromMatches(notServed)
+	if r.Guard = newAllergenGuard(question); r.Guard.Active {
+		if r.Safe, err = r.Guard.safeItems(ctx, namespace, embedding, opts); err != nil {
+			return retrieval{}, err
+		}
+	}
+	log.Printf("Query returned %d matches, %d not served at %s", len(matches), len(notServed), describeLocalTime(opts.Now))
+	return r, nil
+}
 
-		log.Printf("Fetched %d vectors", len(fetchResp.Vectors))
+// queryAvailability runs the served-now and not-served-now queries, each narrowed by filter when set.
+// Matches below the chatbot's min_score are dropped.
+func queryAvailability(ctx context.Context, namespace string, embedding []float32, opts queryOptions, filter map[string]interface{}) ([]VectorMatch, []VectorMatch, error) {
+	matches, err := Vectors.Query(ctx, namespace, embedding, opts.Settings.TopK, andFilter(servedNowFilter(opts.Now), filter))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query vector store: %w", err)
+	}
+	notServed, err := Vectors.Query(ctx, namespace, embedding, 3, andFilter(notServedNowFilter(opts.Now), filter))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query vector store: %w", err)
+	}
+	return aboveMinScore(matches, opts.Settings.MinScore), aboveMinScore(notServed, opts.Settings.MinScore), nil
+}
 
-		for _, vec := range fetchResp.Vectors {
-			if vec.Metadata != nil {
-				if textVal, ok := vec.Metadata.Fields["text"]; ok {
-					text := textVal.GetStringValue()
-					log.Printf("Found text: %s", text)
-					contextTexts = append(contextTexts, text)
-				}
-			}
-		}
-	} else {
+// queryNotes is the prompt section after the knowledge: local time and availability, and the allergen rules
+func queryNotes(opts queryOptions, found retrieval) string {
+	notes := availabilityNote(opts.Now, found.NotServed)
+	if found.Guard.Active {
+		notes += "\n\n" + found.Guard.note(found.Safe)
+	}
+	return notes
+}
+
+// queryChatbotInPinecone queries the vector database and generates AI responses
+func queryChatbotInPinecone(ctx context.Context, embedding []float32, namespace string, userQuestion string, opts queryOptions) (gin.H, error) {
+	log.Printf("=== QUERYING VECTORS ===")
+	log.Printf("Query namespace: %s", namespace)
+	log.Printf("User question: %s", userQuestion)
+	log.Printf("Embedding length: %d", len(embedding))
+
+	// Query the vector store; matches carry their metadata
+	found, err := retrieveContext(ctx, namespace, userQuestion, embedding, opts)
+	if err != nil {
+		return nil, err
+	}
+	matches, contextTexts := found.Matches, found.Context
+	if len(matches) == 0 {
 		log.Printf("No matching vectors found")
 	}
 
 	// Generate natural language response using the context
 	var finalResponse string
-	if len(contextTexts) > 0 {
+	tagged, missingTags := found.Guard.assess(matches, found.Safe)
+	refused := found.Guard.Active && !tagged
+	if refused {
+		// Allergen questions are only answered from explicit tags
+		finalResponse = found.Guard.refusal()
+	} else if len(contextTexts) > 0 || len(found.NotServed) > 0 || opts.Profile != "" {
 		// Use improved prompt based on your Python reference
-		prompt := createRestaurantPrompt(userQuestion, contextTexts)
+		prompt := createRestaurantPrompt(userQuestion, opts.Profile, contextTexts, queryNotes(opts, found))
 
-		response, err := generateResponseWithGemini(ctx, prompt)
+		response, err := chatModelFor(opts.Settings.Model).Generate(ctx, prompt)
 		if err != nil {
 			log.Printf("Error generating response: %v", err)
 			finalResponse = "I found some information but couldn't generate a proper response. Here's what I found: " + strings.Join(contextTexts, "; ")
 		} else {
 			finalResponse = response
 		}
+		finalResponse, _ = found.Guard.finish(finalResponse, missingTags, nil)
 	} else {
 		finalResponse = "I couldn't find any relevant information to answer your question."
 	}
@@ -335,14 +370,29 @@ func queryChatbotInPinecone(ctx context.Context, embedding []float32, namespace
 		"response": finalResponse,
 		"context":  contextTexts,
 		"debug": gin.H{
-			"namespace":     namespace,
-			"matches":       len(queryResp.Matches),
-			"context_count": len(contextTexts),
+			"namespace":      namespace,
+			"matches":        len(matches),
+			"context_count":  len(contextTexts),
+			"local_time":     describeLocalTime(opts.Now),
+			"not_served":     found.NotServed,
+			"filtered":       found.Filtered,
+			"query_filters":  found.Filters.debug(found.FilterFallback),
+			"allergen_guard": found.Guard.debug(found.Safe, refused),
+			"settings":       opts.Settings.debug(),
 		},
 	}, nil
 }
 
-func queryChatbotInPineconeWithHistory(ctx context.Context, embedding []float32, namespace string, userQuestion string, history []ChatHistory, language string) (gin.H, error) {
+// errAnswerInterrupted is returned when the chat model fails after part of the answer was streamed
+var errAnswerInterrupted = errors.New("answer interrupted")
+
+func queryChatbotInPineconeWithHistory(ctx context.Context, embedding []float32, namespace string, userQuestion string, history []ChatHistory, language string, opts queryOptions) (gin.H, error) {
+	return streamChatbotInPineconeWithHistory(ctx, embedding, namespace, userQuestion, history, language, opts, nil)
+}
+
+// streamChatbotInPineconeWithHistory answers like queryChatbotInPineconeWithHistory but, when onToken is set,
+// streams the generated answer through it. Fallback answers are sent as a single token.
+func streamChatbotInPineconeWithHistory(ctx context.Context, embedding []float32, namespace string, userQuestion string, history []ChatHistory, language string, opts queryOptions, onToken func(string) error) (gin.H, error) {
 	log.Printf("=== QUERYING VECTORS WITH HISTORY ===")
 	log.Printf("Query namespace: %s", namespace)
 	log.Printf("User question: %s", userQuestion)
@@ -350,83 +400,68 @@ func queryChatbotInPineconeWithHistory(ctx context.Context, embedding []float32,
 	log.Printf("History items: %d", len(history))
 	log.Printf("Embedding length: %d", len(embedding))
 
-	// Describe the index to get the host
-	idx, err := PineconeClient.DescribeIndex(ctx, "mindmenu-index")
+	// Query the vector store; matches carry their metadata
+	found, err := retrieveContext(ctx, namespace, userQuestion, embedding, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to describe index: %w", err)
+		return nil, err
 	}
-
-	// Create index connection WITH namespace
-	index, err := PineconeClient.Index(pinecone.NewIndexConnParams{
-		Host:      idx.Host,
-		Namespace: namespace,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create IndexConnection for Host: %w", err)
-	}
-
-	// Query the index
-	queryResp, err := index.QueryByVectorValues(ctx, &pinecone.QueryByVectorValuesRequest{
-		Vector:          embedding,
-		TopK:            5,
-		IncludeMetadata: true,
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to query Pinecone: %w", err)
-	}
-
-	log.Printf("Query returned %d matches", len(queryResp.Matches))
-
-	var contextTexts []string
-	var ids []string
-	for _, match := range queryResp.Matches {
-		ids = append(ids, match.Vector.Id)
-		log.Printf("Match ID: %s, Score: %f", match.Vector.Id, match.Score)
-	}
-
-	// Fetch vector metadata
-	if len(ids) > 0 {
-		fetchResp, err := index.FetchVectors(ctx, ids)
-		if err != nil {
-			log.Printf("Fetch error: %v", err)
-			return gin.H{
-				"context": []string{},
-				"message": "Failed to fetch vector metadata",
-				"error":   "Failed to fetch vector metadata",
-			}, nil
-		}
-
-		log.Printf("Fetched %d vectors", len(fetchResp.Vectors))
-
-		for _, vec := range fetchResp.Vectors {
-			if vec.Metadata != nil {
-				if textVal, ok := vec.Metadata.Fields["text"]; ok {
-					text := textVal.GetStringValue()
-					log.Printf("Found text: %s", text)
-					contextTexts = append(contextTexts, text)
-				}
-			}
-		}
-	} else {
+	matches, contextTexts := found.Matches, found.Context
+	if len(matches) == 0 {
 		log.Printf("No matching vectors found")
 	}
 
 	// Generate natural language response using the context and history
+	send := func(text string) error {
+		if onToken == nil {
+			return nil
+		}
+		return onToken(text)
+	}
 	var finalResponse string
-	if len(contextTexts) > 0 {
+	tagged, missingTags := found.Guard.assess(matches, found.Safe)
+	refused := found.Guard.Active && !tagged
+	if refused {
+		// Allergen questions are only answered from explicit tags
+		finalResponse = found.Guard.refusal()
+		if err := send(finalResponse); err != nil {
+			return nil, err
+		}
+	} else if len(contextTexts) > 0 || len(found.NotServed) > 0 || opts.Profile != "" {
 		// Use the enhanced prompt with history
-		prompt := createRestaurantPromptWithHistory(userQuestion, contextTexts, history, language)
-
-		response, err := generateResponseWithGemini(ctx, prompt)
-		if err != nil {
+		prompt := createRestaurantPromptWithHistory(userQuestion, opts.Profile, contextTexts, history, opts.Settings.HistoryTurns, language, queryNotes(opts, found))
+
+		var response string
+		streamed := false
+		if onToken != nil {
+			response, err = chatModelFor(opts.Settings.Model).GenerateStream(ctx, prompt, func(token string) error {
+				streamed = true
+				return onToken(token)
+			})
+		} else {
+			response, err = chatModelFor(opts.Settings.Model).Generate(ctx, prompt)
+		}
+		switch {
+		case err != nil && streamed:
+			// The guest has already seen part of the answer, which must not be stored as if it were complete
+			log.Printf("Error streaming response: %v", err)
+			return nil, fmt.Errorf("%w: %v", errAnswerInterrupted, err)
+		case err != nil:
 			log.Printf("Error generating response: %v", err)
 			finalResponse = "I found some information but couldn't generate a proper response. Here's what I found: " + strings.Join(contextTexts, "; ")
-		} else {
+			if err := send(finalResponse); err != nil {
+				return nil, err
+			}
+		default:
 			finalResponse = response
 		}
+		if finalResponse, err = found.Guard.finish(finalResponse, missingTags, onToken); err != nil {
+			return nil, err
+		}
 	} else {
 		finalResponse = "I couldn't find any relevant information to answer your question."
+		if err := send(finalResponse); err != nil {
+			return nil, err
+		}
 	}
 
 	log.Printf("=== QUERY WITH HISTORY COMPLETE ===")
@@ -435,11 +470,17 @@ func queryChatbotInPineconeWithHistory(ctx context.Context, embedding []float32,
 		"response": finalResponse,
 		"context":  contextTexts,
 		"debug": gin.H{
-			"namespace":     namespace,
-			"matches":       len(queryResp.Matches),
-			"context_count": len(contextTexts),
-			"history_count": len(history),
-			"language":      language,
+			"namespace":      namespace,
+			"matches":        len(matches),
+			"context_count":  len(contextTexts),
+			"history_count":  len(history),
+			"language":       language,
+			"local_time":     describeLocalTime(opts.Now),
+			"not_served":     found.NotServed,
+			"filtered":       found.Filtered,
+			"query_filters":  found.Filters.debug(found.FilterFallback),
+			"allergen_guard": found.Guard.debug(found.Safe, refused),
+			"settings":       opts.Settings.debug(),
 		},
 	}, nil
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// VectorRecord is a single stored vector together with its metadata
+type VectorRecord struct {
+	ID       string                 `json:"id"`
+	Values   []float32              `json:"values"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// VectorMatch is a VectorRecord returned by a similarity query
+type VectorMatch struct {
+	VectorRecord
+	Score float32 `json:"score"`
+}
+
+// VectorStore is the storage backend for chunk embeddings.
+// Every operation is scoped to a namespace; one namespace holds one branch's vectors.
+// Filters use the Pinecone metadata filter syntax ($eq, $ne, $in, $nin, $gt, $gte, $lt, $lte, $exists, $and, $or).
+type VectorStore interface {
+	Upsert(ctx context.Context, namespace string, records []VectorRecord) error
+	Fetch(ctx context.Context, namespace string, ids []string) (map[string]VectorRecord, error)
+	Delete(ctx context.Context, namespace string, ids []string) error
+	Query(ctx context.Context, namespace string, vector []float32, topK int, filter map[string]interface{}) ([]VectorMatch, error)
+	ListIDs(ctx context.Context, namespace string) ([]string, error)
+	// DeleteNamespace removes every vector in namespace; a missing namespace is not an error
+	DeleteNamespace(ctx context.Context, namespace string) error
+}
+
+// Vectors is the vector store selected at startup
+var Vectors VectorStore
+
+// newVectorStoreFromEnv builds the vector store named by VECTOR_STORE ("pinecone" or "memory")
+func newVectorStoreFromEnv(ctx context.Context) (VectorStore, error) {
+	kind := strings.ToLower(strings.TrimSpace(os.Getenv("VECTOR_STORE")))
+	switch kind {
+	case "", "pinecone":
+		return newPineconeStore(ctx, os.Getenv("PINECONE_API_KEY"), pineconeIndexName())
+	case "memory", "local":
+		return newMemoryStore(os.Getenv("VECTOR_STORE_PATH"))
+	default:
+		return nil, fmt.Errorf("unknown VECTOR_STORE %q", kind)
+	}
+}
+
+// batchStrings splits ids into slices of at most size elements
+func batchStrings(ids []string, size int) [][]string {
+	var batches [][]string
+	for i := 0; i < len(ids); i += size {
+		end := min(i+size, len(ids))
+		batches = append(batches, ids[i:end])
+	}
+	return batches
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// memoryStore is an in-process VectorStore with brute-force cosine search.
+// When path is set the whole store is persisted to a JSON file after every write,
+// which is plenty for a single restaurant's menus and lets the backend run offline.
+type memoryStore struct {
+	mu         sync.RWMutex
+	path       string
+	namespaces map[string]map[string]VectorRecord
+}
+
+// newMemoryStore creates an in-memory store, loading path if it exists
+func newMemoryStore(path string) (*memoryStore, error) {
+	s := &memoryStore{
+		path:       path,
+		namespaces: make(map[string]map[string]VectorRecord),
+	}
+	if path == "" {
+		log.Printf("Using in-memory vector store (not persisted)")
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		log.Printf("Using on-disk vector store at %s (new)", path)
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read vector store file: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.namespaces); err != nil {
+		return nil, fmt.Errorf("failed to parse vector store file: %w", err)
+	}
+	log.Printf("Using on-disk vector store at %s (%d namespaces)", path, len(s.namespaces))
+	return s, nil
+}
+
+// persist writes the store to disk; callers must hold the write lock
+func (s *memoryStore) persist() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.namespaces)
+	if err != nil {
+		return fmt.Errorf("failed to encode vector store: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".vectors-*")
+	if err != nil {
+		return fmt.Errorf("failed to write vector store: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write vector store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write vector store: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *memoryStore) Upsert(ctx context.Context, namespace string, records []VectorRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns := s.namespaces[namespace]
+	if ns == nil {
+		ns = make(map[string]VectorRecord)
+		s.namespaces[namespace] = ns
+	}
+	for _, r := range records {
+		// Round-trip metadata through JSON so stored values have the same
+		// shape (float64 numbers, []interface{} lists) as those read back from Pinecone
+		meta, err := normalizeMetadata(r.Metadata)
+		if err != nil {
+			return err
+		}
+		ns[r.ID] = VectorRecord{
+			ID:       r.ID,
+			Values:   append([]float32(nil), r.Values...),
+			Metadata: meta,
+		}
+	}
+	return s.persist()
+}
+
+func (s *memoryStore) Fetch(ctx context.Context, namespace string, ids []string) (map[string]VectorRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]VectorRecord, len(ids))
+	ns := s.namespaces[namespace]
+	for _, id := range ids {
+		if r, ok := ns[id]; ok {
+			result[id] = r
+		}
+	}
+	return result, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, namespace string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns := s.namespaces[namespace]
+	for _, id := range ids {
+		delete(ns, id)
+	}
+	if len(ns) == 0 {
+		delete(s.namespaces, namespace)
+	}
+	return s.persist()
+}
+
+func (s *memoryStore) DeleteNamespace(ctx context.Context, namespace string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.namespaces[namespace]; !ok {
+		return nil
+	}
+	delete(s.namespaces, namespace)
+	return s.persist()
+}
+
+func (s *memoryStore) Query(ctx context.Context, namespace string, vector []float32, topK int, filter map[string]interface{}) ([]VectorMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []VectorMatch
+	for _, r := range s.namespaces[namespace] {
+		if len(filter) > 0 && !matchMetadataFilter(r.Metadata, filter) {
+			continue
+		}
+		if len(r.Values) != len(vector) {
+			continue
+		}
+		matches = append(matches, VectorMatch{
+			VectorRecord: r,
+			Score:        cosineSimilarity(vector, r.Values),
+		})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if topK > 0 && len(matches) > topK {
+		matches = matches[:topK]
+	}
+	return matches, nil
+}
+
+func (s *memoryStore) ListIDs(ctx context.Context, namespace string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.namespaces[namespace]))
+	for id := range s.namespaces[namespace] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// cosineSimilarity returns the cosine of the angle between two equal-length vectors
+func cosineSimilarity(a, b []float32) float32 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(na) * math.Sqrt(nb)))
+}
+
+// normalizeMetadata round-trips metadata through JSON
+func normalizeMetadata(meta map[string]interface{}) (map[string]interface{}, error) {
+	if meta == nil {
+		return map[string]interface{}{}, nil
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	return out, nil
+}
+
+// matchMetadataFilter evaluates a Pinecone-style metadata filter against metadata
+func matchMetadataFilter(meta map[string]interface{}, filter map[string]interface{}) bool {
+	for key, cond := range filter {
+		switch key {
+		case "$and":
+			for _, sub := range asFilterList(cond) {
+				if !matchMetadataFilter(meta, sub) {
+					return false
+				}
+			}
+		case "$or":
+			matched := false
+			for _, sub := range asFilterList(cond) {
+				if matchMetadataFilter(meta, sub) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		default:
+			if !matchFieldCondition(meta[key], cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchFieldCondition evaluates one field's condition; a bare value means $eq
+func matchFieldCondition(value interface{}, cond interface{}) bool {
+	ops, ok := cond.(map[string]interface{})
+	if !ok {
+		return metadataContains(value, cond)
+	}
+	for op, arg := range ops {
+		switch op {
+		case "$eq":
+			if !metadataContains(value, arg) {
+				return false
+			}
+		case "$ne":
+			if value == nil || metadataContains(value, arg) {
+				return false
+			}
+		case "$in":
+			found := false
+			for _, a := range asValueList(arg) {
+				if metadataContains(value, a) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		case "$nin":
+			for _, a := range asValueList(arg) {
+				if metadataContains(value, a) {
+					return false
+				}
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			v, ok1 := toFloat(value)
+			a, ok2 := toFloat(arg)
+			if !ok1 || !ok2 {
+				return false
+			}
+			switch op {
+			case "$gt":
+				if !(v > a) {
+					return false
+				}
+			case "$gte":
+				if !(v >= a) {
+					return false
+				}
+			case "$lt":
+				if !(v < a) {
+					return false
+				}
+			case "$lte":
+				if !(v <= a) {
+					return false
+				}
+			}
+		case "$exists":
+			want, _ := arg.(bool)
+			if (value != nil) != want {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// metadataContains reports whether value equals want, or contains it when value is a list
+func metadataContains(value, want interface{}) bool {
+	if list, ok := value.([]interface{}); ok {
+		for _, v := range list {
+			if scalarEqual(v, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return scalarEqual(value, want)
+}
+
+func scalarEqual(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	return a == b
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func asFilterList(v interface{}) []map[string]interface{} {
+	var out []map[string]interface{}
+	switch list := v.(type) {
+	case []map[string]interface{}:
+		return list
+	case []interface{}:
+		for _, item := range list {
+			if m, ok := item.(map[string]interface{}); ok {
+				out = append(out, m)
+			}
+		}
+	}
+	return out
+}
+
+func asValueList(v interface{}) []interface{} {
+	switch list := v.(type) {
+	case []interface{}:
+		return list
+	case []string:
+		out := make([]interface{}, len(list))
+		for i, s := range list {
+			out[i] = s
+		}
+		return out
+	}
+	return []interface{}{v}
+}
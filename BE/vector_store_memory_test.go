@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryStoreQueryRanking(t *testing.T) {
+	ctx := context.Background()
+	s, err := newMemoryStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Upsert(ctx, "ns", []VectorRecord{
+		{ID: "exact", Values: []float32{1, 0, 0}},
+		{ID: "close", Values: []float32{0.9, 0.1, 0}},
+		{ID: "tie", Values: []float32{0.9, 0.1, 0}},
+		{ID: "opposite", Values: []float32{-1, 0, 0}},
+		{ID: "wrong-dim", Values: []float32{1, 0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upsert(ctx, "other", []VectorRecord{{ID: "elsewhere", Values: []float32{1, 0, 0}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		topK int
+		want []string
+	}{
+		{0, []string{"exact", "close", "tie", "opposite"}},
+		{2, []string{"exact", "close"}},
+		{10, []string{"exact", "close", "tie", "opposite"}},
+	}
+	for _, tt := range tests {
+		matches, err := s.Query(ctx, "ns", []float32{1, 0, 0}, tt.topK, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, m := range matches {
+			got = append(got, m.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("topK %d: got %v, want %v", tt.topK, got, tt.want)
+		}
+	}
+
+	matches, _ := s.Query(ctx, "ns", []float32{1, 0, 0}, 1, nil)
+	if matches[0].Score < 0.999 {
+		t.Errorf("identical vector scored %v, want 1", matches[0].Score)
+	}
+	if matches, _ := s.Query(ctx, "missing", []float32{1, 0, 0}, 5, nil); len(matches) != 0 {
+		t.Errorf("missing namespace returned %d matches", len(matches))
+	}
+}
+
+func TestMatchMetadataFilter(t *testing.T) {
+	meta, err := normalizeMetadata(map[string]interface{}{
+		"category":  "Drinks",
+		"price":     25000,
+		"allergens": []string{"milk", "soy"},
+		"available": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := func(items ...interface{}) []interface{} { return items }
+
+	tests := []struct {
+		name   string
+		filter map[string]interface{}
+		want   bool
+	}{
+		{"bare value", map[string]interface{}{"category": "Drinks"}, true},
+		{"bare value mismatch", map[string]interface{}{"category": "Food"}, false},
+		{"$eq int against float", map[string]interface{}{"price": map[string]interface{}{"$eq": 25000}}, true},
+		{"$eq list member", map[string]interface{}{"allergens": map[string]interface{}{"$eq": "milk"}}, true},
+		{"$eq bool", map[string]interface{}{"available": map[string]interface{}{"$eq": true}}, true},
+		{"$ne", map[string]interface{}{"category": map[string]interface{}{"$ne": "Food"}}, true},
+		{"$ne equal", map[string]interface{}{"category": map[string]interface{}{"$ne": "Drinks"}}, false},
+		{"$ne missing field", map[string]interface{}{"course": map[string]interface{}{"$ne": "Main"}}, false},
+		{"$in", map[string]interface{}{"category": map[string]interface{}{"$in": []string{"Food", "Drinks"}}}, true},
+		{"$in miss", map[string]interface{}{"category": map[string]interface{}{"$in": list("Food")}}, false},
+		{"$in list field", map[string]interface{}{"allergens": map[string]interface{}{"$in": list("nuts", "soy")}}, true},
+		{"$nin", map[string]interface{}{"allergens": map[string]interface{}{"$nin": list("nuts", "eggs")}}, true},
+		{"$nin hit", map[string]interface{}{"allergens": map[string]interface{}{"$nin": list("milk")}}, false},
+		{"$gt", map[string]interface{}{"price": map[string]interface{}{"$gt": 25000}}, false},
+		{"$gte", map[string]interface{}{"price": map[string]interface{}{"$gte": 25000}}, true},
+		{"$lt", map[string]interface{}{"price": map[string]interface{}{"$lt": 30000.5}}, true},
+		{"$lte", map[string]interface{}{"price": map[string]interface{}{"$lte": 24999}}, false},
+		{"range", map[string]interface{}{"price": map[string]interface{}{"$gte": 20000, "$lte": 30000}}, true},
+		{"range on string", map[string]interface{}{"category": map[string]interface{}{"$gte": 1}}, false},
+		{"$exists", map[string]interface{}{"price": map[string]interface{}{"$exists": true}}, true},
+		{"$exists false", map[string]interface{}{"course": map[string]interface{}{"$exists": false}}, true},
+		{"unknown operator", map[string]interface{}{"price": map[string]interface{}{"$regex": "2"}}, false},
+		{"$and", map[string]interface{}{"$and": list(
+			map[string]interface{}{"category": "Drinks"},
+			map[string]interface{}{"price": map[string]interface{}{"$lt": 10000}},
+		)}, false},
+		{"$or", map[string]interface{}{"$or": list(
+			map[string]interface{}{"category": "Food"},
+			map[string]interface{}{"price": map[string]interface{}{"$lt": 30000}},
+		)}, true},
+		{"$or none", map[string]interface{}{"$or": []map[string]interface{}{
+			{"category": "Food"},
+			{"allergens": map[string]interface{}{"$in": list("nuts")}},
+		}}, false},
+		{"nested", map[string]interface{}{"$and": list(
+			map[string]interface{}{"$or": list(
+				map[string]interface{}{"category": "Food"},
+				map[string]interface{}{"category": "Drinks"},
+			)},
+			map[string]interface{}{"allergens": map[string]interface{}{"$nin": list("nuts")}},
+		)}, true},
+	}
+	for _, tt := range tests {
+		if got := matchMetadataFilter(meta, tt.filter); got != tt.want {
+			t.Errorf("%s: matchMetadataFilter(%v) = %v, want %v", tt.name, tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestScalarEqual(t *testing.T) {
+	tests := []struct {
+		a, b interface{}
+		want bool
+	}{
+		{float64(3), 3, true},
+		{float64(3), float32(3), true},
+		{int64(3), 3.0, true},
+		{float64(3), "3", false},
+		{"a", "a", true},
+		{"a", "b", false},
+		{true, true, true},
+		{true, 1, false},
+		{nil, nil, true},
+	}
+	for _, tt := range tests {
+		if got := scalarEqual(tt.a, tt.b); got != tt.want {
+			t.Errorf("scalarEqual(%#v, %#v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreFilteredQuery(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newMemoryStore("")
+	err := s.Upsert(ctx, "ns", []VectorRecord{
+		{ID: "latte", Values: []float32{1, 0}, Metadata: map[string]interface{}{"allergens": []string{"milk"}, "price": 30}},
+		{ID: "tea", Values: []float32{0.8, 0.2}, Metadata: map[string]interface{}{"price": 15}},
+		{ID: "cake", Values: []float32{0.5, 0.5}, Metadata: map[string]interface{}{"allergens": []string{"eggs", "milk"}, "price": 20}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := map[string]interface{}{
+		"allergens": map[string]interface{}{"$nin": []string{"milk"}},
+		"price":     map[string]interface{}{"$lte": 20},
+	}
+	matches, err := s.Query(ctx, "ns", []float32{1, 0}, 5, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].ID != "tea" {
+		t.Errorf("filtered query = %+v, want only tea", matches)
+	}
+}
+
+func TestMemoryStorePersistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vectors.json")
+	s, err := newMemoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Upsert(ctx, "b1", []VectorRecord{
+		{ID: "a", Values: []float32{1, 2}, Metadata: map[string]interface{}{"price": 12, "tags": []string{"vegan"}}},
+		{ID: "b", Values: []float32{3, 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upsert(ctx, "b2", []VectorRecord{{ID: "c", Values: []float32{5, 6}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "b1", []string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := newMemoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reloaded.Fetch(ctx, "b1", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VectorRecord{"a": {
+		ID:       "a",
+		Values:   []float32{1, 2},
+		Metadata: map[string]interface{}{"price": float64(12), "tags": []interface{}{"vegan"}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded b1 = %+v, want %+v", got, want)
+	}
+	if ids, _ := reloaded.ListIDs(ctx, "b2"); !reflect.DeepEqual(ids, []string{"c"}) {
+		t.Errorf("reloaded b2 ids = %v, want [c]", ids)
+	}
+
+	// Deleting a namespace is persisted too, and deleting it again is not an error
+	if err := reloaded.DeleteNamespace(ctx, "b2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.DeleteNamespace(ctx, "b2"); err != nil {
+		t.Errorf("second DeleteNamespace: %v", err)
+	}
+	again, err := newMemoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := again.ListIDs(ctx, "b2"); len(ids) != 0 {
+		t.Errorf("deleted namespace still has ids %v", ids)
+	}
+	if ids, _ := again.ListIDs(ctx, "b1"); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Errorf("b1 ids = %v, want [a]", ids)
+	}
+}
+
+func TestMemoryStoreListIDs(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newMemoryStore("")
+	s.Upsert(ctx, "ns", []VectorRecord{{ID: "b"}, {ID: "c"}, {ID: "a"}})
+	ids, err := s.ListIDs(ctx, "ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "b", "c"}) {
+		t.Errorf("ListIDs = %v, want sorted ids", ids)
+	}
+
+	// Removing the last vector drops the namespace
+	s.Delete(ctx, "ns", ids)
+	if _, ok := s.namespaces["ns"]; ok {
+		t.Error("empty namespace was kept")
+	}
+	if ids, _ := s.ListIDs(ctx, "missing"); len(ids) != 0 {
+		t.Errorf("missing namespace ids = %v", ids)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pinecone-io/go-pinecone/v4/pinecone"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const defaultPineconeIndex = "mindmenu-index"
+
+// pineconeIndexName returns the index configured by PINECONE_INDEX, defaulting to mindmenu-index
+func pineconeIndexName() string {
+	if name := os.Getenv("PINECONE_INDEX"); name != "" {
+		return name
+	}
+	return defaultPineconeIndex
+}
+
+// pineconeStore is the VectorStore backed by a Pinecone serverless index.
+// One gRPC connection to the index is opened at startup and shared by every namespace;
+// builds create a namespace per reindex, so a connection per namespace would leak.
+type pineconeStore struct {
+	index *pinecone.IndexConnection
+}
+
+// newPineconeStore connects to Pinecone and creates the index if it doesn't exist
+func newPineconeStore(ctx context.Context, apiKey, indexName string) (*pineconeStore, error) {
+	var err error
+	PineconeClient, err = pinecone.NewClient(pinecone.NewClientParams{
+		ApiKey: apiKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Pinecone client: %w", err)
+	}
+
+	idx, err := createPineconeIndex(ctx, PineconeClient, indexName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Pinecone index: %w", err)
+	}
+
+	index, err := PineconeClient.Index(pinecone.NewIndexConnParams{Host: idx.Host})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IndexConnection: %w", err)
+	}
+	return &pineconeStore{index: index}, nil
+}
+
+// createPineconeIndex describes the index, creating it first if it doesn't exist
+func createPineconeIndex(ctx context.Context, client *pinecone.Client, name string) (*pinecone.Index, error) {
+	idx, err := client.DescribeIndex(ctx, name)
+	if err == nil {
+		log.Printf("Index '%s' already exists", name)
+		return idx, nil
+	}
+
+	log.Printf("Creating Pinecone index '%s'...", name)
+
+	dimension := int32(768)
+	metric := pinecone.Cosine
+	idx, err = client.CreateServerlessIndex(ctx, &pinecone.CreateServerlessIndexRequest{
+		Name:      name,
+		Dimension: &dimension,
+		Metric:    &metric,
+		Cloud:     pinecone.Aws,
+		Region:    "us-east1",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index: %w", err)
+	}
+
+	log.Printf("Successfully created index '%s'", name)
+	return idx, nil
+}
+
+// conn returns a view of the shared index connection scoped to namespace
+func (s *pineconeStore) conn(namespace string) (*pinecone.IndexConnection, error) {
+	return s.index.WithNamespace(namespace), nil
+}
+
+func (s *pineconeStore) Upsert(ctx context.Context, namespace string, records []VectorRecord) error {
+	index, err := s.conn(namespace)
+	if err != nil {
+		return err
+	}
+
+	vectors := make([]*pinecone.Vector, 0, len(records))
+	for _, r := range records {
+		metadata, err := structpb.NewStruct(r.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to create metadata struct: %w", err)
+		}
+		values := r.Values
+		vectors = append(vectors, &pinecone.Vector{
+			Id:       r.ID,
+			Values:   &values,
+			Metadata: metadata,
+		})
+	}
+
+	for i := 0; i < len(vectors); i += 100 {
+		end := min(i+100, len(vectors))
+		if _, err := index.UpsertVectors(ctx, vectors[i:end]); err != nil {
+			return fmt.Errorf("failed to upsert vectors: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *pineconeStore) Fetch(ctx context.Context, namespace string, ids []string) (map[string]VectorRecord, error) {
+	result := make(map[string]VectorRecord, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	index, err := s.conn(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, batch := range batchStrings(ids, 100) {
+		fetchResp, err := index.FetchVectors(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch vectors: %w", err)
+		}
+		for id, vec := range fetchResp.Vectors {
+			result[id] = pineconeToRecord(vec)
+		}
+	}
+	return result, nil
+}
+
+func (s *pineconeStore) Delete(ctx context.Context, namespace string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	index, err := s.conn(namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batchStrings(ids, 100) {
+		if err := index.DeleteVectorsById(ctx, batch); err != nil {
+			return fmt.Errorf("failed to delete vectors: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *pineconeStore) Query(ctx context.Context, namespace string, vector []float32, topK int, filter map[string]interface{}) ([]VectorMatch, error) {
+	index, err := s.conn(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &pinecone.QueryByVectorValuesRequest{
+		Vector:          vector,
+		TopK:            uint32(topK),
+		IncludeMetadata: true,
+	}
+	if len(filter) > 0 {
+		metadataFilter, err := structpb.NewStruct(filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata filter: %w", err)
+		}
+		req.MetadataFilter = metadataFilter
+	}
+
+	queryResp, err := index.QueryByVectorValues(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Pinecone: %w", err)
+	}
+
+	matches := make([]VectorMatch, 0, len(queryResp.Matches))
+	for _, m := range queryResp.Matches {
+		if m.Vector == nil {
+			continue
+		}
+		matches = append(matches, VectorMatch{
+			VectorRecord: pineconeToRecord(m.Vector),
+			Score:        m.Score,
+		})
+	}
+	return matches, nil
+}
+
+func (s *pineconeStore) ListIDs(ctx context.Context, namespace string) ([]string, error) {
+	index, err := s.conn(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	limit := uint32(100)
+	var token *string
+	for {
+		resp, err := index.ListVectors(ctx, &pinecone.ListVectorsRequest{
+			Limit:           &limit,
+			PaginationToken: token,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list vectors: %w", err)
+		}
+		for _, id := range resp.VectorIds {
+			if id != nil {
+				ids = append(ids, *id)
+			}
+		}
+		if resp.NextPaginationToken == nil || *resp.NextPaginationToken == "" {
+			break
+		}
+		token = resp.NextPaginationToken
+	}
+	return ids, nil
+}
+
+func (s *pineconeStore) DeleteNamespace(ctx context.Context, namespace string) error {
+	index, err := s.conn(namespace)
+	if err != nil {
+		return err
+	}
+	if err := index.DeleteAllVectorsInNamespace(ctx); err != nil && !strings.Contains(strings.ToLower(err.Error()), "not found") {
+		return fmt.Errorf("failed to delete namespace %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// pineconeToRecord converts a Pinecone vector into a VectorRecord
+func pineconeToRecord(vec *pinecone.Vector) VectorRecord {
+	r := VectorRecord{ID: vec.Id}
+	if vec.Values != nil {
+		r.Values = *vec.Values
+	}
+	if vec.Metadata != nil {
+		r.Metadata = vec.Metadata.AsMap()
+	}
+	return r
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VersionIndexState tells whether the chatbot is answering from its active version.
+// OutOfDate is true when the active version has not been indexed yet, e.g. right after
+// an activation or while its reindex is still running.
+type VersionIndexState struct {
+	ActiveVersionID      string `json:"active_version_id"`
+	LastIndexedVersionID string `json:"last_indexed_version_id"`
+	OutOfDate            bool   `json:"out_of_date"`
+}
+
+func indexState(bot Chatbot) VersionIndexState {
+	return VersionIndexState{
+		ActiveVersionID:      bot.ActiveVersionID,
+		LastIndexedVersionID: bot.LastIndexedVersionID,
+		OutOfDate:            bot.ActiveVersionID != "" && bot.ActiveVersionID != bot.LastIndexedVersionID,
+	}
+}
+
+// loadChatbotVersion fetches a version, returning ErrNotFound if it belongs to another chatbot
+func loadChatbotVersion(ctx context.Context, chatbotID, versionID string) (ChatbotVersion, error) {
+	v, err := Versions.Get(ctx, versionID)
+	if err != nil {
+		return ChatbotVersion{}, err
+	}
+	if v.ChatbotID != chatbotID {
+		return ChatbotVersion{}, ErrNotFound
+	}
+	return v, nil
+}
+
+// bindChatbotVersion is loadChatbotVersion for handlers: it writes the error response and reports success
+func bindChatbotVersion(c *gin.Context, chatbotID, versionID string) (ChatbotVersion, bool) {
+	v, err := loadChatbotVersion(c.Request.Context(), chatbotID, versionID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found", "version_id": versionID})
+		return ChatbotVersion{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch version", "details": err.Error()})
+		return ChatbotVersion{}, false
+	}
+	return v, true
+}
+
+// ListChatbotVersions returns a chatbot's versions, newest first, with the index state
+func ListChatbotVersions(c *gin.Context) {
+	chatbotID := c.Param("chatbotId")
+	ctx := c.Request.Context()
+
+	bot, err := Chatbots.Get(ctx, chatbotID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
+		return
+	}
+	versions, err := Versions.List(ctx, chatbotID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list versions", "details": err.Error()})
+		return
+	}
+
+	type versionSummary struct {
+		ChatbotVersion
+		Active  bool `json:"active"`
+		Indexed bool `json:"indexed"`
+	}
+	out := make([]versionSummary, 0, len(versions))
+	for _, v := range versions {
+		out = append(out, versionSummary{
+			ChatbotVersion: v,
+			Active:         v.ID == bot.ActiveVersionID,
+			Indexed:        v.ID == bot.LastIndexedVersionID,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"versions":    out,
+		"count":       len(out),
+		"index_state": indexState(bot),
+	})
+}
+
+// GetChatbotVersion returns one version with its content
+func GetChatbotVersion(c *gin.Context) {
+	v, ok := bindChatbotVersion(c, c.Param("chatbotId"), c.Param("versionId"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"version": v})
+}
+
+// ActivateChatbotVersion makes a version the chatbot's active one. The chatbot keeps answering from
+// the indexed version until it is reindexed; pass {"reindex": true} to queue that immediately.
+func ActivateChatbotVersion(c *gin.Context) {
+	chatbotID := c.Param("chatbotId")
+	var body struct {
+		Reindex bool `json:"reindex"`
+	}
+	_ = c.ShouldBindJSON(&body) // accept empty
+
+	bot, err := Chatbots.Get(c.Request.Context(), chatbotID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
+		return
+	}
+	v, ok := bindChatbotVersion(c, chatbotID, c.Param("versionId"))
+	if !ok {
+		return
+	}
+	activateVersion(c, bot, v, body.Reindex)
+}
+
+// RollbackChatbotVersion re-activates an earlier version, switching straight back to its index
+// build while that is retained and reindexing it otherwise. Without a version_id it steps back to
+// the version created before the active one.
+func RollbackChatbotVersion(c *gin.Context) {
+	chatbotID := c.Param("chatbotId")
+	var body struct {
+		VersionID string `json:"version_id"`
+	}
+	_ = c.ShouldBindJSON(&body) // accept empty
+
+	ctx := c.Request.Context()
+	bot, err := Chatbots.Get(ctx, chatbotID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chatbot not found"})
+		return
+	}
+
+	targetID := body.VersionID
+	if targetID == "" {
+		if bot.ActiveVersionID == "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Chatbot has no active version to roll back from"})
+			return
+		}
+		versions, err := Versions.List(ctx, chatbotID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list versions", "details": err.Error()})
+			return
+		}
+		// versions are newest first, so the previous version follows the active one
+		for i, v := range versions {
+			if v.ID == bot.ActiveVersionID && i+1 < len(versions) {
+				targetID = versions[i+1].ID
+			}
+		}
+		if targetID == "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "No earlier version to roll back to"})
+			return
+		}
+	}
+
+	v, ok := bindChatbotVersion(c, chatbotID, targetID)
+	if !ok {
+		return
+	}
+	activateVersion(c, bot, v, true)
+}
+
+// activateVersion sets the active version and writes the response. With reindex it also brings the
+// index in line: a retained build of the version goes live at once, otherwise a reindex is queued.
+func activateVersion(c *gin.Context, bot Chatbot, v ChatbotVersion, reindex bool) {
+	ctx := c.Request.Context()
+	previous := bot.ActiveVersionID
+	if err := Chatbots.Update(ctx, bot.ID, map[string]interface{}{"active_version_id": v.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate version", "details": err.Error()})
+		return
+	}
+	bot.ActiveVersionID = v.ID
+
+	resp := gin.H{
+		"chatbot_id":          bot.ID,
+		"active_version_id":   v.ID,
+		"previous_version_id": previous,
+	}
+	// Nothing to do when the version is already what the index serves
+	if reindex && bot.LastIndexedVersionID != v.ID {
+		if build, err := retainedBuildFor(ctx, bot.ID, v.ID); err == nil {
+			if err := switchToBuild(ctx, bot, build, nil); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Version activated but switching builds failed", "details": err.Error(), "index_state": indexState(bot)})
+				return
+			}
+			bot.LastIndexedVersionID = v.ID
+			resp["build_id"] = build.ID
+			resp["index_state"] = indexState(bot)
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+		job, err := enqueueIndexJob(ctx, bot.ID, IndexJobPayload{BranchID: bot.BranchID, VersionID: v.ID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Version activated but reindex could not be queued", "details": err.Error(), "index_state": indexState(bot)})
+			return
+		}
+		resp["job_id"] = job.ID
+	}
+	resp["index_state"] = indexState(bot)
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Sheet limits: Excel's own (column XFD, row 1048576) for validating references, and a
+// narrower row width so a single far-right cell cannot make every row huge
+const (
+	xlsxMaxColumns  = 16384
+	xlsxMaxRows     = 1048576
+	maxXLSXRowWidth = 256
+)
+
+// readXLSXSheet returns the rows of one worksheet of an .xlsx workbook as strings.
+// sheetName selects a sheet by name; empty means the first sheet. Only cell values are read:
+// formulas yield their cached result and styles are ignored, which is all a menu import needs.
+func readXLSXSheet(data []byte, sheetName string) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("not an xlsx file: %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeZipXML(files, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("workbook has no sheets")
+	}
+
+	rid := workbook.Sheets[0].RID
+	if sheetName != "" {
+		rid = ""
+		for _, s := range workbook.Sheets {
+			if strings.EqualFold(s.Name, sheetName) {
+				rid = s.RID
+			}
+		}
+		if rid == "" {
+			return nil, fmt.Errorf("sheet %q not found", sheetName)
+		}
+	}
+
+	var rels struct {
+		Rels []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeZipXML(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	sheetPath := ""
+	for _, r := range rels.Rels {
+		if r.ID == rid {
+			sheetPath = r.Target
+		}
+	}
+	if sheetPath == "" {
+		return nil, fmt.Errorf("worksheet for sheet %s not found", rid)
+	}
+	if strings.HasPrefix(sheetPath, "/") {
+		sheetPath = strings.TrimPrefix(sheetPath, "/")
+	} else {
+		sheetPath = path.Join("xl", sheetPath)
+	}
+
+	// Shared strings are optional; workbooks with only numbers or inline strings omit them
+	var shared struct {
+		Items []xlsxRichText `xml:"si"`
+	}
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decodeZipXML(files, "xl/sharedStrings.xml", &shared); err != nil {
+			return nil, err
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Num   int `xml:"r,attr"`
+			Cells []struct {
+				Ref    string       `xml:"r,attr"`
+				Type   string       `xml:"t,attr"`
+				Value  string       `xml:"v"`
+				Inline xlsxRichText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodeZipXML(files, sheetPath, &sheet); err != nil {
+		return nil, err
+	}
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, r := range sheet.Rows {
+		// Blank rows are usually left out of the sheet; keep their place so row numbers match Excel's
+		if r.Num != 0 {
+			if r.Num <= len(rows) || r.Num > xlsxMaxRows {
+				return nil, fmt.Errorf("bad row number %d", r.Num)
+			}
+			for len(rows) < r.Num-1 {
+				rows = append(rows, nil)
+			}
+		}
+		var row []string
+		for i, c := range r.Cells {
+			col := i
+			if c.Ref != "" {
+				var err error
+				if col, err = xlsxColumnIndex(c.Ref); err != nil {
+					return nil, err
+				}
+			}
+			if col >= maxXLSXRowWidth {
+				return nil, fmt.Errorf("cell %s: only the first %d columns can be imported", c.Ref, maxXLSXRowWidth)
+			}
+			var value string
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(c.Value)
+				if err != nil || idx < 0 || idx >= len(shared.Items) {
+					return nil, fmt.Errorf("cell %s: bad shared string index %q", c.Ref, c.Value)
+				}
+				value = shared.Items[idx].String()
+			case "inlineStr":
+				value = c.Inline.String()
+			case "b":
+				value = map[string]string{"0": "false", "1": "true"}[c.Value]
+			default:
+				value = c.Value
+			}
+			for len(row) <= col {
+				row = append(row, "")
+			}
+			row[col] = value
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// xlsxRichText is a string item: plain <t> or rich-text runs <r><t>
+type xlsxRichText struct {
+	Text string   `xml:"t"`
+	Runs []string `xml:"r>t"`
+}
+
+func (t xlsxRichText) String() string {
+	if len(t.Runs) > 0 {
+		return strings.Join(t.Runs, "")
+	}
+	return t.Text
+}
+
+// xlsxColumnIndex converts a cell reference such as "C12" or "AA3" to a zero-based column
+func xlsxColumnIndex(ref string) (int, error) {
+	col, letters := 0, 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		letters++
+	}
+	if letters == 0 || letters > 3 || col > xlsxMaxColumns {
+		return 0, fmt.Errorf("bad cell reference %q", ref)
+	}
+	return col - 1, nil
+}
+
+func decodeZipXML(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("xlsx is missing %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer rc.Close()
+	// Worksheets can be large; cap what we read so a zip bomb cannot exhaust memory
+	if err := xml.NewDecoder(io.LimitReader(rc, 64<<20)).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// testXLSX builds a one-sheet workbook whose sheetData is rowsXML, with inline strings only
+func testXLSX(t *testing.T, rowsXML string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="Menu" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml":   `<worksheet><sheetData>` + rowsXML + `</sheetData></worksheet>`,
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func inlineCell(ref, text string) string {
+	return `<c r="` + ref + `" t="inlineStr"><is><t>` + text + `</t></is></c>`
+}
+
+func TestReadXLSXSheetKeepsRowNumbers(t *testing.T) {
+	data := testXLSX(t,
+		`<row r="2">`+inlineCell("A2", "name")+inlineCell("B2", "price")+`</row>`+
+			`<row r="5">`+inlineCell("A5", "Sate")+inlineCell("C5", "spicy")+`</row>`+
+			`<row>`+inlineCell("B6", "oops")+`</row>`)
+	rows, err := readXLSXSheet(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{nil, {"name", "price"}, nil, nil, {"Sate", "", "spicy"}, {"", "oops"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+
+	// Errors point at the spreadsheet's own row numbers
+	result, err := importMenuRows(rows, ColumnMapping{}, "IDR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Row != 6 {
+		t.Errorf("errors = %+v, want one on row 6", result.Errors)
+	}
+}
+
+func TestReadXLSXSheetRejectsBadReferences(t *testing.T) {
+	tests := map[string]string{
+		"four letters":       `<row r="1">` + inlineCell("AAAA1", "x") + `</row>`,
+		"past XFD":           `<row r="1">` + inlineCell("XFE1", "x") + `</row>`,
+		"no column":          `<row r="1">` + inlineCell("12", "x") + `</row>`,
+		"wider than the cap": `<row r="1">` + inlineCell("IW1", "x") + `</row>`,
+		"row out of order":   `<row r="3"></row><row r="2"></row>`,
+		"row past the sheet": `<row r="1048577"></row>`,
+	}
+	for name, rowsXML := range tests {
+		if _, err := readXLSXSheet(testXLSX(t, rowsXML), ""); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	// The last columns inside the limits are fine
+	rows, err := readXLSXSheet(testXLSX(t, `<row r="1">`+inlineCell("IV1", "last")+`</row>`), "")
+	if err != nil || len(rows[0]) != maxXLSXRowWidth || rows[0][maxXLSXRowWidth-1] != "last" {
+		t.Errorf("column IV: %v", err)
+	}
+	if col, err := xlsxColumnIndex("XFD1048576"); err != nil || col != xlsxMaxColumns-1 {
+		t.Errorf("XFD = %d, %v", col, err)
+	}
+}